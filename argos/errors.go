@@ -13,4 +13,6 @@ var (
 	ErrPeerHalted = errors.New("peer spinning halted")
 	// ErrPeerNotRunning means the peer not running
 	ErrPeerNotRunning = errors.New("peer not running")
+	// ErrNoSeeds means a seed provider has no node to provide
+	ErrNoSeeds = errors.New("no seed nodes")
 )
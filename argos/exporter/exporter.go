@@ -0,0 +1,208 @@
+// Package exporter serves the metrics of a go-metrics registry in the Prometheus text exposition format.
+//
+// go-metrics has no labels, so labels are encoded in the names of the metrics by Name, e.g.
+// master.reports{method="FTE",protocol="bitcoin"}, and the metrics sharing the name before the labels
+// are exported as a single family.
+package exporter
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+// ContentType is the content type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// quantiles are the quantiles exported for histograms and timers
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Name returns the name registering the metric with the labels, labels are pairs of label names and values
+func Name(name string, labels ...string) string {
+	if len(labels) < 2 {
+		return name
+	}
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(sanitize(labels[i]))
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Parse splits the name registering a metric into the name and the labels given to Name, labels is nil when
+// the metric has none
+func Parse(registered string) (string, map[string]string) {
+	i := strings.IndexByte(registered, '{')
+	if i < 0 || !strings.HasSuffix(registered, "}") {
+		return registered, nil
+	}
+	name, rest := registered[:i], registered[i+1:len(registered)-1]
+
+	labels := make(map[string]string)
+	for rest != "" {
+		eq := strings.Index(rest, `="`)
+		if eq < 0 {
+			break
+		}
+		key := rest[:eq]
+		rest = rest[eq+2:]
+
+		var value strings.Builder
+		for len(rest) > 0 && rest[0] != '"' {
+			if rest[0] == '\\' && len(rest) > 1 {
+				rest = rest[1:]
+				if rest[0] == 'n' {
+					value.WriteByte('\n')
+					rest = rest[1:]
+					continue
+				}
+			}
+			value.WriteByte(rest[0])
+			rest = rest[1:]
+		}
+		labels[key] = value.String()
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, `"`), ",")
+	}
+	return name, labels
+}
+
+// sanitize replaces the characters not allowed in metric and label names by underscores
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// split separates the registered name into the metric name and its labels without braces
+func split(name string) (string, string) {
+	if i := strings.IndexByte(name, '{'); i >= 0 && strings.HasSuffix(name, "}") {
+		return sanitize(name[:i]), name[i+1 : len(name)-1]
+	}
+	return sanitize(name), ""
+}
+
+// family is the samples of a metric name
+type family struct {
+	name    string
+	kind    string
+	samples []string
+}
+
+// families collects the samples of the registry by metric name
+type families map[string]*family
+
+func (f families) add(name, kind, suffix, labels string, value string) {
+	fam, ok := f[name]
+	if !ok {
+		fam = &family{name: name, kind: kind}
+		f[name] = fam
+	}
+	var sample = name + suffix
+	if labels != "" {
+		sample += "{" + labels + "}"
+	}
+	fam.samples = append(fam.samples, sample+" "+value)
+}
+
+// summary adds the quantiles, sum and count of a distribution, the values are scaled by scale
+func (f families) summary(name, labels string, ps []float64, sum int64, count int64, scale float64) {
+	for i, q := range quantiles {
+		var quantile = `quantile="` + formatFloat(q) + `"`
+		if labels != "" {
+			quantile = labels + "," + quantile
+		}
+		f.add(name, "summary", "", quantile, formatFloat(ps[i]*scale))
+	}
+	f.add(name, "summary", "_sum", labels, formatFloat(float64(sum)*scale))
+	f.add(name, "summary", "_count", labels, strconv.FormatInt(count, 10))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// counterName appends the conventional _total suffix of counters
+func counterName(name string) string {
+	if strings.HasSuffix(name, "_total") {
+		return name
+	}
+	return name + "_total"
+}
+
+// Write writes the metrics of the registry in the text exposition format. Counters and meters are exported
+// as counters, gauges as gauges, histograms as summaries and timers as summaries in seconds.
+func Write(w io.Writer, r metrics.Registry) error {
+	var registered []string
+	r.Each(func(name string, i interface{}) {
+		registered = append(registered, name)
+	})
+	// the metrics are sorted, so the samples of a family are in the order of their labels
+	sort.Strings(registered)
+
+	f := make(families)
+	for _, reg := range registered {
+		name, labels := split(reg)
+		switch m := r.Get(reg).(type) {
+		case metrics.Counter:
+			f.add(counterName(name), "counter", "", labels, strconv.FormatInt(m.Count(), 10))
+		case metrics.Gauge:
+			f.add(name, "gauge", "", labels, strconv.FormatInt(m.Value(), 10))
+		case metrics.GaugeFloat64:
+			f.add(name, "gauge", "", labels, formatFloat(m.Value()))
+		case metrics.Meter:
+			f.add(counterName(name), "counter", "", labels, strconv.FormatInt(m.Snapshot().Count(), 10))
+		case metrics.Histogram:
+			h := m.Snapshot()
+			f.summary(name, labels, h.Percentiles(quantiles), h.Sum(), h.Count(), 1)
+		case metrics.Timer:
+			t := m.Snapshot()
+			f.summary(name+"_seconds", labels, t.Percentiles(quantiles), t.Sum(), t.Count(), 1e-9)
+		}
+	}
+
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		fam := f[name]
+		bw.WriteString("# TYPE " + fam.name + " " + fam.kind + "\n")
+		for _, sample := range fam.samples {
+			bw.WriteString(sample + "\n")
+		}
+	}
+	return bw.Flush()
+}
+
+// Handler serves the metrics of the registry, metrics.DefaultRegistry is served when it is nil
+func Handler(r metrics.Registry) http.Handler {
+	if r == nil {
+		r = metrics.DefaultRegistry
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_ = Write(w, r)
+	})
+}
@@ -0,0 +1,65 @@
+package exporter
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestName(t *testing.T) {
+	assert.Equal(t, "reports", Name("reports"))
+	assert.Equal(t, `reports{method="FTE",protocol="bitcoin"}`, Name("reports", "method", "FTE", "protocol", "bitcoin"))
+	assert.Equal(t, `reports{sniffer="a\"b\\c"}`, Name("reports", "sniffer", `a"b\c`))
+}
+
+func TestParse(t *testing.T) {
+	name, labels := Parse("reports")
+	assert.Equal(t, "reports", name)
+	assert.Nil(t, labels)
+
+	name, labels = Parse(Name("master.reports", "method", "FTE", "sniffer", "a\"b\\c,\nd"))
+	assert.Equal(t, "master.reports", name)
+	assert.Equal(t, map[string]string{"method": "FTE", "sniffer": "a\"b\\c,\nd"}, labels)
+}
+
+func TestWrite(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterCounter(Name("master.reports", "method", "RCE"), r).Inc(2)
+	metrics.GetOrRegisterCounter(Name("master.reports", "method", "FTE"), r).Inc(3)
+	metrics.GetOrRegisterGauge("sniffer.peers", r).Update(8)
+	metrics.GetOrRegisterMeter("report", r).Mark(5)
+	timer := metrics.GetOrRegisterTimer(Name("master.db.latency", "operation", "create"), r)
+	timer.Update(2 * time.Millisecond)
+	timer.Update(4 * time.Millisecond)
+
+	var b bytes.Buffer
+	assert.Nil(t, Write(&b, r))
+	assert.Equal(t, `# TYPE master_db_latency_seconds summary
+master_db_latency_seconds{operation="create",quantile="0.5"} 0.003
+master_db_latency_seconds{operation="create",quantile="0.9"} 0.004
+master_db_latency_seconds{operation="create",quantile="0.99"} 0.004
+master_db_latency_seconds_sum{operation="create"} 0.006
+master_db_latency_seconds_count{operation="create"} 2
+# TYPE master_reports_total counter
+master_reports_total{method="FTE"} 3
+master_reports_total{method="RCE"} 2
+# TYPE report_total counter
+report_total 5
+# TYPE sniffer_peers gauge
+sniffer_peers 8
+`, b.String())
+}
+
+func TestHandler(t *testing.T) {
+	r := metrics.NewRegistry()
+	metrics.GetOrRegisterGauge("sniffer.peers", r).Update(1)
+
+	w := httptest.NewRecorder()
+	Handler(r).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "# TYPE sniffer_peers gauge\nsniffer_peers 1\n", w.Body.String())
+}
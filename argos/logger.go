@@ -2,9 +2,14 @@ package argos
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/cloudwego/kitex/pkg/klog"
+	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,6 +29,28 @@ func StandardLogger() *logrus.Logger {
 	return logger
 }
 
+// NewLogger creates the logger writing to the log file named by name in the directory, and to the stderr
+func NewLogger(dir, name, level string) (*logrus.Logger, error) {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(lvl)
+	logger.AddHook(lfshook.NewHook(
+		filepath.Join(dir, fmt.Sprintf("%s%s.log", time.Now().Format(time.RFC3339), name)),
+		&logrus.TextFormatter{
+			FullTimestamp: true,
+			DisableColors: true,
+		},
+	))
+	return logger, nil
+}
+
 func WarpLogger(logger *logrus.Logger) *WarppedLogger {
 	return &WarppedLogger{
 		logger: logger,
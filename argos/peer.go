@@ -5,6 +5,24 @@ import (
 	"time"
 )
 
+// Direction describes which side initiated the connection to a peer
+type Direction uint8
+
+const (
+	// Outbound means the connection was initiated by the sniffer
+	Outbound Direction = iota
+	// Inbound means the connection was initiated by the remote node
+	Inbound
+)
+
+// String implements fmt.Stringer
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
 // TransactionNotify represents an abstract transaction which has been
 type TransactionNotify struct {
 	// Source is the source where the current node get notified
@@ -14,6 +32,20 @@ type TransactionNotify struct {
 	// TxID is the re-hashed abstract representation of an abstract transaction, which can be computed by real
 	// implementation-related cryptocurrency transaction ids
 	TxID [32]byte
+	// OfferedTxRcncl marks that the source offered transaction reconciliation (e.g. BIP330 Erlay), so it may
+	// announce fewer transactions by plain inventory messages and its timestamps may bias the estimators. The
+	// offer is only recorded, reconciliation is not negotiated with the source.
+	OfferedTxRcncl bool
+	// Direction is the direction of the connection to the source, inbound sources connected to the sniffer by
+	// themselves and usually relay transactions to it with less delay
+	Direction Direction
+}
+
+// NodeAddress is the address of a node announced by another node
+type NodeAddress struct {
+	Address net.TCPAddr
+	// Services is the implementation-related bitfield of features the node announced to support
+	Services uint64
 }
 
 // Peer is an interface that describes the behaviour of an abstract cryptocurrency peer in argos system
@@ -1,13 +1,19 @@
 package argos
 
-import "net"
+import (
+	"net"
+
+	"github.com/cloudwego/netpoll"
+)
 
 type PeerConstructor func(s Sniffer, addr *net.TCPAddr) Peer
+type InboundPeerConstructor func(s Sniffer, conn netpoll.Connection) Peer
 type SeedProvider func() ([]net.TCPAddr, error)
 type RandomRemoteAddressProvider func() (*net.TCPAddr, error)
 
 var (
 	constructors                 = make(map[string]PeerConstructor)
+	inboundConstructors          = make(map[string]InboundPeerConstructor)
 	seedProviders                = make(map[string]SeedProvider)
 	randomRemoteAddressProviders = make(map[string]RandomRemoteAddressProvider)
 )
@@ -16,6 +22,10 @@ func RegisterPeerConstructor(name string, constructor PeerConstructor) {
 	constructors[name] = constructor
 }
 
+func RegisterInboundPeerConstructor(name string, constructor InboundPeerConstructor) {
+	inboundConstructors[name] = constructor
+}
+
 func RegisterSeedProvider(name string, provider SeedProvider) {
 	seedProviders[name] = provider
 }
@@ -31,6 +41,14 @@ func NewPeer(protocol string, addr *net.TCPAddr, s Sniffer) (Peer, error) {
 	return nil, ErrProtocolNotImplemented
 }
 
+// NewInboundPeer creates a peer on a connection accepted from the remote node
+func NewInboundPeer(protocol string, conn netpoll.Connection, s Sniffer) (Peer, error) {
+	if ctor, ok := inboundConstructors[protocol]; ok {
+		return ctor(s, conn), nil
+	}
+	return nil, ErrProtocolNotImplemented
+}
+
 func GetSeedNodes(protocol string) ([]net.TCPAddr, error) {
 	if provider, ok := seedProviders[protocol]; ok {
 		return provider()
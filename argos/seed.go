@@ -0,0 +1,124 @@
+package argos
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// StaticSeedProvider returns a SeedProvider which always provides the given nodes
+func StaticSeedProvider(nodes []net.TCPAddr) SeedProvider {
+	return func() ([]net.TCPAddr, error) {
+		if len(nodes) == 0 {
+			return nil, ErrNoSeeds
+		}
+		result := make([]net.TCPAddr, len(nodes))
+		copy(result, nodes)
+		return result, nil
+	}
+}
+
+// ParseSeed parses a seed node in "ip:port" or "[ipv6]:port" form, a plain ip uses the default port
+func ParseSeed(s string, defaultPort int) (net.TCPAddr, error) {
+	if ip := net.ParseIP(strings.Trim(s, "[]")); ip != nil {
+		return net.TCPAddr{IP: ip, Port: defaultPort}, nil
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return net.TCPAddr{}, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return net.TCPAddr{}, fmt.Errorf("seed host `%s` is not an ip address", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return net.TCPAddr{}, fmt.Errorf("seed port `%s` invalid: %w", port, err)
+	}
+	return net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// ParseSeeds parses one seed node per line by ParseSeed, empty lines, comments starting with '#' and
+// the hosts which are not ip addresses (e.g. onion addresses in the seed lists of bitcoin core) are skipped
+func ParseSeeds(r io.Reader, defaultPort int) ([]net.TCPAddr, error) {
+	var nodes []net.TCPAddr
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		if node, err := ParseSeed(line, defaultPort); err == nil {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, scanner.Err()
+}
+
+// FileSeedProvider returns a SeedProvider reading the nodes from the file by ParseSeeds, the file is read
+// every time the provider is called so it could be edited while running
+func FileSeedProvider(path string, defaultPort int) SeedProvider {
+	return func() ([]net.TCPAddr, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		nodes, err := ParseSeeds(f, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) == 0 {
+			return nil, ErrNoSeeds
+		}
+		return nodes, nil
+	}
+}
+
+// CompositeSeedProvider returns a SeedProvider merging the nodes of all the given providers without duplicates,
+// failed providers are logged and skipped, so it only fails when every provider fails
+func CompositeSeedProvider(providers ...SeedProvider) SeedProvider {
+	return func() ([]net.TCPAddr, error) {
+		var err error
+		var nodes []net.TCPAddr
+		var seen = make(map[string]struct{})
+		var succeeded = false
+
+		for _, provider := range providers {
+			var result []net.TCPAddr
+			if result, err = provider(); err != nil {
+				StandardLogger().WithError(err).Warn("seed provider failed")
+				continue
+			}
+			succeeded = true
+			for _, node := range result {
+				key := string(node.IP.To16()) + strconv.Itoa(node.Port)
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				nodes = append(nodes, node)
+			}
+		}
+
+		if !succeeded {
+			if err == nil {
+				err = ErrNoSeeds
+			}
+			return nil, fmt.Errorf("all seed providers failed, last error: %w", err)
+		}
+		if len(nodes) == 0 {
+			return nil, ErrNoSeeds
+		}
+		return nodes, nil
+	}
+}
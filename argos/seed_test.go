@@ -0,0 +1,82 @@
+package argos
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeeds(t *testing.T) {
+	nodes, err := ParseSeeds(strings.NewReader(`
+# comment
+192.0.2.1:8333
+192.0.2.2            # default port
+[2001:db8::1]:18333
+2001:db8::2
+kpgvmscirrdqpekbqjsvw5teanhatztpp2gl6eee4zkowvwfxwenqaid.onion:8333
+192.0.2.3:notaport
+`), 8333)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"192.0.2.1:8333",
+		"192.0.2.2:8333",
+		"[2001:db8::1]:18333",
+		"[2001:db8::2]:8333",
+	}, addrStrings(nodes))
+}
+
+func TestFileSeedProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seeds.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("192.0.2.1\n"), 0644))
+
+	nodes, err := FileSeedProvider(path, 8333)()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"192.0.2.1:8333"}, addrStrings(nodes))
+
+	assert.Nil(t, os.WriteFile(path, []byte("# nothing\n"), 0644))
+	_, err = FileSeedProvider(path, 8333)()
+	assert.Equal(t, ErrNoSeeds, err)
+
+	_, err = FileSeedProvider(filepath.Join(t.TempDir(), "missing.txt"), 8333)()
+	assert.NotNil(t, err)
+}
+
+func TestCompositeSeedProvider(t *testing.T) {
+	var failed = errors.New("seed failed")
+	var failing SeedProvider = func() ([]net.TCPAddr, error) {
+		return nil, failed
+	}
+	a := StaticSeedProvider([]net.TCPAddr{
+		{IP: net.IPv4(192, 0, 2, 1), Port: 8333},
+		{IP: net.IPv4(192, 0, 2, 2), Port: 8333},
+	})
+	b := StaticSeedProvider([]net.TCPAddr{
+		// the same node parsed into the 4 bytes form
+		{IP: net.ParseIP("192.0.2.2").To4(), Port: 8333},
+		{IP: net.IPv4(192, 0, 2, 2), Port: 18333},
+	})
+
+	// partial failures are tolerated and duplicates are removed
+	nodes, err := CompositeSeedProvider(failing, a, failing, b)()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"192.0.2.1:8333", "192.0.2.2:8333", "192.0.2.2:18333"}, addrStrings(nodes))
+
+	_, err = CompositeSeedProvider(failing, failing)()
+	assert.True(t, errors.Is(err, failed))
+
+	_, err = CompositeSeedProvider()()
+	assert.True(t, errors.Is(err, ErrNoSeeds))
+}
+
+func addrStrings(nodes []net.TCPAddr) []string {
+	var result []string
+	for _, node := range nodes {
+		result = append(result, node.String())
+	}
+	return result
+}
@@ -18,7 +18,7 @@ func Serialize(w netpoll.Writer, data any) (int, error) {
 	return SerializeWithEndian(w, data, binary.LittleEndian)
 }
 
-// Serialize using builtin basic type memory binary representations and given `order``
+// Serialize using builtin basic type memory binary representations and given `order“
 // to serialize the given `data` object pointer into binary reading stream.
 // It should be noticed the `data` must be a pointer when a slice's corresponding size field
 // was not set to the slice's length.
@@ -11,8 +11,15 @@ type Sniffer interface {
 	Logger() *logrus.Logger
 	NotifyTransaction(notify TransactionNotify)
 	Connect(address net.TCPAddr)
-	NodeConn(src net.TCPAddr, conn []net.TCPAddr)
+	// Listen accepts inbound connections on the given address, it blocks until the listener fails
+	Listen(address string) error
+	NodeConn(src net.TCPAddr, conn []NodeAddress)
 	NodeExit(address net.TCPAddr)
 	Spin(node net.TCPAddr)
 	Halt()
 }
+
+// HandshakeNotifier is implemented by the sniffers wanting to know when a peer completed its handshake
+type HandshakeNotifier interface {
+	NotifyHandshake(address net.TCPAddr)
+}
@@ -0,0 +1,18 @@
+package argos
+
+import "runtime/debug"
+
+// Version describes the running binary by its module version, vcs revision and go version
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	version := info.Main.Version
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" && len(setting.Value) >= 12 {
+			version += " " + setting.Value[:12]
+		}
+	}
+	return version + " " + info.GoVersion
+}
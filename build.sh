@@ -1,8 +1,7 @@
-cp -n master/config/config.example master/config/config.go
-
 sh kitexgen.sh
 
 mkdir -p output
+cp -n master/config/master.example.json output/master.json
 
 
 mkdir -p sniffer/output
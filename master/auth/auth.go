@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/base"
+	"github.com/apache/thrift/lib/go/thrift"
+)
+
+const (
+	// DefaultWindow is the clock difference tolerated between the sniffers and the master,
+	// nonces are remembered for the same time to reject replayed requests
+	DefaultWindow = 5 * time.Minute
+	// NonceSize is the size of the random nonce in each request
+	NonceSize = 16
+)
+
+var (
+	// ErrMissingAuth means the request carries no auth
+	ErrMissingAuth = errors.New("request not authenticated")
+	// ErrExpired means the request was signed too long ago or too far in the future
+	ErrExpired = errors.New("request timestamp out of window")
+	// ErrBadMAC means the request was not signed by the shared key or was modified
+	ErrBadMAC = errors.New("request mac mismatch")
+	// ErrReplayed means the nonce of the request has been seen
+	ErrReplayed = errors.New("request replayed")
+)
+
+// digest computes the HMAC of the request serialized with an empty mac, so the mac covers every field of
+// the request including the timestamp and nonce of its auth
+func digest(key []byte, req thrift.TStruct, auth *base.Auth) ([]byte, error) {
+	mac := auth.Mac
+	auth.Mac = nil
+	defer func() {
+		auth.Mac = mac
+	}()
+
+	data, err := thrift.NewTSerializer().Write(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// Sign authenticates the request by the key, auth should be the auth field of the request
+func Sign(key []byte, req thrift.TStruct, auth *base.Auth) error {
+	auth.Timestamp = time.Now().UnixNano()
+	auth.Nonce = make([]byte, NonceSize)
+	if _, err := rand.Read(auth.Nonce); err != nil {
+		return err
+	}
+
+	mac, err := digest(key, req, auth)
+	if err != nil {
+		return err
+	}
+	auth.Mac = mac
+	return nil
+}
+
+// SignResponse authenticates the response by the key, auth should be the auth field of the response and
+// request the auth of the request it answers. The response carries the nonce of the request instead of its
+// own, so it cannot be replayed as the response to another request.
+func SignResponse(key []byte, resp thrift.TStruct, auth, request *base.Auth) error {
+	if request == nil {
+		return ErrMissingAuth
+	}
+	auth.Timestamp = time.Now().UnixNano()
+	auth.Nonce = append([]byte(nil), request.Nonce...)
+
+	mac, err := digest(key, resp, auth)
+	if err != nil {
+		return err
+	}
+	auth.Mac = mac
+	return nil
+}
+
+// VerifyResponse checks the response is signed by the key for the request, auth should be the auth field of
+// the response and request the auth the request was signed with
+func VerifyResponse(key []byte, resp thrift.TStruct, auth, request *base.Auth) error {
+	if auth == nil || len(auth.Mac) == 0 {
+		return ErrMissingAuth
+	}
+	if request == nil || len(request.Nonce) == 0 || !bytes.Equal(auth.Nonce, request.Nonce) {
+		return ErrReplayed
+	}
+
+	mac, err := digest(key, resp, auth)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, auth.Mac) {
+		return ErrBadMAC
+	}
+	return nil
+}
+
+// Verifier checks the requests are signed by the shared key and not replayed
+type Verifier struct {
+	key    []byte
+	window time.Duration
+	now    func() time.Time
+	nonces map[string]time.Time
+	pruned time.Time
+	mu     sync.Mutex
+}
+
+// NewVerifier creates a verifier of the key tolerating the clock difference of window
+func NewVerifier(key []byte, window time.Duration) *Verifier {
+	return &Verifier{
+		key:    key,
+		window: window,
+		now:    time.Now,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// Verify checks the request, auth should be the auth field of the request
+func (v *Verifier) Verify(req thrift.TStruct, auth *base.Auth) error {
+	if auth == nil || len(auth.Mac) == 0 {
+		return ErrMissingAuth
+	}
+
+	now := v.now()
+	signed := time.Unix(0, auth.Timestamp)
+	if signed.Before(now.Add(-v.window)) || signed.After(now.Add(v.window)) {
+		return ErrExpired
+	}
+
+	mac, err := digest(v.key, req, auth)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, auth.Mac) {
+		return ErrBadMAC
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.prune(now)
+	nonce := string(auth.Nonce)
+	if _, ok := v.nonces[nonce]; ok {
+		return ErrReplayed
+	}
+	// the nonce is remembered until the request falls out of the window
+	v.nonces[nonce] = signed.Add(v.window)
+	return nil
+}
+
+// prune forgets the nonces out of the window, it runs at most every tenth of the window
+func (v *Verifier) prune(now time.Time) {
+	if now.Sub(v.pruned) < v.window/10 {
+		return
+	}
+	v.pruned = now
+	for nonce, expiry := range v.nonces {
+		if expiry.Before(now) {
+			delete(v.nonces, nonce)
+		}
+	}
+}
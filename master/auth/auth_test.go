@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/base"
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/master"
+	"github.com/stretchr/testify/assert"
+)
+
+func signedPing(t *testing.T, key []byte) *master.PingRequest {
+	req := &master.PingRequest{
+		Identifier: "hubei-SIp7m1Lkc4",
+		Timestamp:  time.Now().UnixNano(),
+		Auth:       &base.Auth{},
+	}
+	assert.Nil(t, Sign(key, req, req.Auth))
+	return req
+}
+
+func TestVerify(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	v := NewVerifier(key, DefaultWindow)
+
+	req := signedPing(t, key)
+	assert.Nil(t, v.Verify(req, req.Auth))
+	// the mac is kept after verifying
+	assert.Equal(t, 32, len(req.Auth.Mac))
+
+	// the same request is rejected when it is sent again
+	assert.Equal(t, ErrReplayed, v.Verify(req, req.Auth))
+
+	// any modification of the request breaks the mac
+	req = signedPing(t, key)
+	req.Identifier = "hubei-another"
+	assert.Equal(t, ErrBadMAC, v.Verify(req, req.Auth))
+
+	req = signedPing(t, []byte("another key"))
+	assert.Equal(t, ErrBadMAC, v.Verify(req, req.Auth))
+
+	req = signedPing(t, key)
+	req.Auth.Timestamp++
+	assert.Equal(t, ErrBadMAC, v.Verify(req, req.Auth))
+
+	req = &master.PingRequest{Identifier: "hubei-SIp7m1Lkc4"}
+	assert.Equal(t, ErrMissingAuth, v.Verify(req, req.Auth))
+}
+
+func TestVerifyResponse(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	req := signedPing(t, key)
+
+	resp := &master.PingResponse{Protocol: "bitcoin", Auth: &base.Auth{}}
+	assert.Nil(t, SignResponse(key, resp, resp.Auth, req.Auth))
+	assert.Nil(t, VerifyResponse(key, resp, resp.Auth, req.Auth))
+
+	// the response to another request is rejected
+	assert.Equal(t, ErrReplayed, VerifyResponse(key, resp, resp.Auth, signedPing(t, key).Auth))
+
+	resp.Protocol = "another"
+	assert.Equal(t, ErrBadMAC, VerifyResponse(key, resp, resp.Auth, req.Auth))
+	assert.Equal(t, ErrBadMAC, VerifyResponse([]byte("another key"), resp, resp.Auth, req.Auth))
+
+	assert.Equal(t, ErrMissingAuth, VerifyResponse(key, &master.PingResponse{}, nil, req.Auth))
+}
+
+func TestVerifyWindow(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	v := NewVerifier(key, time.Minute)
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	req := signedPing(t, key)
+	v.now = func() time.Time { return now.Add(2 * time.Minute) }
+	assert.Equal(t, ErrExpired, v.Verify(req, req.Auth))
+	v.now = func() time.Time { return now.Add(-2 * time.Minute) }
+	assert.Equal(t, ErrExpired, v.Verify(req, req.Auth))
+
+	// nonces are forgotten once their requests are out of the window
+	v.now = func() time.Time { return now }
+	assert.Nil(t, v.Verify(req, req.Auth))
+	assert.Equal(t, 1, len(v.nonces))
+	v.prune(now.Add(3 * time.Minute))
+	assert.Empty(t, v.nonces)
+}
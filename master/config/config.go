@@ -0,0 +1,155 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// the environment variables overriding the config file
+const (
+	EnvDbDriver        = "ARGOS_DB_DRIVER"
+	EnvDbConnDsn       = "ARGOS_DB_DSN"
+	EnvRpcListenAddr   = "ARGOS_RPC_ADDR"
+	EnvWebListenAddr   = "ARGOS_WEB_ADDR"
+	EnvLogDir          = "ARGOS_LOG_DIR"
+	EnvHMACKey         = "ARGOS_HMAC_KEY"
+	EnvMetricsInterval = "ARGOS_METRICS_INTERVAL"
+	EnvMetricsHistory  = "ARGOS_METRICS_HISTORY"
+)
+
+var (
+	// ErrUnknownDriver means the database driver is not supported
+	ErrUnknownDriver = errors.New("unknown database driver")
+	// ErrMissingDsn means the data source name of the database is not configured
+	ErrMissingDsn = errors.New("database dsn not configured")
+	// ErrInvalidMetrics means the metrics interval or history is not positive
+	ErrInvalidMetrics = errors.New("metrics interval and history should be positive")
+)
+
+// Config is the config of the master, it is loaded from a json file and overridden by the environment
+type Config struct {
+	// DbDriver is the database of the master: mysql, postgres or sqlite, DbConnDsn is its dsn, e.g.
+	// "host=ip user=user password=passwd dbname=argos port=5432" for postgres or "argos.db" for sqlite
+	DbDriver  string `json:"db_driver"`
+	DbConnDsn string `json:"db_dsn"`
+	// RpcListenAddr is the address serving the sniffers, WebListenAddr is the address of the web api
+	RpcListenAddr string `json:"rpc_listen_address"`
+	WebListenAddr string `json:"web_listen_address"`
+	// LogDir is the directory the log files are written to
+	LogDir string `json:"log_dir"`
+	// HMACKey is the key shared with the sniffers to sign the requests and the answers to pings, the messages are
+	// authenticated but not encrypted. Signing is disabled when it is empty.
+	HMACKey string `json:"hmac_key"`
+	// MetricsInterval is the seconds between the samples of the metrics history, MetricsHistory is the
+	// number of samples kept at that interval
+	MetricsInterval int `json:"metrics_interval"`
+	MetricsHistory  int `json:"metrics_history"`
+}
+
+// Default returns the config used when neither the file nor the environment sets a field
+func Default() *Config {
+	return &Config{
+		DbDriver:        DriverMySQL,
+		RpcListenAddr:   "localhost:4222",
+		WebListenAddr:   ":8080",
+		LogDir:          "logs",
+		MetricsInterval: 60,
+		MetricsHistory:  60,
+	}
+}
+
+// Load reads the config file at path over the defaults, applies the environment and validates the result,
+// only the defaults and the environment are used when path is empty
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return c, c.Validate()
+}
+
+// applyEnv overrides the fields set by the environment
+func (c *Config) applyEnv(lookup func(key string) (string, bool)) error {
+	for key, field := range map[string]*string{
+		EnvDbDriver:      &c.DbDriver,
+		EnvDbConnDsn:     &c.DbConnDsn,
+		EnvRpcListenAddr: &c.RpcListenAddr,
+		EnvWebListenAddr: &c.WebListenAddr,
+		EnvLogDir:        &c.LogDir,
+		EnvHMACKey:       &c.HMACKey,
+	} {
+		if value, ok := lookup(key); ok {
+			*field = value
+		}
+	}
+
+	for key, field := range map[string]*int{
+		EnvMetricsInterval: &c.MetricsInterval,
+		EnvMetricsHistory:  &c.MetricsHistory,
+	} {
+		if value, ok := lookup(key); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			*field = n
+		}
+	}
+	return nil
+}
+
+// Validate checks the config is complete and consistent
+func (c *Config) Validate() error {
+	switch c.DbDriver {
+	case DriverMySQL, DriverPostgres, DriverSQLite:
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownDriver, c.DbDriver)
+	}
+	if c.DbConnDsn == "" {
+		return ErrMissingDsn
+	}
+	if _, err := net.ResolveTCPAddr("tcp", c.RpcListenAddr); err != nil {
+		return fmt.Errorf("rpc listen address: %w", err)
+	}
+	if _, _, err := net.SplitHostPort(c.WebListenAddr); err != nil {
+		return fmt.Errorf("web listen address: %w", err)
+	}
+	if c.MetricsInterval <= 0 || c.MetricsHistory <= 0 {
+		return ErrInvalidMetrics
+	}
+	return nil
+}
+
+// GetMetricsInterval returns the interval between the samples of the report rate
+func (c *Config) GetMetricsInterval() time.Duration {
+	return time.Duration(c.MetricsInterval) * time.Second
+}
+
+// Save writes the config to the file as indented json, replacing its content
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
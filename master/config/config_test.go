@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.json")
+	assert.Nil(t, os.WriteFile(path, []byte(`{"db_driver": "sqlite", "db_dsn": "argos.db", "metrics_interval": 10}`), 0644))
+
+	c, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, DriverSQLite, c.DbDriver)
+	assert.Equal(t, "argos.db", c.DbConnDsn)
+	assert.Equal(t, 10*time.Second, c.GetMetricsInterval())
+	// the fields missing in the file keep their defaults
+	assert.Equal(t, "localhost:4222", c.RpcListenAddr)
+	assert.Equal(t, 60, c.MetricsHistory)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		EnvDbDriver:        "postgres",
+		EnvDbConnDsn:       "host=127.0.0.1 dbname=argos",
+		EnvRpcListenAddr:   "0.0.0.0:4333",
+		EnvMetricsInterval: "30",
+	}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	c := Default()
+	assert.Nil(t, c.applyEnv(lookup))
+	assert.Equal(t, DriverPostgres, c.DbDriver)
+	assert.Equal(t, "host=127.0.0.1 dbname=argos", c.DbConnDsn)
+	assert.Equal(t, "0.0.0.0:4333", c.RpcListenAddr)
+	assert.Equal(t, ":8080", c.WebListenAddr)
+	assert.Equal(t, 30, c.MetricsInterval)
+	assert.Nil(t, c.Validate())
+
+	env[EnvMetricsHistory] = "many"
+	assert.NotNil(t, Default().applyEnv(lookup))
+}
+
+func TestValidate(t *testing.T) {
+	c := Default()
+	assert.Equal(t, ErrMissingDsn, c.Validate())
+
+	c.DbConnDsn = "argos.db"
+	assert.Nil(t, c.Validate())
+
+	c.DbDriver = "oracle"
+	assert.True(t, errors.Is(c.Validate(), ErrUnknownDriver))
+
+	c = Default()
+	c.DbConnDsn = "argos.db"
+	c.WebListenAddr = "8080"
+	assert.NotNil(t, c.Validate())
+
+	c = Default()
+	c.DbConnDsn = "argos.db"
+	c.MetricsHistory = 0
+	assert.Equal(t, ErrInvalidMetrics, c.Validate())
+}
+
+func TestSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master.json")
+	c := Default()
+	c.DbDriver = DriverSQLite
+	c.DbConnDsn = "argos.db"
+	assert.Nil(t, c.Save(path))
+
+	loaded, err := Load(path)
+	assert.Nil(t, err)
+	assert.Equal(t, c, loaded)
+}
@@ -1,10 +1,8 @@
 package dal
 
 import (
-	"errors"
-
 	"github.com/AlaricGilbert/argos-core/master/model"
-	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ConclusionQuery struct {
@@ -16,22 +14,38 @@ type ConclusionQuery struct {
 	Method   string
 }
 
+// CreateOrUpdateConclustion keeps the earliest record of the transaction by the method of r as its conclusion.
+// Both statements are atomic under the unique key of (txid, method), so concurrent reports cannot create
+// duplicated conclusions nor replace an earlier one.
 func CreateOrUpdateConclustion(r *model.Record) error {
-	return db.Transaction(func(tx *gorm.DB) error {
-		var record model.Record
-		if err := tx.Table("conclusions").Where("txid = ? AND method = ?", r.Txid, r.Method).First(&record).Error; err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				// create
-				return tx.Table("conclusions").Create(r).Error
-			}
-			return err
-		} else {
-			if r.Timestamp < record.Timestamp {
-				return tx.Table("conclusions").Model(&record).Updates(r).Error
-			}
-			return nil
-		}
-	})
+	// the record may carry the id of its row in records
+	conclusion := *r
+	conclusion.ID = 0
+	result := db.Table("conclusions").Clauses(clause.OnConflict{DoNothing: true}).Create(&conclusion)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+
+	return db.Table("conclusions").Where("txid = ? AND method = ? AND timestamp > ?", r.Txid, r.Method, r.Timestamp).
+		Updates(map[string]interface{}{
+			"timestamp":       r.Timestamp,
+			"source_ip":       r.SourceIp,
+			"sniffer":         r.Sniffer,
+			"protocol":        r.Protocol,
+			"uncertainty":     r.Uncertainty,
+			"offered_txrcncl": r.OfferedTxRcncl,
+		}).Error
+}
+
+// SaveConclusion creates or replaces the conclusion of the transaction by the method of r, unlike
+// CreateOrUpdateConclustion it keeps the latest estimate rather than the earliest record
+func SaveConclusion(r *model.Record) error {
+	conclusion := *r
+	conclusion.ID = 0
+	return db.Table("conclusions").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "txid"}, {Name: "method"}},
+		DoUpdates: clause.AssignmentColumns([]string{"timestamp", "source_ip", "sniffer", "protocol", "uncertainty", "offered_txrcncl"}),
+	}).Create(&conclusion).Error
 }
 
 func GetSingleConclusion(txid, method string) (*model.Record, error) {
@@ -3,15 +3,55 @@ package dal
 import (
 	"github.com/AlaricGilbert/argos-core/master/config"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
+const (
+	DriverMySQL    = config.DriverMySQL
+	DriverPostgres = config.DriverPostgres
+	DriverSQLite   = config.DriverSQLite
+)
+
+// ErrUnknownDriver means the database driver is not supported
+var ErrUnknownDriver = config.ErrUnknownDriver
+
 var db *gorm.DB
 
-func InitDatabase() {
-	var err error
-	db, err = gorm.Open(mysql.Open(config.DbConnDsn), &gorm.Config{})
+// dialector returns the gorm dialector of the driver connecting to the dsn
+func dialector(driver, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case DriverMySQL:
+		return mysql.Open(dsn), nil
+	case DriverPostgres:
+		return postgres.Open(dsn), nil
+	case DriverSQLite:
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, ErrUnknownDriver
+	}
+}
+
+// Open connects to the database of the driver, records the latency of its operations and migrates its
+// schema to the latest version
+func Open(driver, dsn string) error {
+	d, err := dialector(driver, dsn)
 	if err != nil {
+		return err
+	}
+	if db, err = gorm.Open(d, &gorm.Config{}); err != nil {
+		return err
+	}
+	if err = registerMetrics(db); err != nil {
+		return err
+	}
+	return Migrate()
+}
+
+// InitDatabase opens the database of the config, it panics when the database is not available
+func InitDatabase(c *config.Config) {
+	if err := Open(c.DbDriver, c.DbConnDsn); err != nil {
 		panic(err)
 	}
 }
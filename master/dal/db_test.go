@@ -0,0 +1,150 @@
+package dal
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
+	"github.com/AlaricGilbert/argos-core/master/model"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestDatabase opens a migrated sqlite database in the temp dir of the test
+func openTestDatabase(t *testing.T) {
+	assert.Nil(t, Open(DriverSQLite, filepath.Join(t.TempDir(), "argos.db")))
+}
+
+func TestOpen(t *testing.T) {
+	assert.Equal(t, ErrUnknownDriver, Open("oracle", ""))
+
+	openTestDatabase(t)
+	for _, table := range []string{"records", "conclusions", "tasks", "sniffers", "observations"} {
+		assert.True(t, db.Migrator().HasTable(table), table)
+	}
+	for _, table := range offeredTxRcnclV3 {
+		assert.True(t, db.Migrator().HasColumn(table, "offered_txrcncl"), table)
+	}
+
+	// migrations are applied only once
+	assert.Nil(t, Migrate())
+	var applied []schemaMigration
+	assert.Nil(t, db.Find(&applied).Error)
+	assert.Equal(t, len(migrations), len(applied))
+}
+
+func TestQueryMetrics(t *testing.T) {
+	openTestDatabase(t)
+
+	create := metrics.GetOrRegisterTimer(exporter.Name("master.db.latency", "operation", "create"), nil)
+	query := metrics.GetOrRegisterTimer(exporter.Name("master.db.latency", "operation", "query"), nil)
+	creates, queries := create.Count(), query.Count()
+
+	assert.Nil(t, CreateTask("metrics", "bitcoin"))
+	_, err := GetTask("metrics")
+	assert.Nil(t, err)
+	assert.Equal(t, creates+1, create.Count())
+	assert.Equal(t, queries+1, query.Count())
+}
+
+func TestTaskRevision(t *testing.T) {
+	openTestDatabase(t)
+
+	assert.Nil(t, CreateTask("hubei", "bitcoin"))
+	assert.Nil(t, UpdateTaskConfig("hubei", `{"network":"testnet3"}`))
+	task, err := GetTask("hubei")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), task.Revision)
+	assert.Equal(t, `{"network":"testnet3"}`, task.Config)
+}
+
+func TestConclusions(t *testing.T) {
+	openTestDatabase(t)
+
+	r := model.Record{Txid: "00", Timestamp: 2, SourceIp: "192.0.2.1", Method: "FTE", OfferedTxRcncl: true}
+	assert.Nil(t, CreateOrUpdateConclustion(&r))
+	// only an earlier record replaces the conclusion
+	late := model.Record{Txid: "00", Timestamp: 3, SourceIp: "192.0.2.2", Method: "FTE"}
+	assert.Nil(t, CreateOrUpdateConclustion(&late))
+	early := model.Record{Txid: "00", Timestamp: 1, SourceIp: "192.0.2.3", Method: "FTE"}
+	assert.Nil(t, CreateOrUpdateConclustion(&early))
+	conclusion, err := GetSingleConclusion("00", "FTE")
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.3", conclusion.SourceIp)
+	assert.False(t, conclusion.OfferedTxRcncl)
+
+	// saved conclusions are replaced by the latest estimate, zero fields included
+	assert.Nil(t, SaveConclusion(&model.Record{Txid: "00", Timestamp: 5, SourceIp: "192.0.2.4", Method: "GFE", Uncertainty: 7}))
+	assert.Nil(t, SaveConclusion(&model.Record{Txid: "00", Timestamp: 4, SourceIp: "192.0.2.5", Method: "GFE"}))
+	conclusion, err = GetSingleConclusion("00", "GFE")
+	assert.Nil(t, err)
+	assert.Equal(t, "192.0.2.5", conclusion.SourceIp)
+	assert.Equal(t, int64(0), conclusion.Uncertainty)
+}
+
+func TestRegisterSniffer(t *testing.T) {
+	openTestDatabase(t)
+
+	now := time.Now()
+	s := model.Sniffer{Identifier: "hubei-SIp7m1Lkc4", LastPing: now.UnixNano(), Session: "chosen-by-the-sniffer"}
+	assert.Nil(t, RegisterSniffer(&s))
+	assert.NotEmpty(t, s.Session)
+	assert.NotEqual(t, "chosen-by-the-sniffer", s.Session)
+
+	// the holder keeps its session on the later pings
+	session := s.Session
+	s.LastPing = now.Add(time.Millisecond).UnixNano()
+	assert.Nil(t, RegisterSniffer(&s))
+	assert.Equal(t, session, s.Session)
+
+	// another process cannot take the identifier while the holder is online
+	another := model.Sniffer{Identifier: "hubei-SIp7m1Lkc4", LastPing: now.Add(time.Second).UnixNano()}
+	assert.Equal(t, ErrIdentifierConflict, RegisterSniffer(&another))
+	another.LastPing = now.Add(time.Minute).UnixNano()
+	assert.Nil(t, RegisterSniffer(&another))
+
+	sniffers, err := GetSnifferList(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(sniffers))
+	assert.Equal(t, another.Session, sniffers[0].Session)
+
+	// only the session of the latest holder is valid
+	for session, valid := range map[string]bool{another.Session: true, s.Session: false, "": false} {
+		ok, err := CheckSession("hubei-SIp7m1Lkc4", session)
+		assert.Nil(t, err)
+		assert.Equal(t, valid, ok)
+	}
+	ok, err := CheckSession("hubei-another", another.Session)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+}
+
+func TestMigrateDuplicates(t *testing.T) {
+	openTestDatabase(t)
+
+	// roll back to the first version, where conclusions were not unique
+	assert.Nil(t, db.Where("version > 1").Delete(&schemaMigration{}).Error)
+	for _, idx := range indexesV2 {
+		assert.Nil(t, db.Migrator().DropIndex(idx.table, idx.name))
+	}
+	for i, timestamp := range []int64{3, 1, 2, 1} {
+		assert.Nil(t, db.Table("conclusions").Create(&model.Record{
+			Txid: "00", Timestamp: timestamp, Method: "FTE", SourceIp: fmt.Sprintf("192.0.2.%d", i),
+		}).Error)
+	}
+
+	// the earliest conclusion is kept, the first created one when the timestamps are the same
+	assert.Nil(t, Migrate())
+	var conclusions []model.Record
+	assert.Nil(t, db.Table("conclusions").Find(&conclusions).Error)
+	assert.Equal(t, 1, len(conclusions))
+	assert.Equal(t, "192.0.2.1", conclusions[0].SourceIp)
+	for _, idx := range indexesV2 {
+		assert.True(t, db.Migrator().HasIndex(idx.table, idx.name), idx.name)
+	}
+
+	// conclusions cannot be duplicated any more
+	assert.NotNil(t, db.Table("conclusions").Create(&model.Record{Txid: "00", Method: "FTE"}).Error)
+}
@@ -0,0 +1,46 @@
+package dal
+
+import (
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/metrics"
+	"gorm.io/gorm"
+)
+
+// queryStartedKey keeps the time a statement started in its instance settings
+const queryStartedKey = "argos:query_started"
+
+// registerMetrics records the latency of the database operations by the callbacks of gorm
+func registerMetrics(db *gorm.DB) error {
+	before := func(tx *gorm.DB) {
+		tx.InstanceSet(queryStartedKey, time.Now())
+	}
+	after := func(operation string) func(tx *gorm.DB) {
+		return func(tx *gorm.DB) {
+			if started, ok := tx.InstanceGet(queryStartedKey); ok {
+				metrics.ObserveQuery(operation, time.Since(started.(time.Time)))
+			}
+		}
+	}
+
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Create().Before("gorm:create").Register("argos:before_create", before),
+		cb.Create().After("gorm:create").Register("argos:after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("argos:before_query", before),
+		cb.Query().After("gorm:query").Register("argos:after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("argos:before_update", before),
+		cb.Update().After("gorm:update").Register("argos:after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("argos:before_delete", before),
+		cb.Delete().After("gorm:delete").Register("argos:after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("argos:before_row", before),
+		cb.Row().After("gorm:row").Register("argos:after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("argos:before_raw", before),
+		cb.Raw().After("gorm:raw").Register("argos:after_raw", after("raw")),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
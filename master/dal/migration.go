@@ -0,0 +1,212 @@
+package dal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos"
+	"gorm.io/gorm"
+)
+
+// migration changes the schema from the previous version to its version, migrations are applied in order
+// and each of them only once, the applied versions are recorded in the schema_migrations table
+type migration struct {
+	version int
+	name    string
+	up      func(tx *gorm.DB) error
+}
+
+// schemaMigration is an applied migration
+type schemaMigration struct {
+	Version   int    `gorm:"column:version;primaryKey;autoIncrement:false"`
+	Name      string `gorm:"column:name;size:255"`
+	AppliedAt int64  `gorm:"column:applied_at"`
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// migrations are the versions of the schema, released migrations must never be changed
+var migrations = []migration{
+	{version: 1, name: "create tables", up: createTables},
+	{version: 2, name: "add indexes", up: addIndexes},
+	{version: 3, name: "add offered_txrcncl", up: addOfferedTxRcncl},
+}
+
+// Migrate applies the migrations not applied yet
+func Migrate() error {
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+
+	var applied []schemaMigration
+	if err := db.Find(&applied).Error; err != nil {
+		return err
+	}
+	var done = make(map[int]struct{}, len(applied))
+	for _, m := range applied {
+		done[m.Version] = struct{}{}
+	}
+
+	for _, m := range migrations {
+		if _, ok := done[m.version]; ok {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&schemaMigration{Version: m.version, Name: m.name, AppliedAt: time.Now().Unix()}).Error
+		}); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		argos.StandardLogger().WithField("version", m.version).WithField("name", m.name).Info("database migrated")
+	}
+	return nil
+}
+
+// the tables as created by the first migration, they are snapshots of the models so that the migration
+// does not change with them. Tables created by hand before are completed with the missing columns.
+type (
+	recordV1 struct {
+		ID          int64  `gorm:"column:id;primaryKey"`
+		Txid        string `gorm:"column:txid;size:64"`
+		Timestamp   int64  `gorm:"column:timestamp"`
+		SourceIp    string `gorm:"column:source_ip;size:45"`
+		Sniffer     string `gorm:"column:sniffer;size:64"`
+		Protocol    string `gorm:"column:protocol;size:32"`
+		Method      string `gorm:"column:method;size:32"`
+		Uncertainty int64  `gorm:"column:uncertainty"`
+	}
+	conclusionV1 recordV1
+	taskV1       struct {
+		ID       int64  `gorm:"column:id;primaryKey"`
+		Prefix   string `gorm:"column:prefix;size:64"`
+		Protocol string `gorm:"column:protocol;size:32"`
+		Config   string `gorm:"column:config;type:text"`
+		Revision int64  `gorm:"column:revision"`
+	}
+	snifferV1 struct {
+		ID            int64  `gorm:"column:id;primaryKey"`
+		Identifier    string `gorm:"column:identifier;size:64"`
+		Protocol      string `gorm:"column:protocol;size:32"`
+		Build         string `gorm:"column:build;size:255"`
+		OutboundPeers int32  `gorm:"column:outbound_peers"`
+		InboundPeers  int32  `gorm:"column:inbound_peers"`
+		Uptime        int64  `gorm:"column:uptime"`
+		FirstPing     int64  `gorm:"column:first_ping"`
+		LastPing      int64  `gorm:"column:last_ping"`
+		Session       string `gorm:"column:session;size:64"`
+	}
+	observationV1 struct {
+		ID          int64  `gorm:"column:id;primaryKey"`
+		Txid        string `gorm:"column:txid;size:64"`
+		Timestamp   int64  `gorm:"column:timestamp"`
+		SourceIp    string `gorm:"column:source_ip;size:45"`
+		SourcePort  int32  `gorm:"column:source_port"`
+		Sniffer     string `gorm:"column:sniffer;size:64"`
+		Protocol    string `gorm:"column:protocol;size:32"`
+		Uncertainty int64  `gorm:"column:uncertainty"`
+	}
+)
+
+func (recordV1) TableName() string      { return "records" }
+func (conclusionV1) TableName() string  { return "conclusions" }
+func (taskV1) TableName() string        { return "tasks" }
+func (snifferV1) TableName() string     { return "sniffers" }
+func (observationV1) TableName() string { return "observations" }
+
+func createTables(tx *gorm.DB) error {
+	return tx.AutoMigrate(&recordV1{}, &conclusionV1{}, &taskV1{}, &snifferV1{}, &observationV1{})
+}
+
+// index is an index of a table created by a migration
+type index struct {
+	table   string
+	name    string
+	columns []string
+	unique  bool
+}
+
+// indexesV2 serve the queries of the dal, the unique ones also keep concurrent writers from creating
+// duplicated rows
+var indexesV2 = []index{
+	{table: "records", name: "idx_records_txid", columns: []string{"txid"}},
+	{table: "records", name: "idx_records_source_ip", columns: []string{"source_ip"}},
+	{table: "records", name: "idx_records_timestamp", columns: []string{"timestamp"}},
+	{table: "conclusions", name: "uniq_conclusions_txid_method", columns: []string{"txid", "method"}, unique: true},
+	{table: "conclusions", name: "idx_conclusions_method_protocol_timestamp", columns: []string{"method", "protocol", "timestamp"}},
+	{table: "conclusions", name: "idx_conclusions_source_ip", columns: []string{"source_ip"}},
+	{table: "tasks", name: "uniq_tasks_prefix", columns: []string{"prefix"}, unique: true},
+	{table: "sniffers", name: "uniq_sniffers_identifier", columns: []string{"identifier"}, unique: true},
+	{table: "observations", name: "idx_observations_txid", columns: []string{"txid"}},
+}
+
+// deleteDuplicates deletes the rows of the table sharing the columns with another row, the row kept is the
+// first one ordered by the order columns and then the id. The subquery is wrapped as MySQL does not allow
+// selecting from the table being deleted from.
+func deleteDuplicates(tx *gorm.DB, table string, columns []string, order string) error {
+	var same = make([]string, len(columns))
+	for i, column := range columns {
+		same[i] = fmt.Sprintf("c.%s = d.%s", column, column)
+	}
+	var before = "d.id < c.id"
+	if order != "" {
+		before = fmt.Sprintf("d.%s < c.%s OR (d.%s = c.%s AND d.id < c.id)", order, order, order, order)
+	}
+	return tx.Exec(fmt.Sprintf(
+		"DELETE FROM %s WHERE id IN (SELECT id FROM (SELECT c.id FROM %s c JOIN %s d ON %s AND (%s)) dup)",
+		table, table, table, strings.Join(same, " AND "), before,
+	)).Error
+}
+
+func addIndexes(tx *gorm.DB) error {
+	// the conclusion kept is the earliest one, as CreateOrUpdateConclustion does
+	if err := deleteDuplicates(tx, "conclusions", []string{"txid", "method"}, "timestamp"); err != nil {
+		return err
+	}
+	if err := deleteDuplicates(tx, "tasks", []string{"prefix"}, ""); err != nil {
+		return err
+	}
+	if err := deleteDuplicates(tx, "sniffers", []string{"identifier"}, ""); err != nil {
+		return err
+	}
+
+	for _, idx := range indexesV2 {
+		if tx.Migrator().HasIndex(idx.table, idx.name) {
+			continue
+		}
+		var unique = ""
+		if idx.unique {
+			unique = "UNIQUE "
+		}
+		var columns = make([]string, len(idx.columns))
+		for i, column := range idx.columns {
+			columns[i] = tx.Statement.Quote(column)
+		}
+		if err := tx.Exec(fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique,
+			tx.Statement.Quote(idx.name), tx.Statement.Quote(idx.table), strings.Join(columns, ", "))).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offeredTxRcnclV3 are the tables given the offered_txrcncl column, which marks the sources that sent
+// sendtxrcncl. The rows stored before are not known to have offered it.
+var offeredTxRcnclV3 = []string{"records", "conclusions", "observations"}
+
+func addOfferedTxRcncl(tx *gorm.DB) error {
+	for _, table := range offeredTxRcnclV3 {
+		if tx.Migrator().HasColumn(table, "offered_txrcncl") {
+			continue
+		}
+		if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s BOOLEAN NOT NULL DEFAULT FALSE",
+			tx.Statement.Quote(table), tx.Statement.Quote("offered_txrcncl"))).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package dal
+
+import "github.com/AlaricGilbert/argos-core/master/model"
+
+// observationBatchSize is the number of observations inserted by a single statement
+const observationBatchSize = 500
+
+// CreateObservations stores the raw observations
+func CreateObservations(observations []model.Observation) error {
+	if len(observations) == 0 {
+		return nil
+	}
+	return db.Table("observations").CreateInBatches(observations, observationBatchSize).Error
+}
+
+// GetObservationsWithTxid returns the raw observations of the transaction ordered by timestamp
+func GetObservationsWithTxid(txid string) ([]model.Observation, error) {
+	var observations []model.Observation
+
+	return observations, db.Table("observations").Where("txid = ?", txid).Order("timestamp").Find(&observations).Error
+}
@@ -0,0 +1,103 @@
+package dal
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SnifferOfflineTimeout is the time without pings after which a sniffer is considered offline,
+// sniffers ping every 10 seconds so it tolerates two missed heartbeats
+const SnifferOfflineTimeout = 30 * time.Second
+
+// ErrIdentifierConflict means the identifier is held by another sniffer process which is still online
+var ErrIdentifierConflict = errors.New("identifier held by another live sniffer")
+
+// newSession generates a random session token
+func newSession() (string, error) {
+	var token [16]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token[:]), nil
+}
+
+// RegisterSniffer creates the sniffer on its first ping and refreshes it on the later ones. The session of s
+// identifies the sniffer process: it is kept only when it is the session stored for the identifier, any other
+// is replaced by a newly issued one, and pings from another process than the one holding the identifier are
+// rejected by ErrIdentifierConflict until the holder goes offline.
+func RegisterSniffer(s *model.Sniffer) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		var sniffer model.Sniffer
+		if err = tx.Table("sniffers").Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("identifier = ?", s.Identifier).First(&sniffer).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		found := err == nil
+
+		held := found && sniffer.Session != "" && sniffer.Session == s.Session
+		if found && sniffer.Session != "" && !held && sniffer.IsOnline(time.Unix(0, s.LastPing), SnifferOfflineTimeout) {
+			return ErrIdentifierConflict
+		}
+		// sessions are only issued by the master, the one sent by an unknown process is not taken
+		if !held {
+			if s.Session, err = newSession(); err != nil {
+				return err
+			}
+		}
+
+		if !found {
+			s.FirstPing = s.LastPing
+			return tx.Table("sniffers").Create(s).Error
+		}
+		return tx.Table("sniffers").Where("id = ?", sniffer.ID).Updates(map[string]interface{}{
+			"protocol":       s.Protocol,
+			"build":          s.Build,
+			"outbound_peers": s.OutboundPeers,
+			"inbound_peers":  s.InboundPeers,
+			"uptime":         s.Uptime,
+			"last_ping":      s.LastPing,
+			"session":        s.Session,
+		}).Error
+	})
+}
+
+// CheckSession reports whether the session was issued to the sniffer of the identifier by its latest ping
+func CheckSession(identifier, session string) (bool, error) {
+	if session == "" {
+		return false, nil
+	}
+	var count int64
+	err := db.Table("sniffers").Where("identifier = ? AND session = ?", identifier, session).Count(&count).Error
+	return count > 0, err
+}
+
+// GetSnifferList returns the known sniffers with their liveness, only the online or offline ones are
+// returned when online is not nil
+func GetSnifferList(online *bool) ([]model.Sniffer, error) {
+	var sniffers []model.Sniffer
+	if err := db.Table("sniffers").Order("identifier").Find(&sniffers).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	filtered := sniffers[:0]
+	for _, s := range sniffers {
+		s.Online = s.IsOnline(now, SnifferOfflineTimeout)
+		if online == nil || *online == s.Online {
+			filtered = append(filtered, s)
+		}
+	}
+	return filtered, nil
+}
+
+// RemoveSniffer forgets the sniffer, it is registered again on its next ping
+func RemoveSniffer(identifier string) error {
+	return db.Table("sniffers").Where("identifier = ?", identifier).Delete(&model.Sniffer{}).Error
+}
@@ -1,6 +1,9 @@
 package dal
 
-import "github.com/AlaricGilbert/argos-core/master/model"
+import (
+	"github.com/AlaricGilbert/argos-core/master/model"
+	"gorm.io/gorm"
+)
 
 func GetTaskList() (tasks []model.Task, err error) {
 	return tasks, db.Table("tasks").Find(&tasks).Error
@@ -16,11 +19,22 @@ func GetTask(prefix string) (*model.Task, error) {
 }
 
 func CreateTask(prefix, protocol string) error {
-	return db.Table("tasks").Create(&model.Task{Prefix: prefix, Protocol: protocol}).Error
+	return db.Table("tasks").Create(&model.Task{Prefix: prefix, Protocol: protocol, Revision: 1}).Error
 }
 
 func UpdateTask(prefix, protocol string) error {
-	return db.Table("tasks").Where("prefix = ?", prefix).Update("protocol", protocol).Error
+	return db.Table("tasks").Where("prefix = ?", prefix).Updates(map[string]interface{}{
+		"protocol": protocol,
+		"revision": gorm.Expr("revision + 1"),
+	}).Error
+}
+
+// UpdateTaskConfig replaces the json encoded config of the task
+func UpdateTaskConfig(prefix, config string) error {
+	return db.Table("tasks").Where("prefix = ?", prefix).Updates(map[string]interface{}{
+		"config":   config,
+		"revision": gorm.Expr("revision + 1"),
+	}).Error
 }
 
 func RemoveTask(prefix string) error {
@@ -0,0 +1,195 @@
+package estimator
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Method is the method the global conclusions are stored under
+	Method = "GFE"
+	// MethodFirstTimestamp is the method of the first-seen reports of the sniffers merged by the estimator
+	MethodFirstTimestamp = "FTE"
+	// DefaultWindow is the time the observations of a transaction are kept after its first observation,
+	// later observations start a new estimate
+	DefaultWindow = 10 * time.Minute
+)
+
+// Observation is a sniffer seeing a transaction announced by a source
+type Observation struct {
+	Sniffer string
+	Source  string
+	// Timestamp is the unix nano time converted to the master clock, Uncertainty bounds its error
+	Timestamp   int64
+	Uncertainty int64
+}
+
+// Estimate is the source of a transaction estimated from the observations of all sniffers
+type Estimate struct {
+	Source string
+	// Sniffer is the sniffer holding the earliest observation of the source
+	Sniffer     string
+	Timestamp   int64
+	Uncertainty int64
+	// Votes is the number of sniffers that may have seen the source first
+	Votes int
+}
+
+// Merge estimates the source from the observations of a transaction. The earliest announcement surely
+// happened before the earliest upper bound of the observations, so every observation whose lower bound
+// is before it may be the first; the sources of these candidates are voted, each observation weighted by
+// the inverse of its uncertainty, and ties are broken by the earliest timestamp.
+func Merge(observations []Observation) (Estimate, bool) {
+	if len(observations) == 0 {
+		return Estimate{}, false
+	}
+
+	bound := observations[0].Timestamp + observations[0].Uncertainty
+	for _, o := range observations[1:] {
+		if o.Timestamp+o.Uncertainty < bound {
+			bound = o.Timestamp + o.Uncertainty
+		}
+	}
+
+	weights := make(map[string]float64)
+	voters := make(map[string]map[string]struct{})
+	first := make(map[string]Observation)
+	for _, o := range observations {
+		if o.Timestamp-o.Uncertainty > bound {
+			continue
+		}
+		// a millisecond is added so that observations without uncertainty do not take all the weight
+		weights[o.Source] += 1 / float64(o.Uncertainty+int64(time.Millisecond))
+		if voters[o.Source] == nil {
+			voters[o.Source] = make(map[string]struct{})
+		}
+		voters[o.Source][o.Sniffer] = struct{}{}
+		if f, ok := first[o.Source]; !ok || o.Timestamp < f.Timestamp {
+			first[o.Source] = o
+		}
+	}
+
+	var best string
+	for source, weight := range weights {
+		if best == "" || weight > weights[best] ||
+			weight == weights[best] && (first[source].Timestamp < first[best].Timestamp ||
+				first[source].Timestamp == first[best].Timestamp && source < best) {
+			best = source
+		}
+	}
+
+	o := first[best]
+	return Estimate{
+		Source:      best,
+		Sniffer:     o.Sniffer,
+		Timestamp:   o.Timestamp,
+		Uncertainty: o.Uncertainty,
+		Votes:       len(voters[best]),
+	}, true
+}
+
+// transaction is the observations of a transaction within the window
+type transaction struct {
+	first        time.Time
+	observations []Observation
+	estimate     Estimate
+}
+
+// add adds the observation, a sniffer observing the same source again only keeps its earliest time. It
+// reports whether the observations changed.
+func (tx *transaction) add(observation Observation) bool {
+	for i, o := range tx.observations {
+		if o.Sniffer == observation.Sniffer && o.Source == observation.Source {
+			if observation.Timestamp >= o.Timestamp {
+				return false
+			}
+			tx.observations[i] = observation
+			return true
+		}
+	}
+	tx.observations = append(tx.observations, observation)
+	return true
+}
+
+// Loader returns the observations of the transaction stored before, so that a transaction seen again after
+// the window or a restart is estimated from all its observations rather than the new ones only
+type Loader func(txid string) []Observation
+
+// Estimator merges the observations of the sniffers per transaction
+type Estimator struct {
+	window       time.Duration
+	now          func() time.Time
+	load         Loader
+	transactions map[string]*transaction
+	pruned       time.Time
+	mu           sync.Mutex
+}
+
+// New creates an estimator keeping the observations of each transaction for window
+func New(window time.Duration) *Estimator {
+	return NewWithLoader(window, nil)
+}
+
+// NewWithLoader creates an estimator keeping the observations of each transaction for window, the
+// observations of the transactions not within the window are loaded by load first
+func NewWithLoader(window time.Duration, load Loader) *Estimator {
+	return &Estimator{
+		window:       window,
+		now:          time.Now,
+		load:         load,
+		transactions: make(map[string]*transaction),
+	}
+}
+
+// Observe adds the observation of the transaction, it returns the estimate of the transaction and
+// whether the estimate changed. A sniffer observing the same source again only keeps its earliest time.
+func (e *Estimator) Observe(txid string, observation Observation) (Estimate, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	e.prune(now)
+
+	tx, ok := e.transactions[txid]
+	if !ok {
+		tx = &transaction{first: now}
+		if e.load != nil {
+			for _, o := range e.load(txid) {
+				tx.add(o)
+			}
+		}
+		e.transactions[txid] = tx
+	}
+
+	// the loaded observations may already hold this one, the estimate of a new transaction is computed anyway
+	if !tx.add(observation) && ok {
+		return tx.estimate, false
+	}
+
+	estimate, _ := Merge(tx.observations)
+	if estimate == tx.estimate {
+		return estimate, false
+	}
+	tx.estimate = estimate
+	return estimate, true
+}
+
+// Len returns the number of transactions within the window
+func (e *Estimator) Len() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.transactions)
+}
+
+// prune drops the transactions older than the window, scanning them at most ten times per window
+func (e *Estimator) prune(now time.Time) {
+	if now.Sub(e.pruned) < e.window/10 {
+		return
+	}
+	e.pruned = now
+	for txid, tx := range e.transactions {
+		if now.Sub(tx.first) > e.window {
+			delete(e.transactions, txid)
+		}
+	}
+}
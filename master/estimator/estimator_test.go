@@ -0,0 +1,113 @@
+package estimator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerge(t *testing.T) {
+	ms := int64(time.Millisecond)
+
+	_, ok := Merge(nil)
+	assert.False(t, ok)
+
+	// the earliest observation of a precise sniffer is overlapped by two others seeing another source,
+	// the sources are voted by the precision of their observations
+	estimate, ok := Merge([]Observation{
+		{Sniffer: "a", Source: "10.0.0.1", Timestamp: 1000 * ms, Uncertainty: 4 * ms},
+		{Sniffer: "b", Source: "10.0.0.2", Timestamp: 1001 * ms, Uncertainty: 5 * ms},
+		{Sniffer: "c", Source: "10.0.0.2", Timestamp: 1003 * ms, Uncertainty: 5 * ms},
+		// surely later than the first announcement, so it does not vote
+		{Sniffer: "d", Source: "10.0.0.3", Timestamp: 1100 * ms, Uncertainty: 5 * ms},
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.2", estimate.Source)
+	assert.Equal(t, "b", estimate.Sniffer)
+	assert.Equal(t, 1001*ms, estimate.Timestamp)
+	assert.Equal(t, 2, estimate.Votes)
+
+	// a precise observation outweighs imprecise ones
+	estimate, _ = Merge([]Observation{
+		{Sniffer: "a", Source: "10.0.0.1", Timestamp: 1000 * ms, Uncertainty: 0},
+		{Sniffer: "b", Source: "10.0.0.2", Timestamp: 1001 * ms, Uncertainty: 50 * ms},
+		{Sniffer: "c", Source: "10.0.0.2", Timestamp: 1003 * ms, Uncertainty: 50 * ms},
+	})
+	assert.Equal(t, "10.0.0.1", estimate.Source)
+	assert.Equal(t, 1, estimate.Votes)
+
+	// ties are broken by the earliest timestamp
+	estimate, _ = Merge([]Observation{
+		{Sniffer: "a", Source: "10.0.0.2", Timestamp: 1001 * ms, Uncertainty: 5 * ms},
+		{Sniffer: "b", Source: "10.0.0.1", Timestamp: 1000 * ms, Uncertainty: 5 * ms},
+	})
+	assert.Equal(t, "10.0.0.1", estimate.Source)
+}
+
+func TestEstimator(t *testing.T) {
+	ms := int64(time.Millisecond)
+	e := New(time.Minute)
+	now := time.Now()
+	e.now = func() time.Time { return now }
+
+	estimate, changed := e.Observe("tx", Observation{Sniffer: "a", Source: "10.0.0.1", Timestamp: 1000 * ms})
+	assert.True(t, changed)
+	assert.Equal(t, "10.0.0.1", estimate.Source)
+
+	// a later observation of the same sniffer and source is ignored
+	_, changed = e.Observe("tx", Observation{Sniffer: "a", Source: "10.0.0.1", Timestamp: 1010 * ms})
+	assert.False(t, changed)
+
+	// an observation of another sniffer far later does not change the estimate
+	_, changed = e.Observe("tx", Observation{Sniffer: "b", Source: "10.0.0.2", Timestamp: 2000 * ms})
+	assert.False(t, changed)
+
+	// an earlier observation does
+	estimate, changed = e.Observe("tx", Observation{Sniffer: "c", Source: "10.0.0.3", Timestamp: 900 * ms})
+	assert.True(t, changed)
+	assert.Equal(t, "10.0.0.3", estimate.Source)
+	assert.Equal(t, 1, e.Len())
+
+	// transactions are forgotten out of the window
+	now = now.Add(2 * time.Minute)
+	estimate, changed = e.Observe("another", Observation{Sniffer: "a", Source: "10.0.0.1", Timestamp: 3000 * ms})
+	assert.True(t, changed)
+	assert.Equal(t, 1, e.Len())
+}
+
+func TestEstimatorLoader(t *testing.T) {
+	ms := int64(time.Millisecond)
+	stored := []Observation{
+		{Sniffer: "a", Source: "10.0.0.1", Timestamp: 1000 * ms, Uncertainty: 5 * ms},
+		{Sniffer: "b", Source: "10.0.0.1", Timestamp: 1001 * ms, Uncertainty: 5 * ms},
+		// the same report stored twice only votes once
+		{Sniffer: "b", Source: "10.0.0.1", Timestamp: 1001 * ms, Uncertainty: 5 * ms},
+	}
+	loads := 0
+	e := NewWithLoader(time.Minute, func(txid string) []Observation {
+		loads++
+		if txid != "tx" {
+			return nil
+		}
+		return stored
+	})
+
+	// a late report of a transaction not within the window is merged with the stored ones, so it does not
+	// replace the estimate they back
+	estimate, changed := e.Observe("tx", Observation{Sniffer: "c", Source: "10.0.0.2", Timestamp: 1002 * ms, Uncertainty: 5 * ms})
+	assert.True(t, changed)
+	assert.Equal(t, "10.0.0.1", estimate.Source)
+	assert.Equal(t, 2, estimate.Votes)
+
+	// the stored observations are only loaded once
+	_, changed = e.Observe("tx", Observation{Sniffer: "d", Source: "10.0.0.2", Timestamp: 1003 * ms, Uncertainty: 5 * ms})
+	assert.False(t, changed)
+	assert.Equal(t, 1, loads)
+
+	// the observation already stored still gives the estimate of a new transaction
+	estimate, changed = NewWithLoader(time.Minute, func(string) []Observation { return stored }).
+		Observe("tx", stored[0])
+	assert.True(t, changed)
+	assert.Equal(t, 2, estimate.Votes)
+}
@@ -3,23 +3,81 @@ package main
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"net"
 	"strings"
 	"time"
 
 	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/AlaricGilbert/argos-core/master/auth"
 	"github.com/AlaricGilbert/argos-core/master/dal"
+	"github.com/AlaricGilbert/argos-core/master/estimator"
 	"github.com/AlaricGilbert/argos-core/master/kitex_gen/base"
 	"github.com/AlaricGilbert/argos-core/master/kitex_gen/master"
 	"github.com/AlaricGilbert/argos-core/master/metrics"
 	"github.com/AlaricGilbert/argos-core/master/model"
+	"github.com/apache/thrift/lib/go/thrift"
 )
 
 // ArgosMasterImpl implements the last service interface defined in the IDL.
 type ArgosMasterImpl struct{}
 
+// verifier authenticates the sniffer requests, requests are not authenticated when it is nil
+var verifier *auth.Verifier
+
+// authKey signs the answers to the authenticated pings, they are not signed when it is empty
+var authKey []byte
+
+// errMalformedReport means the report misses the fields needed to record it
+var errMalformedReport = errors.New("malformed report")
+
+// globalEstimator merges the first-seen reports of all sniffers into the conclusions of estimator.Method
+var globalEstimator = estimator.NewWithLoader(estimator.DefaultWindow, loadObservations)
+
+// authenticate checks the request is signed by the shared key, auth is the auth field of the request
+func authenticate(req thrift.TStruct, a *base.Auth) bool {
+	if verifier == nil {
+		return true
+	}
+	if err := verifier.Verify(req, a); err != nil {
+		argos.StandardLogger().WithError(err).Warn("unauthenticated request rejected")
+		return false
+	}
+	return true
+}
+
+// unauthenticated is the status of the rejected unauthenticated requests
+func unauthenticated() *base.ResponseStatus {
+	return &base.ResponseStatus{
+		Code:    base.StatusUnauthenticated,
+		Message: base.MessageUnauthenticated,
+	}
+}
+
+// checkSession checks the session was issued to the sniffer by ping, the status rejecting the request is
+// returned otherwise
+func checkSession(identifier, session string) *base.ResponseStatus {
+	ok, err := dal.CheckSession(identifier, session)
+	if err != nil {
+		argos.StandardLogger().WithError(err).WithField("identifier", identifier).Warn("check session failed")
+		return &base.ResponseStatus{
+			Code:    base.StatusInternalError,
+			Message: err.Error(),
+		}
+	}
+	if !ok {
+		argos.StandardLogger().WithField("identifier", identifier).Warn("request of an invalid session rejected")
+		return &base.ResponseStatus{
+			Code:    base.StatusInvalidSession,
+			Message: base.MessageInvalidSession,
+		}
+	}
+	return nil
+}
+
 // Ping implements the ArgosMasterImpl interface.
 func (s *ArgosMasterImpl) Ping(ctx context.Context, req *master.PingRequest) (resp *master.PingResponse, err error) {
+	defer func() { metrics.MarkRPC("Ping", resp.GetStatus()) }()
 	tt := time.Now().UnixNano()
 	logger := argos.StandardLogger()
 
@@ -39,38 +97,236 @@ func (s *ArgosMasterImpl) Ping(ctx context.Context, req *master.PingRequest) (re
 		return badResp, nil
 	}
 
+	if !authenticate(req, req.GetAuth()) {
+		return &master.PingResponse{Status: unauthenticated()}, nil
+	}
+	// the answer carries the task, so it is signed for the sniffer to check it comes from the master
+	if len(authKey) > 0 {
+		defer func() {
+			resp.Auth = &base.Auth{}
+			if err := auth.SignResponse(authKey, resp, resp.Auth, req.GetAuth()); err != nil {
+				logger.WithError(err).Error("sign ping response failed")
+			}
+		}()
+	}
+
 	id := req.GetIdentifier()
 	protocol := ""
+	var taskConfig *master.TaskConfig
 	if pref, _, ok := strings.Cut(id, "-"); ok {
 		if task, err := dal.GetTask(pref); err == nil {
 			protocol = task.Protocol
+			taskConfig = newTaskConfig(task)
 		} else {
 			logger.WithError(err).Info("query task failed")
 		}
 	}
 
+	// refresh the registry, the session is issued by the registry, so the ping is not answered with a session
+	// when the registry is not available or another live sniffer holds the identifier
+	sniffer := &model.Sniffer{
+		Identifier:    id,
+		Protocol:      protocol,
+		Build:         req.GetBuild(),
+		OutboundPeers: req.GetOutboundPeers(),
+		InboundPeers:  req.GetInboundPeers(),
+		Uptime:        req.GetUptime(),
+		LastPing:      tt,
+		Session:       req.GetSession(),
+	}
+	if err := dal.RegisterSniffer(sniffer); errors.Is(err, dal.ErrIdentifierConflict) {
+		logger.WithField("identifier", id).Warn("ping rejected since identifier conflicts")
+		return &master.PingResponse{
+			Status: &base.ResponseStatus{
+				Code:    base.StatusIdentifierConflict,
+				Message: base.MessageIdentifierConflict,
+			},
+		}, nil
+	} else if err != nil {
+		logger.WithError(err).WithField("identifier", id).Warn("register sniffer failed")
+		return &master.PingResponse{
+			Status: &base.ResponseStatus{
+				Code:    base.StatusInternalError,
+				Message: base.MessageInternalError,
+			},
+		}, nil
+	}
+
 	resp = &master.PingResponse{
 		Status: &base.ResponseStatus{
 			Code:    base.StatusOK,
 			Message: "",
 		},
 		Protocol: protocol,
+		Task:     taskConfig,
+		Session:  thrift.StringPtr(sniffer.Session),
 		TimeSync: &master.TimeSync{
 			SendTimestamp: req.GetTimestamp(),
 			RecvTimestamp: tt,
-			RespTimestamp: time.Now().Unix(),
+			RespTimestamp: time.Now().UnixNano(),
 		},
 	}
 	logger.WithField("resp", resp).Info("ponged")
 	return
 }
 
+// newTaskConfig converts the task into the config pushed to sniffers, a malformed config is logged and
+// only the protocol is pushed
+func newTaskConfig(task *model.Task) *master.TaskConfig {
+	config, err := task.GetConfig()
+	if err != nil {
+		argos.StandardLogger().WithError(err).WithField("prefix", task.Prefix).Warn("malformed task config ignored")
+		config = model.TaskConfig{}
+	}
+	return &master.TaskConfig{
+		Revision:          task.Revision,
+		Protocol:          task.Protocol,
+		Network:           config.Network,
+		Estimators:        config.Estimators,
+		Thresholds:        config.Thresholds,
+		MaxPeers:          config.MaxPeers,
+		FeeFilter:         config.FeeFilter,
+		FetchTransactions: config.FetchTransactions,
+		Seeds:             config.Seeds,
+	}
+}
+
 // Report implements the ArgosMasterImpl interface.
 func (s *ArgosMasterImpl) Report(ctx context.Context, req *master.ReportRequest) (resp *master.ReportResponse, err error) {
+	defer func() { metrics.MarkRPC("Report", resp.GetStatus()) }()
 	logger := argos.StandardLogger()
 	logger.WithField("report", req).Info("received report")
 
-	metrics.ReportMetrics.Mark(1)
+	if req == nil || !authenticate(req, req.GetAuth()) {
+		return &master.ReportResponse{Status: unauthenticated()}, nil
+	}
+	if status := checkSession(req.GetIdentifier(), req.GetSession()); status != nil {
+		return &master.ReportResponse{Status: status}, nil
+	}
+
+	if err := saveReport(req); errors.Is(err, errMalformedReport) {
+		return &master.ReportResponse{
+			Status: &base.ResponseStatus{
+				Code:    base.StatusInvalidArgument,
+				Message: base.MessageInvalidArgument,
+			},
+		}, nil
+	} else if err != nil {
+		return &master.ReportResponse{
+			Status: &base.ResponseStatus{
+				Code:    base.StatusInternalError,
+				Message: err.Error(),
+			},
+		}, nil
+	}
+
+	return &master.ReportResponse{
+		Status: &base.ResponseStatus{
+			Code:    base.StatusOK,
+			Message: "",
+		},
+	}, nil
+}
+
+// ReportBatch implements the ArgosMasterImpl interface.
+func (s *ArgosMasterImpl) ReportBatch(ctx context.Context, req *master.ReportBatchRequest) (resp *master.ReportBatchResponse, err error) {
+	defer func() { metrics.MarkRPC("ReportBatch", resp.GetStatus()) }()
+	logger := argos.StandardLogger()
+	if req == nil || !authenticate(req, req.GetAuth()) {
+		return &master.ReportBatchResponse{Status: unauthenticated()}, nil
+	}
+	if status := checkSession(req.GetIdentifier(), req.GetSession()); status != nil {
+		return &master.ReportBatchResponse{Status: status}, nil
+	}
+	logger.WithField("identifier", req.GetIdentifier()).WithField("reports", len(req.GetReports())).Info("received report batch")
+
+	var accepted int32 = 0
+	for i, r := range req.GetReports() {
+		err := saveReport(r)
+		if errors.Is(err, errMalformedReport) {
+			continue
+		}
+		// the reports are saved in order, so the sniffer resends the ones from the failed report on
+		if err != nil {
+			handled := int32(i)
+			return &master.ReportBatchResponse{
+				Status: &base.ResponseStatus{
+					Code:    base.StatusInternalError,
+					Message: err.Error(),
+				},
+				Accepted: accepted,
+				Handled:  &handled,
+			}, nil
+		}
+		accepted++
+	}
+
+	return &master.ReportBatchResponse{
+		Status: &base.ResponseStatus{
+			Code:    base.StatusOK,
+			Message: "",
+		},
+		Accepted: accepted,
+	}, nil
+}
+
+// ReportObservations implements the ArgosMasterImpl interface.
+func (s *ArgosMasterImpl) ReportObservations(ctx context.Context, req *master.ObservationBatchRequest) (resp *master.ObservationBatchResponse, err error) {
+	defer func() { metrics.MarkRPC("ReportObservations", resp.GetStatus()) }()
+	logger := argos.StandardLogger()
+	if req == nil || !authenticate(req, req.GetAuth()) {
+		return &master.ObservationBatchResponse{Status: unauthenticated()}, nil
+	}
+	if status := checkSession(req.GetIdentifier(), req.GetSession()); status != nil {
+		return &master.ObservationBatchResponse{Status: status}, nil
+	}
+	logger.WithField("identifier", req.GetIdentifier()).WithField("observations", len(req.GetObservations())).Debug("received observation batch")
+
+	observations := make([]model.Observation, 0, len(req.GetObservations()))
+	for _, o := range req.GetObservations() {
+		if o == nil || len(o.Txid) == 0 || len(o.Ip) == 0 {
+			continue
+		}
+		observations = append(observations, model.Observation{
+			Txid:           hex.EncodeToString(o.Txid),
+			Timestamp:      o.Timestamp,
+			SourceIp:       net.IP(o.Ip).String(),
+			SourcePort:     o.Port,
+			Sniffer:        req.Identifier,
+			Protocol:       req.Protocol,
+			Uncertainty:    o.Uncertainty,
+			OfferedTxRcncl: o.GetOfferedTxrcncl(),
+		})
+	}
+
+	if err := dal.CreateObservations(observations); err != nil {
+		logger.WithError(err).WithField("observations", len(observations)).Warn("observations create failed")
+		return &master.ObservationBatchResponse{
+			Status: &base.ResponseStatus{
+				Code:    base.StatusInternalError,
+				Message: err.Error(),
+			},
+		}, nil
+	}
+
+	return &master.ObservationBatchResponse{
+		Status: &base.ResponseStatus{
+			Code:    base.StatusOK,
+			Message: "",
+		},
+		Accepted: int32(len(observations)),
+	}, nil
+}
+
+// saveReport records the report and updates the conclusion of its transaction, errMalformedReport is returned
+// when the report is malformed and the database error when it could not be recorded
+func saveReport(req *master.ReportRequest) error {
+	logger := argos.StandardLogger()
+
+	if req == nil || req.Transaction == nil || req.Transaction.From == nil {
+		logger.WithField("report", req).Warn("malformed report ignored")
+		return errMalformedReport
+	}
 
 	r := model.Record{
 		Txid:      hex.EncodeToString(req.Transaction.Txid),
@@ -79,20 +335,75 @@ func (s *ArgosMasterImpl) Report(ctx context.Context, req *master.ReportRequest)
 		Sniffer:   req.Identifier,
 		Protocol:  req.Protocol,
 		Method:    req.Method,
+		// reports of the sniffers without clock sync carry no uncertainty
+		Uncertainty:    req.GetUncertainty(),
+		OfferedTxRcncl: req.GetOfferedTxrcncl(),
 	}
 
 	if err := dal.CreateRecord(&r); err != nil {
-		logger.WithField("record", r).Info("record create failed")
+		logger.WithError(err).WithField("record", r).Warn("record create failed")
+		return err
 	}
 
 	if err := dal.CreateOrUpdateConclustion(&r); err != nil {
-		logger.WithField("conclustion", r).Info("conclustion create or update failed")
+		logger.WithError(err).WithField("conclustion", r).Warn("conclustion create or update failed")
+		return err
 	}
+	metrics.ReportMetrics.Mark(1)
+	metrics.MarkReport(req.Method, req.Protocol, req.Identifier)
 
-	return &master.ReportResponse{
-		Status: &base.ResponseStatus{
-			Code:    base.StatusOK,
-			Message: "",
-		},
-	}, nil
+	if r.Method == estimator.MethodFirstTimestamp {
+		estimate(&r)
+	}
+	return nil
+}
+
+// loadObservations returns the stored first-seen records of the transaction, so that the estimator does not
+// replace the conclusion they back by one estimated from the records arriving after the window or a restart
+func loadObservations(txid string) []estimator.Observation {
+	records, err := dal.GetRecordsWithTxid(txid)
+	if err != nil {
+		argos.StandardLogger().WithError(err).WithField("txid", txid).Warn("load records of the estimator failed")
+		return nil
+	}
+	observations := make([]estimator.Observation, 0, len(records))
+	for _, r := range records {
+		if r.Method != estimator.MethodFirstTimestamp {
+			continue
+		}
+		observations = append(observations, estimator.Observation{
+			Sniffer:     r.Sniffer,
+			Source:      r.SourceIp,
+			Timestamp:   r.Timestamp,
+			Uncertainty: r.Uncertainty,
+		})
+	}
+	return observations
+}
+
+// estimate merges the first-seen record into the global estimate of its transaction, and saves the
+// estimate when it changed
+func estimate(r *model.Record) {
+	e, changed := globalEstimator.Observe(r.Txid, estimator.Observation{
+		Sniffer:     r.Sniffer,
+		Source:      r.SourceIp,
+		Timestamp:   r.Timestamp,
+		Uncertainty: r.Uncertainty,
+	})
+	if !changed {
+		return
+	}
+
+	conclusion := model.Record{
+		Txid:        r.Txid,
+		Timestamp:   e.Timestamp,
+		SourceIp:    e.Source,
+		Sniffer:     e.Sniffer,
+		Protocol:    r.Protocol,
+		Method:      estimator.Method,
+		Uncertainty: e.Uncertainty,
+	}
+	if err := dal.SaveConclusion(&conclusion); err != nil {
+		argos.StandardLogger().WithError(err).WithField("conclusion", conclusion).Info("global conclusion save failed")
+	}
 }
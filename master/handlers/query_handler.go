@@ -99,3 +99,23 @@ func QueryByTx(c *gin.Context) {
 		retData(c, result)
 	}
 }
+
+// QueryObservations returns the raw observations of the transaction sent by the sniffers in the raw observation mode
+func QueryObservations(c *gin.Context) {
+	txid := c.Query("txid")
+	if txid == "" {
+		retErrMsg(c, "txid should not be empty")
+		return
+	}
+
+	if _, err := hex.DecodeString(txid); err != nil {
+		retErrMsg(c, "txid is not valid")
+		return
+	}
+
+	if result, err := dal.GetObservationsWithTxid(txid); err != nil {
+		retErr(c, err)
+	} else {
+		retData(c, result)
+	}
+}
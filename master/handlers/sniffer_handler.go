@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"strconv"
+
+	"github.com/AlaricGilbert/argos-core/master/dal"
+	"github.com/gin-gonic/gin"
+)
+
+// GetSniffers lists the sniffers, the online query filters them by liveness
+func GetSniffers(c *gin.Context) {
+	var online *bool
+	if q := c.Query("online"); q != "" {
+		v, err := strconv.ParseBool(q)
+		if err != nil {
+			retErrMsg(c, "online should be true or false")
+			return
+		}
+		online = &v
+	}
+
+	if sniffers, err := dal.GetSnifferList(online); err == nil {
+		retData(c, sniffers)
+	} else {
+		retErr(c, err)
+	}
+}
+
+// RemoveSniffer forgets a retired sniffer
+func RemoveSniffer(c *gin.Context) {
+	identifier := c.Query("identifier")
+	if identifier == "" {
+		retErrMsg(c, "identifier cannot be empty")
+		return
+	}
+	retUnwarpErr(c, dal.RemoveSniffer(identifier))
+}
@@ -1,6 +1,9 @@
 package handlers
 
 import (
+	"strconv"
+	"time"
+
 	"github.com/AlaricGilbert/argos-core/master/metrics"
 	"github.com/gin-gonic/gin"
 )
@@ -8,6 +11,44 @@ import (
 func GetReportStatus(c *gin.Context) {
 	c.JSON(200, gin.H{
 		"code": 200,
-		"data": metrics.ReportMetrics.GetMetrics(),
+		"data": metrics.ReportHistory(),
 	})
 }
+
+// QuerySeries returns the points of the series of the name between from and to, in unix seconds, the last hour
+// by default. The other queries filter the series by their labels, e.g. ?name=master.reports&method=FTE.
+// The names of the series are listed when the name is empty.
+func QuerySeries(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		retData(c, metrics.History.Names())
+		return
+	}
+
+	to := time.Now()
+	if q := c.Query("to"); q != "" {
+		ts, err := strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			retErrMsg(c, "to should be a unix timestamp")
+			return
+		}
+		to = time.Unix(ts, 0)
+	}
+	from := to.Add(-time.Hour)
+	if q := c.Query("from"); q != "" {
+		ts, err := strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			retErrMsg(c, "from should be a unix timestamp")
+			return
+		}
+		from = time.Unix(ts, 0)
+	}
+
+	labels := make(map[string]string)
+	for k, v := range c.Request.URL.Query() {
+		if k != "name" && k != "from" && k != "to" && len(v) > 0 {
+			labels[k] = v[0]
+		}
+	}
+	retData(c, metrics.History.Query(name, labels, from, to))
+}
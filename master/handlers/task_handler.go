@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"encoding/json"
+
 	"github.com/AlaricGilbert/argos-core/master/dal"
+	"github.com/AlaricGilbert/argos-core/master/model"
 	"github.com/gin-gonic/gin"
 )
 
@@ -42,3 +45,34 @@ func WriteTask(c *gin.Context) {
 		}
 	}
 }
+
+// WriteTaskConfig replaces the structured config of an existing task by the json body
+func WriteTaskConfig(c *gin.Context) {
+	prefix := c.Query("prefix")
+	if prefix == "" {
+		retErrMsg(c, "prefix cannot be empty")
+		return
+	}
+
+	var config model.TaskConfig
+	if err := c.ShouldBindJSON(&config); err != nil {
+		retErr(c, err)
+		return
+	}
+	if config.MaxPeers < 0 || config.FeeFilter != nil && *config.FeeFilter < 0 {
+		retErrMsg(c, "max_peers and fee_filter cannot be negative")
+		return
+	}
+
+	if _, err := dal.GetTask(prefix); err != nil {
+		retErrMsg(c, "no such task")
+		return
+	}
+
+	data, err := json.Marshal(&config)
+	if err != nil {
+		retErr(c, err)
+		return
+	}
+	retUnwarpErr(c, dal.UpdateTaskConfig(prefix, string(data)))
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"net"
-	"time"
+	"os"
 
 	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
+	"github.com/AlaricGilbert/argos-core/master/auth"
 	"github.com/AlaricGilbert/argos-core/master/config"
 	"github.com/AlaricGilbert/argos-core/master/dal"
 	"github.com/AlaricGilbert/argos-core/master/handlers"
@@ -14,50 +17,180 @@ import (
 	"github.com/AlaricGilbert/argos-core/master/metrics"
 	"github.com/cloudwego/kitex/server"
 	"github.com/gin-gonic/gin"
-	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
 )
 
+const usage = `usage: master <command> [flags]
+
+commands:
+  run                 run the master, the default command
+  migrate             migrate the database schema to the latest version and exit
+  init-config         write the default config file
+  version             print the build version
+
+run "master <command> -h" for the flags of a command
+`
+
+// options are the flags common to the commands
+type options struct {
+	configFile string
+	logDir     string
+	logLevel   string
+}
+
+// command is a subcommand of the master binary, it returns the exit code
+type command func(args []string) int
+
+var commands = map[string]command{
+	"run":         runCommand,
+	"migrate":     migrateCommand,
+	"init-config": initConfigCommand,
+	"version":     versionCommand,
+}
+
 func main() {
-	logger := logrus.New()
-	logger.AddHook(lfshook.NewHook(
-		fmt.Sprintf("logs/%s_master.log", time.Now().Format(time.RFC3339)),
-		&logrus.TextFormatter{
-			FullTimestamp: true,
-			DisableColors: true,
-		},
-	))
+	name, args := "run", os.Args[1:]
+	// the master is run when no command is given, so the flags of run can be passed directly
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	os.Exit(cmd(args))
+}
+
+// newFlagSet creates the flag set of the command with the flags common to all commands
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&opts.configFile, "config", "", "path of the json config file, only the defaults and ARGOS_* environment variables are used when empty")
+	fs.StringVar(&opts.logDir, "log-dir", "", "directory of the log files, overrides the log_dir of the config")
+	fs.StringVar(&opts.logLevel, "log-level", "info", "lowest level logged, one of trace, debug, info, warn, error")
+	return fs
+}
+
+// setup loads the config and creates the logger of the command
+func setup(opts options) (*config.Config, *logrus.Logger, error) {
+	cfg, err := config.Load(opts.configFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load config failed: %w", err)
+	}
+	if opts.logDir != "" {
+		cfg.LogDir = opts.logDir
+	}
+	logger, err := argos.NewLogger(cfg.LogDir, "_master", opts.logLevel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create logger failed: %w", err)
+	}
 
 	argos.SetLogger(logger)
+	return cfg, logger, nil
+}
+
+func runCommand(args []string) int {
+	var opts options
+	_ = newFlagSet("run", &opts).Parse(args)
+
+	cfg, logger, err := setup(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.HMACKey != "" {
+		verifier = auth.NewVerifier([]byte(cfg.HMACKey), auth.DefaultWindow)
+		authKey = []byte(cfg.HMACKey)
+	} else {
+		logger.Warn("hmac key not configured, sniffer requests are not authenticated")
+	}
 
-	go metrics.ReportMetrics.Host()
-	dal.InitDatabase()
-	go startGinServer()
+	metrics.SetHistory(cfg.GetMetricsInterval(), cfg.MetricsHistory)
+	go metrics.Host()
+	dal.InitDatabase(cfg)
+	go startGinServer(cfg.WebListenAddr)
 
-	addr, _ := net.ResolveTCPAddr("tcp", "localhost:4222")
+	addr, err := net.ResolveTCPAddr("tcp", cfg.RpcListenAddr)
+	if err != nil {
+		log.Fatalf("resolve rpc listen address failed: %v", err)
+	}
 
 	svr := master.NewServer(new(ArgosMasterImpl), server.WithServiceAddr(addr))
 
-	err := svr.Run()
+	err = svr.Run()
 
 	if err != nil {
 		log.Println(err.Error())
+		return 1
+	}
+	return 0
+}
+
+func migrateCommand(args []string) int {
+	var opts options
+	_ = newFlagSet("migrate", &opts).Parse(args)
+
+	cfg, logger, err := setup(opts)
+	if err != nil {
+		log.Println(err)
+		return 1
 	}
+	// the schema is migrated when the database is opened
+	if err = dal.Open(cfg.DbDriver, cfg.DbConnDsn); err != nil {
+		logger.WithError(err).Error("database migration failed")
+		return 1
+	}
+	return 0
 }
 
-func startGinServer() {
+func initConfigCommand(args []string) int {
+	var opts options
+	fs := newFlagSet("init-config", &opts)
+	force := fs.Bool("force", false, "overwrite the config file when it exists")
+	_ = fs.Parse(args)
+
+	if opts.configFile == "" {
+		opts.configFile = "master.json"
+	}
+	if _, err := os.Stat(opts.configFile); err == nil && !*force {
+		fmt.Fprintf(os.Stderr, "%s exists, use -force to overwrite it\n", opts.configFile)
+		return 1
+	}
+	if err := config.Default().Save(opts.configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "write config failed: %v\n", err)
+		return 1
+	}
+	fmt.Println(opts.configFile)
+	return 0
+}
+
+func versionCommand(args []string) int {
+	fmt.Println(argos.Version())
+	return 0
+}
+
+func startGinServer(addr string) {
 	r := gin.Default()
 
 	task := r.Group("task")
 	task.GET("/list", handlers.GetTasks)
 	task.POST("/write", handlers.WriteTask)
+	task.POST("/config", handlers.WriteTaskConfig)
+
+	sniffer := r.Group("sniffer")
+	sniffer.GET("/list", handlers.GetSniffers)
+	sniffer.POST("/remove", handlers.RemoveSniffer)
 
 	status := r.Group("status")
 	status.GET("/report", handlers.GetReportStatus)
+	status.GET("/series", handlers.QuerySeries)
+
+	r.GET("/metrics", gin.WrapH(exporter.Handler(nil)))
 
 	query := r.Group("query")
 	query.GET("/time", handlers.QueryByTime)
 	query.GET("/ip", handlers.QueryByIP)
 	query.GET("/tx", handlers.QueryByTx)
-	r.Run(config.WebListenAddr) // listen and serve on 0.0.0.0:8080
+	query.GET("/observations", handlers.QueryObservations)
+	r.Run(addr) // listen and serve on 0.0.0.0:8080 by default
 }
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
+	"github.com/rcrowley/go-metrics"
+)
+
+// collector samples the metrics of a registry into a store. The series are named and labelled as the
+// metrics registered by exporter.Name: counters are recorded as their rate per second since the last
+// sample, meters as their one-minute rate, gauges as their value and timers and histograms as their mean,
+// timers in seconds.
+type collector struct {
+	registry metrics.Registry
+	interval time.Duration
+	// counts are the counts of the counters at the last sample
+	counts map[string]int64
+	last   time.Time
+}
+
+func newCollector(registry metrics.Registry, interval time.Duration) *collector {
+	return &collector{
+		registry: registry,
+		interval: interval,
+		counts:   make(map[string]int64),
+	}
+}
+
+// collect records a sample of each metric into the store at now, and expires the points out of retention
+func (c *collector) collect(store *Store, now time.Time) {
+	elapsed := now.Sub(c.last).Seconds()
+	c.registry.Each(func(registered string, i interface{}) {
+		name, labels := exporter.Parse(registered)
+		switch m := i.(type) {
+		case metrics.Counter:
+			count := m.Count()
+			if last, ok := c.counts[registered]; ok && elapsed > 0 && count >= last {
+				store.Record(name, labels, float64(count-last)/elapsed, now)
+			}
+			c.counts[registered] = count
+		case metrics.Gauge:
+			store.Record(name, labels, float64(m.Value()), now)
+		case metrics.GaugeFloat64:
+			store.Record(name, labels, m.Value(), now)
+		case metrics.Meter:
+			store.Record(name, labels, m.Snapshot().Rate1(), now)
+		case metrics.Histogram:
+			store.Record(name, labels, m.Snapshot().Mean(), now)
+		case metrics.Timer:
+			store.Record(name, labels, m.Snapshot().Mean()/float64(time.Second), now)
+		}
+	})
+	c.last = now
+	store.Expire(now)
+}
+
+// run collects into the store every interval, it never returns
+func (c *collector) run(store *Store) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		c.collect(store, now)
+	}
+}
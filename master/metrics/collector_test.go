@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
+	"github.com/rcrowley/go-metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollect(t *testing.T) {
+	r := metrics.NewRegistry()
+	s := NewStore()
+	c := newCollector(r, time.Minute)
+	now := time.Now().Truncate(time.Minute)
+	s.now = func() time.Time { return now.Add(2 * time.Minute) }
+
+	reports := metrics.GetOrRegisterCounter(exporter.Name("master.reports", "method", "FTE"), r)
+	peers := metrics.GetOrRegisterGauge("sniffer.peers", r)
+	latency := metrics.GetOrRegisterTimer("master.db.latency", r)
+
+	reports.Inc(10)
+	peers.Update(8)
+	latency.Update(20 * time.Millisecond)
+	c.collect(s, now)
+
+	// counters are recorded as rates from the second sample
+	reports.Inc(120)
+	c.collect(s, now.Add(time.Minute))
+
+	series := s.Query("master.reports", map[string]string{"method": "FTE"}, now, now.Add(time.Minute))
+	assert.Len(t, series, 1)
+	assert.Equal(t, []Point{{Timestamp: now.Add(time.Minute).Unix(), Value: 2}}, series[0].Points)
+
+	series = s.Query("sniffer.peers", nil, now, now.Add(time.Minute))
+	assert.Len(t, series[0].Points, 2)
+	assert.Equal(t, float64(8), series[0].Points[0].Value)
+
+	series = s.Query("master.db.latency", nil, now, now.Add(time.Minute))
+	assert.InDelta(t, 0.02, series[0].Points[0].Value, 1e-9)
+}
+
+func TestReportHistory(t *testing.T) {
+	SetHistory(time.Minute, 60)
+	now := time.Now()
+	History.Record(reportSeries, nil, 1, now.Add(-30*time.Minute))
+	History.Record(reportSeries, nil, 2, now)
+	assert.Equal(t, []float64{1, 2}, ReportHistory())
+}
@@ -1,61 +1,80 @@
 package metrics
 
 import (
-	"container/list"
-	"sync"
+	"strconv"
 	"time"
 
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/base"
 	"github.com/rcrowley/go-metrics"
 )
 
+// UpdateInterval is the default interval the metrics are sampled into History
 const UpdateInterval = time.Minute
 
+// reportSeries is the name of the meter of the reports, and of its series
+const reportSeries = "report"
+
 var (
-	ReportMetrics = NewHistoricalMeter("report")
+	// ReportMetrics is the rate of the reports accepted from all sniffers
+	ReportMetrics = metrics.GetOrRegisterMeter(reportSeries, nil)
+	// History keeps the history of the metrics of the default registry, sampled by Host
+	History = NewStore()
+	// sampler samples the default registry every interval
+	sampler = newCollector(metrics.DefaultRegistry, UpdateInterval)
 )
 
-type HistoricalMeter struct {
-	meter    metrics.Meter
-	history  *list.List
-	interval time.Duration
-	maximum  int
-	mu       sync.Mutex
-}
-
-func NewHistoricalMeter(name string) *HistoricalMeter {
-	return &HistoricalMeter{
-		meter:    metrics.GetOrRegisterMeter(name, nil),
-		history:  list.New(),
-		interval: UpdateInterval,
-		maximum:  60,
-		mu:       sync.Mutex{},
+// SetHistory keeps a sample of the metrics every interval for maximum samples, and an hourly average for a
+// week when the interval is shorter than an hour. It should be called before Host.
+func SetHistory(interval time.Duration, maximum int) {
+	resolutions := []Resolution{{Step: interval, Retention: interval * time.Duration(maximum)}}
+	if coarse := DefaultResolutions[len(DefaultResolutions)-1]; interval < coarse.Step {
+		resolutions = append(resolutions, coarse)
 	}
+	History = NewStore(resolutions...)
+	sampler.interval = interval
 }
 
-func (m *HistoricalMeter) Mark(n int64) {
-	m.meter.Mark(n)
+// Host samples the metrics of the default registry into History every interval, it never returns
+func Host() {
+	sampler.run(History)
 }
 
-func (m *HistoricalMeter) Host() {
-	tick := time.Tick(m.interval)
-	for range tick {
-		m.mu.Lock()
-		if m.history.Len() == 60 {
-			m.history.Remove(m.history.Front())
+// ReportHistory returns the samples of the report rate kept at the finest resolution, the oldest first
+func ReportHistory() []float64 {
+	r := History.resolutions[0]
+	now := time.Now()
+	var history = make([]float64, 0)
+	// the oldest step kept starts after the retention, which keeps the query at the finest resolution
+	for _, series := range History.Query(reportSeries, nil, now.Add(r.Step-r.Retention), now) {
+		for _, p := range series.Points {
+			history = append(history, p.Value)
 		}
-		m.history.PushBack(m.meter.Rate1())
-		m.mu.Unlock()
 	}
+	return history
+}
+
+// MarkReport counts a report accepted from the sniffer
+func MarkReport(method, protocol, sniffer string) {
+	metrics.GetOrRegisterCounter(exporter.Name("master.reports",
+		"method", method, "protocol", protocol, "sniffer", sniffer), nil).Inc(1)
 }
 
-func (m *HistoricalMeter) GetMetrics() []float64 {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	history := make([]float64, m.history.Len())
-	i := 0
-	for e := m.history.Front(); e != nil; e = e.Next() {
-		history[i] = e.Value.(float64)
-		i++
+// MarkRPC counts a call of the rpc method answered with the status, calls not answered with StatusOK are
+// also counted as errors
+func MarkRPC(method string, status *base.ResponseStatus) {
+	metrics.GetOrRegisterCounter(exporter.Name("master.rpc.requests", "method", method), nil).Inc(1)
+	var code int32 = base.StatusInternalError
+	if status != nil {
+		code = status.Code
 	}
-	return history
+	if code != base.StatusOK {
+		metrics.GetOrRegisterCounter(exporter.Name("master.rpc.errors",
+			"method", method, "code", strconv.Itoa(int(code))), nil).Inc(1)
+	}
+}
+
+// ObserveQuery records the latency of a database operation
+func ObserveQuery(operation string, d time.Duration) {
+	metrics.GetOrRegisterTimer(exporter.Name("master.db.latency", "operation", operation), nil).Update(d)
 }
@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Resolution is a granularity the series are kept at, samples are averaged into buckets of Step and the
+// buckets older than Retention are dropped
+type Resolution struct {
+	Step      time.Duration
+	Retention time.Duration
+}
+
+// DefaultResolutions keep a point per minute for an hour and a point per hour for a week
+var DefaultResolutions = []Resolution{
+	{Step: time.Minute, Retention: time.Hour},
+	{Step: time.Hour, Retention: 7 * 24 * time.Hour},
+}
+
+// Point is the average of the samples recorded in the step starting at Timestamp, in unix seconds
+type Point struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+// Series is the points of a named series with labels at a resolution, Step is in seconds
+type Series struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Step   int64             `json:"step"`
+	Points []Point           `json:"points"`
+}
+
+// bucket accumulates the samples recorded in a step
+type bucket struct {
+	start int64
+	sum   float64
+	count int
+}
+
+// series keeps the buckets of a series at each resolution of the store
+type series struct {
+	name    string
+	labels  map[string]string
+	buckets [][]bucket
+}
+
+// Store keeps named series with labels in memory at several resolutions
+type Store struct {
+	resolutions []Resolution
+	series      map[string]*series
+	now         func() time.Time
+	mu          sync.Mutex
+}
+
+// NewStore creates a store keeping the series at the resolutions, which are ordered from the finest one,
+// DefaultResolutions are used when none is given
+func NewStore(resolutions ...Resolution) *Store {
+	if len(resolutions) == 0 {
+		resolutions = DefaultResolutions
+	}
+	resolutions = append([]Resolution(nil), resolutions...)
+	sort.Slice(resolutions, func(i, j int) bool {
+		return resolutions[i].Step < resolutions[j].Step
+	})
+	return &Store{
+		resolutions: resolutions,
+		series:      make(map[string]*series),
+		now:         time.Now,
+	}
+}
+
+// key identifies the series of the name and labels regardless of the order of the labels
+func key(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := []byte(name)
+	for _, k := range keys {
+		b = append(b, 0)
+		b = append(b, k...)
+		b = append(b, 0)
+		b = append(b, labels[k]...)
+	}
+	return string(b)
+}
+
+// Record adds a sample of the series at the time, it is averaged with the other samples of its step at each
+// resolution
+func (s *Store) Record(name string, labels map[string]string, value float64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(name, labels)
+	ss, ok := s.series[k]
+	if !ok {
+		copied := make(map[string]string, len(labels))
+		for k, v := range labels {
+			copied[k] = v
+		}
+		ss = &series{name: name, labels: copied, buckets: make([][]bucket, len(s.resolutions))}
+		s.series[k] = ss
+	}
+
+	for i, r := range s.resolutions {
+		start := at.Truncate(r.Step).Unix()
+		buckets := ss.buckets[i]
+		if n := len(buckets); n > 0 && buckets[n-1].start == start {
+			buckets[n-1].sum += value
+			buckets[n-1].count++
+			continue
+		} else if n > 0 && buckets[n-1].start > start {
+			// samples arriving late for a step already passed are dropped
+			continue
+		}
+
+		// drop the buckets out of the retention before adding the new one
+		ss.buckets[i] = append(expire(buckets, at, r), bucket{start: start, sum: value, count: 1})
+	}
+}
+
+// expire returns the buckets still in the retention of the resolution at now
+func expire(buckets []bucket, now time.Time, r Resolution) []bucket {
+	oldest := now.Add(-r.Retention).Unix()
+	expired := 0
+	for expired < len(buckets) && buckets[expired].start <= oldest {
+		expired++
+	}
+	return buckets[expired:]
+}
+
+// Expire drops the points out of the retention of each resolution, and forgets the series left without points
+func (s *Store) Expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for k, ss := range s.series {
+		empty := true
+		for i, r := range s.resolutions {
+			ss.buckets[i] = expire(ss.buckets[i], now, r)
+			if len(ss.buckets[i]) > 0 {
+				empty = false
+			}
+		}
+		if empty {
+			delete(s.series, k)
+		}
+	}
+}
+
+// resolution returns the index of the finest resolution still keeping the points since from, the coarsest
+// one is returned when none of them does
+func (s *Store) resolution(from, now time.Time) int {
+	for i, r := range s.resolutions {
+		if !from.Before(now.Add(-r.Retention)) {
+			return i
+		}
+	}
+	return len(s.resolutions) - 1
+}
+
+// Query returns the points between from and to of the series of the name having all the labels, at the
+// finest resolution keeping the points since from. Series are ordered by their labels.
+func (s *Store) Query(name string, labels map[string]string, from, to time.Time) []Series {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.resolution(from, s.now())
+	r := s.resolutions[i]
+	// the step containing from is included
+	first, last := from.Truncate(r.Step).Unix(), to.Unix()
+
+	var keys []string
+	for k, ss := range s.series {
+		if ss.name == name && matches(ss.labels, labels) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := make([]Series, 0, len(keys))
+	for _, k := range keys {
+		ss := s.series[k]
+		points := make([]Point, 0)
+		for _, b := range ss.buckets[i] {
+			if b.start >= first && b.start <= last {
+				points = append(points, Point{Timestamp: b.start, Value: b.sum / float64(b.count)})
+			}
+		}
+		result = append(result, Series{
+			Name:   ss.name,
+			Labels: ss.labels,
+			Step:   int64(r.Step / time.Second),
+			Points: points,
+		})
+	}
+	return result
+}
+
+// matches reports whether the labels contain all the wanted labels
+func matches(labels, want map[string]string) bool {
+	for k, v := range want {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Names returns the names of the series kept in order
+func (s *Store) Names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := make(map[string]struct{})
+	names := make([]string, 0)
+	for _, ss := range s.series {
+		if _, ok := seen[ss.name]; !ok {
+			seen[ss.name] = struct{}{}
+			names = append(names, ss.name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
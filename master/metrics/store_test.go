@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreDownsampling(t *testing.T) {
+	s := NewStore(Resolution{Step: time.Hour, Retention: 7 * 24 * time.Hour}, Resolution{Step: time.Minute, Retention: time.Hour})
+	start := time.Unix(1700000000, 0).Truncate(time.Hour)
+	s.now = func() time.Time { return start.Add(2 * time.Hour) }
+
+	// two samples a minute for two hours
+	for i := 0; i < 240; i++ {
+		at := start.Add(time.Duration(i) * 30 * time.Second)
+		s.Record("report", nil, float64(i/120+1), at)
+	}
+
+	// the last hour is kept every minute, the averages of the samples of each minute
+	series := s.Query("report", nil, start.Add(time.Hour), start.Add(2*time.Hour))
+	assert.Len(t, series, 1)
+	assert.Equal(t, int64(60), series[0].Step)
+	assert.Len(t, series[0].Points, 60)
+	assert.Equal(t, Point{Timestamp: start.Add(time.Hour).Unix(), Value: 2}, series[0].Points[0])
+
+	// the points before the last hour are only kept hourly
+	series = s.Query("report", nil, start, start.Add(2*time.Hour))
+	assert.Equal(t, int64(3600), series[0].Step)
+	assert.Equal(t, []Point{{Timestamp: start.Unix(), Value: 1}, {Timestamp: start.Add(time.Hour).Unix(), Value: 2}}, series[0].Points)
+}
+
+func TestStoreLabels(t *testing.T) {
+	s := NewStore()
+	now := time.Now()
+	s.Record("master.reports", map[string]string{"method": "FTE", "sniffer": "a"}, 1, now)
+	s.Record("master.reports", map[string]string{"sniffer": "b", "method": "FTE"}, 2, now)
+	s.Record("master.reports", map[string]string{"method": "RCE", "sniffer": "a"}, 3, now)
+	s.Record("master.db.latency", map[string]string{"operation": "create"}, 0.01, now)
+
+	assert.Equal(t, []string{"master.db.latency", "master.reports"}, s.Names())
+	assert.Len(t, s.Query("master.reports", nil, now.Add(-time.Minute), now), 3)
+
+	series := s.Query("master.reports", map[string]string{"method": "FTE"}, now.Add(-time.Minute), now)
+	assert.Len(t, series, 2)
+	for _, ss := range series {
+		assert.Equal(t, "FTE", ss.Labels["method"])
+		assert.Len(t, ss.Points, 1)
+	}
+	assert.Empty(t, s.Query("master.reports", map[string]string{"method": "GFE"}, now.Add(-time.Minute), now))
+}
+
+func TestStoreExpire(t *testing.T) {
+	s := NewStore(Resolution{Step: time.Minute, Retention: time.Hour})
+	now := time.Now()
+	s.Record("sniffer.peers", map[string]string{"sniffer": "retired"}, 8, now)
+	s.Record("sniffer.peers", map[string]string{"sniffer": "alive"}, 8, now.Add(45*time.Minute))
+
+	// series without points in the retention are forgotten
+	s.Expire(now.Add(90 * time.Minute))
+	s.now = func() time.Time { return now.Add(90 * time.Minute) }
+	series := s.Query("sniffer.peers", nil, now, now.Add(90*time.Minute))
+	assert.Len(t, series, 1)
+	assert.Equal(t, "alive", series[0].Labels["sniffer"])
+}
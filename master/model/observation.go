@@ -0,0 +1,16 @@
+package model
+
+// Observation is a peer announcing a transaction to a sniffer, stored in the raw observation mode
+type Observation struct {
+	ID         int64  `gorm:"column:id" db:"id" json:"-" form:"id"`
+	Txid       string `gorm:"column:txid" db:"txid" json:"txid" form:"txid"`
+	Timestamp  int64  `gorm:"column:timestamp" db:"timestamp" json:"timestamp" form:"timestamp"`
+	SourceIp   string `gorm:"column:source_ip" db:"source_ip" json:"source_ip" form:"source_ip"`
+	SourcePort int32  `gorm:"column:source_port" db:"source_port" json:"source_port" form:"source_port"`
+	Sniffer    string `gorm:"column:sniffer" db:"sniffer" json:"sniffer" form:"sniffer"`
+	Protocol   string `gorm:"column:protocol" db:"protocol" json:"protocol" form:"protocol"`
+	// Uncertainty bounds the error of Timestamp from the master clock, in nanoseconds
+	Uncertainty int64 `gorm:"column:uncertainty" db:"uncertainty" json:"uncertainty" form:"uncertainty"`
+	// OfferedTxRcncl marks that the source sent sendtxrcncl to offer transaction reconciliation
+	OfferedTxRcncl bool `gorm:"column:offered_txrcncl" db:"offered_txrcncl" json:"offered_txrcncl" form:"offered_txrcncl"`
+}
@@ -8,4 +8,8 @@ type Record struct {
 	Sniffer   string `gorm:"column:sniffer" db:"sniffer" json:"sniffer" form:"sniffer"`
 	Protocol  string `gorm:"column:protocol" db:"protocol" json:"protocol" form:"protocol"`
 	Method    string `gorm:"column:method" db:"method" json:"method" form:"method"`
+	// Uncertainty bounds the error of Timestamp from the master clock, in nanoseconds
+	Uncertainty int64 `gorm:"column:uncertainty" db:"uncertainty" json:"uncertainty" form:"uncertainty"`
+	// OfferedTxRcncl marks that the source sent sendtxrcncl, so it may announce fewer transactions by inv
+	OfferedTxRcncl bool `gorm:"column:offered_txrcncl" db:"offered_txrcncl" json:"offered_txrcncl" form:"offered_txrcncl"`
 }
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Sniffer is a sniffer known by the master, it is registered and refreshed by its pings
+type Sniffer struct {
+	ID            int64  `gorm:"column:id" db:"id" json:"-" form:"id"`
+	Identifier    string `gorm:"column:identifier" db:"identifier" json:"identifier" form:"identifier"`
+	Protocol      string `gorm:"column:protocol" db:"protocol" json:"protocol" form:"protocol"`
+	Build         string `gorm:"column:build" db:"build" json:"build" form:"build"`
+	OutboundPeers int32  `gorm:"column:outbound_peers" db:"outbound_peers" json:"outbound_peers" form:"outbound_peers"`
+	InboundPeers  int32  `gorm:"column:inbound_peers" db:"inbound_peers" json:"inbound_peers" form:"inbound_peers"`
+	// Uptime is the seconds since the sniffer started, as reported by its last ping
+	Uptime int64 `gorm:"column:uptime" db:"uptime" json:"uptime" form:"uptime"`
+	// FirstPing and LastPing are the unix nano timestamps of the master receiving the first and the last ping
+	FirstPing int64 `gorm:"column:first_ping" db:"first_ping" json:"first_ping" form:"first_ping"`
+	LastPing  int64 `gorm:"column:last_ping" db:"last_ping" json:"last_ping" form:"last_ping"`
+	// Session is the token issued to the sniffer process holding the identifier
+	Session string `gorm:"column:session" db:"session" json:"-" form:"-"`
+	// Online is computed from LastPing when the sniffer is queried
+	Online bool `gorm:"-" db:"-" json:"online" form:"-"`
+}
+
+// IsOnline checks whether the sniffer pinged within the timeout before now
+func (s *Sniffer) IsOnline(now time.Time, timeout time.Duration) bool {
+	return now.Sub(time.Unix(0, s.LastPing)) <= timeout
+}
@@ -1,7 +1,34 @@
 package model
 
+import "encoding/json"
+
 type Task struct {
 	ID       int64  `gorm:"column:id" db:"id" json:"-" form:"id"`
 	Prefix   string `gorm:"column:prefix" db:"prefix" json:"prefix" form:"prefix"`
 	Protocol string `gorm:"column:protocol" db:"protocol" json:"protocol" form:"protocol"`
+	// Config is the json encoded TaskConfig, empty means the sniffers keep their local settings
+	Config string `gorm:"column:config" db:"config" json:"config" form:"config"`
+	// Revision is increased on every change of the task, so the sniffers know when to apply it
+	Revision int64 `gorm:"column:revision" db:"revision" json:"revision" form:"revision"`
+}
+
+// TaskConfig is the structured config pushed to the sniffers of the task, zero and nil fields keep the sniffer
+// settings. FeeFilter and FetchTransactions are pointers so that a task can switch them off.
+type TaskConfig struct {
+	Network           string             `json:"network"`
+	Estimators        []string           `json:"estimators"`
+	Thresholds        map[string]float64 `json:"thresholds"`
+	MaxPeers          int32              `json:"max_peers"`
+	FeeFilter         *int64             `json:"fee_filter,omitempty"`
+	FetchTransactions *bool              `json:"fetch_transactions,omitempty"`
+	Seeds             []string           `json:"seeds"`
+}
+
+// GetConfig decodes the config of the task
+func (t *Task) GetConfig() (TaskConfig, error) {
+	var config TaskConfig
+	if t.Config == "" {
+		return config, nil
+	}
+	return config, json.Unmarshal([]byte(t.Config), &config)
 }
@@ -0,0 +1,175 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+const (
+	// MaxBloomFilterSize is the maximum size of a bloom filter in bytes
+	MaxBloomFilterSize = 36000
+	// MaxBloomHashFuncs is the maximum number of hash functions of a bloom filter
+	MaxBloomHashFuncs = 50
+	// MaxFilterAddDataSize is the maximum size of an element added by filteradd, which equals the maximum script element size
+	MaxFilterAddDataSize = 520
+)
+
+const (
+	// BLOOM_UPDATE_NONE means the filter is not adjusted when a match is found
+	BLOOM_UPDATE_NONE = 0
+	// BLOOM_UPDATE_ALL means the outpoint is added to the filter when any output script element matches
+	BLOOM_UPDATE_ALL = 1
+	// BLOOM_UPDATE_P2PUBKEY_ONLY means the outpoint is added only when a pay-to-pubkey or multisig output matches
+	BLOOM_UPDATE_P2PUBKEY_ONLY = 2
+	// BLOOM_UPDATE_MASK masks the update mode in the flags
+	BLOOM_UPDATE_MASK = 3
+)
+
+var (
+	// ErrBloomFilterTooLarge means the filter exceeds MaxBloomFilterSize or MaxBloomHashFuncs
+	ErrBloomFilterTooLarge = errors.New("bloom filter too large")
+	// ErrBloomElementTooLarge means the filteradd element exceeds MaxFilterAddDataSize
+	ErrBloomElementTooLarge = errors.New("bloom filter element too large")
+)
+
+// murmurHash3 computes the 32 bits x86 variant of MurmurHash3 which is used by BIP37
+func murmurHash3(seed uint32, data []byte) uint32 {
+	const c1 = 0xcc9e2d51
+	const c2 = 0x1b873593
+
+	h1 := seed
+	nblocks := len(data) / 4
+
+	for i := 0; i < nblocks; i++ {
+		k1 := binary.LittleEndian.Uint32(data[i*4:])
+		k1 *= c1
+		k1 = bits.RotateLeft32(k1, 15)
+		k1 *= c2
+
+		h1 ^= k1
+		h1 = bits.RotateLeft32(h1, 13)
+		h1 = h1*5 + 0xe6546b64
+	}
+
+	tail := data[nblocks*4:]
+	var k1 uint32
+	switch len(tail) {
+	case 3:
+		k1 ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k1 ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k1 ^= uint32(tail[0])
+		k1 *= c1
+		k1 = bits.RotateLeft32(k1, 15)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	h1 ^= uint32(len(data))
+	h1 ^= h1 >> 16
+	h1 *= 0x85ebca6b
+	h1 ^= h1 >> 13
+	h1 *= 0xc2b2ae35
+	h1 ^= h1 >> 16
+	return h1
+}
+
+// BloomFilter is a BIP37 bloom filter, it is loaded by filterload and extended by filteradd
+type BloomFilter struct {
+	data      []byte
+	hashFuncs uint32
+	tweak     uint32
+	flags     uint8
+}
+
+// NewBloomFilter creates an empty filter which holds the given number of elements with the given false positive rate
+func NewBloomFilter(elements uint32, fpRate float64, tweak uint32, flags uint8) *BloomFilter {
+	// an empty filter is sized for one element, which also keeps the number of hash functions defined
+	if elements == 0 {
+		elements = 1
+	}
+	// the integer truncations below are the same as the reference implementation,
+	// so filters created by the same parameters are serialized identically
+	const ln2 = 0.6931471805599453094
+	const ln2Squared = ln2 * ln2
+	size := uint32(math.Min(-1/ln2Squared*float64(elements)*math.Log(fpRate), MaxBloomFilterSize*8)) / 8
+	hashFuncs := uint32(math.Min(float64(size*8/elements)*ln2, MaxBloomHashFuncs))
+	return &BloomFilter{
+		data:      make([]byte, size),
+		hashFuncs: hashFuncs,
+		tweak:     tweak,
+		flags:     flags,
+	}
+}
+
+// NewBloomFilterFromLoad creates the filter described by a filterload message
+func NewBloomFilterFromLoad(load *FilterLoad) (*BloomFilter, error) {
+	if len(load.Filter) > MaxBloomFilterSize || load.NHashFuncs > MaxBloomHashFuncs {
+		return nil, ErrBloomFilterTooLarge
+	}
+	data := make([]byte, len(load.Filter))
+	copy(data, load.Filter)
+	return &BloomFilter{
+		data:      data,
+		hashFuncs: load.NHashFuncs,
+		tweak:     load.NTweak,
+		flags:     load.NFlags,
+	}, nil
+}
+
+// FilterLoad converts the filter into a filterload message
+func (f *BloomFilter) FilterLoad() *FilterLoad {
+	data := make([]byte, len(f.data))
+	copy(data, f.data)
+	return &FilterLoad{
+		Count:      VarInt(len(data)),
+		Filter:     data,
+		NHashFuncs: f.hashFuncs,
+		NTweak:     f.tweak,
+		NFlags:     f.flags,
+	}
+}
+
+func (f *BloomFilter) hash(n uint32, data []byte) uint32 {
+	// 0xFBA4C795 chosen as it guarantees a reasonable bit difference between n*0xFBA4C795 and (n+1)*0xFBA4C795
+	return murmurHash3(n*0xFBA4C795+f.tweak, data) % uint32(len(f.data)*8)
+}
+
+// Insert adds the element into the filter
+func (f *BloomFilter) Insert(data []byte) {
+	if len(f.data) == 0 {
+		return
+	}
+	for i := uint32(0); i < f.hashFuncs; i++ {
+		index := f.hash(i, data)
+		f.data[index>>3] |= 1 << (index & 7)
+	}
+}
+
+// Contains checks whether the element may be in the filter, an empty filter matches everything
+func (f *BloomFilter) Contains(data []byte) bool {
+	if len(f.data) == 0 {
+		return true
+	}
+	for i := uint32(0); i < f.hashFuncs; i++ {
+		index := f.hash(i, data)
+		if f.data[index>>3]&(1<<(index&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add handles a filteradd message
+func (f *BloomFilter) Add(add *FilterAdd) error {
+	if len(add.Data) > MaxFilterAddDataSize {
+		return ErrBloomElementTooLarge
+	}
+	f.Insert(add.Data)
+	return nil
+}
@@ -0,0 +1,110 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/AlaricGilbert/argos-core/argos/serialization"
+	"github.com/cloudwego/netpoll"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	data, err := hex.DecodeString(s)
+	assert.Nil(t, err)
+	return data
+}
+
+func reversed(data []byte) []byte {
+	r := make([]byte, len(data))
+	for i := range data {
+		r[len(data)-1-i] = data[i]
+	}
+	return r
+}
+
+func TestMurmurHash3(t *testing.T) {
+	// test vectors from the reference implementation
+	var vectors = []struct {
+		expected uint32
+		seed     uint32
+		data     string
+	}{
+		{0x00000000, 0x00000000, ""},
+		{0x6a396f08, 0xFBA4C795, ""},
+		{0x81f16f39, 0xffffffff, ""},
+		{0x514e28b7, 0x00000000, "00"},
+		{0xea3f0b17, 0xFBA4C795, "00"},
+		{0xfd6cf10d, 0x00000000, "ff"},
+		{0x16c6b7ab, 0x00000000, "0011"},
+		{0x8eb51c3d, 0x00000000, "001122"},
+		{0xb4471bf8, 0x00000000, "00112233"},
+		{0xe2301fa8, 0x00000000, "0011223344"},
+		{0xfc2e4a15, 0x00000000, "001122334455"},
+		{0xb074502c, 0x00000000, "00112233445566"},
+		{0x8034d2a0, 0x00000000, "0011223344556677"},
+		{0xb4698def, 0x00000000, "001122334455667788"},
+	}
+	for _, v := range vectors {
+		assert.Equal(t, v.expected, murmurHash3(v.seed, mustDecodeHex(t, v.data)), v.data)
+	}
+}
+
+func TestBloomFilterInsertSerialize(t *testing.T) {
+	initOnce()
+
+	var vectors = []struct {
+		tweak    uint32
+		expected string
+	}{
+		{0, "03614e9b050000000000000001"},
+		{2147483649, "03ce4299050000000100008001"},
+	}
+
+	for _, v := range vectors {
+		filter := NewBloomFilter(3, 0.01, v.tweak, BLOOM_UPDATE_ALL)
+
+		filter.Insert(mustDecodeHex(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8"))
+		assert.True(t, filter.Contains(mustDecodeHex(t, "99108ad8ed9bb6274d3980bab5a85c048f0950c8")))
+		// one bit different in first byte
+		assert.False(t, filter.Contains(mustDecodeHex(t, "19108ad8ed9bb6274d3980bab5a85c048f0950c8")))
+
+		filter.Insert(mustDecodeHex(t, "b5a2c786d9ef4658287ced5914b37a1b4aa32eee"))
+		assert.True(t, filter.Contains(mustDecodeHex(t, "b5a2c786d9ef4658287ced5914b37a1b4aa32eee")))
+
+		filter.Insert(mustDecodeHex(t, "b9300670b4c5366e95b2699e8b18bc75e5f729c5"))
+		assert.True(t, filter.Contains(mustDecodeHex(t, "b9300670b4c5366e95b2699e8b18bc75e5f729c5")))
+
+		buf := netpoll.NewLinkBuffer()
+		n, err := serialization.Serialize(buf, filter.FilterLoad())
+		assert.Nil(t, err)
+		_ = buf.Flush()
+		serialized, err := buf.ReadBinary(n)
+		assert.Nil(t, err)
+		assert.Equal(t, v.expected, hex.EncodeToString(serialized))
+
+		// loading the serialized filter restores the same filter
+		_, _ = buf.WriteBinary(serialized)
+		_ = buf.Flush()
+		var load FilterLoad
+		_, err = serialization.Deserialize(buf, &load)
+		assert.Nil(t, err)
+		loaded, err := NewBloomFilterFromLoad(&load)
+		assert.Nil(t, err)
+		assert.Equal(t, filter, loaded)
+	}
+}
+
+func TestBloomFilterLimits(t *testing.T) {
+	_, err := NewBloomFilterFromLoad(&FilterLoad{Filter: make([]byte, MaxBloomFilterSize+1), NHashFuncs: 1})
+	assert.Equal(t, ErrBloomFilterTooLarge, err)
+	_, err = NewBloomFilterFromLoad(&FilterLoad{Filter: make([]byte, 1), NHashFuncs: MaxBloomHashFuncs + 1})
+	assert.Equal(t, ErrBloomFilterTooLarge, err)
+
+	filter := NewBloomFilter(1, 0.01, 0, BLOOM_UPDATE_NONE)
+	assert.Equal(t, ErrBloomElementTooLarge, filter.Add(&FilterAdd{Data: make([]byte, MaxFilterAddDataSize+1)}))
+	assert.Nil(t, filter.Add(&FilterAdd{Data: make([]byte, MaxFilterAddDataSize)}))
+
+	// a filter of no element is sized as a filter of one
+	assert.Equal(t, NewBloomFilter(1, 0.01, 0, BLOOM_UPDATE_NONE), NewBloomFilter(0, 0.01, 0, BLOOM_UPDATE_NONE))
+}
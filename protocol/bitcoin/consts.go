@@ -1,5 +1,7 @@
 package bitcoin
 
+import "time"
+
 const (
 	MagicMain     NetworkMagic = 0xD9B4BEF9
 	MagicTestnet  NetworkMagic = 0xDAB5BFFA
@@ -66,6 +68,7 @@ const (
 	NODE_XTHIN           ServiceType = 16 // Never formally proposed (as a BIP), and discontinued. Was historically sporadically seen on the network.
 	NODE_COMPACT_FILTERS ServiceType = 64
 	NODE_NETWORK_LIMITED ServiceType = 1024
+	NODE_P2P_V2          ServiceType = 2048 // This node supports BIP324 v2 encrypted transport.
 )
 
 const (
@@ -81,6 +84,12 @@ const (
 
 const BitcoinMessageMaxLength = 4096 * 1024 // 4096 kilobytes
 
+// V2HandshakeTimeout limits the time spent on a BIP324 handshake before falling back to v1 transport
+const V2HandshakeTimeout = 10 * time.Second
+
+// DialTimeout limits the time spent on connecting a remote
+const DialTimeout = 10 * time.Second
+
 const (
 	CommandReject      = "reject"
 	CommandVersion     = "version"
@@ -92,6 +101,7 @@ const (
 	CommandPing        = "ping"
 	CommandPong        = "pong"
 	CommandAddr        = "addr"
+	CommandGetAddr     = "getaddr"
 	CommandFilterAdd   = "filteradd"
 	CommandFilterClear = "filterclear"
 	CommandFilterLoad  = "filterload"
@@ -100,8 +110,29 @@ const (
 	CommandGetHeaders  = "getheaders"
 	CommandHeaders     = "headers"
 	CommandSendCmpct   = "sendcmpct"
+	// BIP339 and BIP155 feature negotiation, sent by remotes between their version and verack
+	CommandWtxidRelay = "wtxidrelay"
+	CommandSendAddrV2 = "sendaddrv2"
+	// BIP330 transaction reconciliation (Erlay) messages
+	CommandSendTxRcncl  = "sendtxrcncl"
+	CommandReqRecon     = "reqrecon"
+	CommandSketch       = "sketch"
+	CommandReqSketchExt = "reqsketchext"
+	CommandReconcilDiff = "reconcildiff"
 )
 
+// MaxFetchedTransactions is the number of fetched transactions remembered, so that a transaction announced by
+// several remotes is only fetched from the first one
+const MaxFetchedTransactions = 50000
+
+// TxReconciliationVersion is the highest BIP330 reconciliation protocol version the peer understands
+const TxReconciliationVersion = 1
+
 const (
 	UserAgent = "/Argos:0.1/"
+	// ProtocolVersion is the protocol version advertised by default, which is the version of current
+	// reference implementations. It makes remotes offer wtxid relay (BIP339) and addrv2 (BIP155), which are
+	// accepted silently but never offered back, so remotes keep announcing transactions by txid and sharing
+	// addresses by addr messages.
+	ProtocolVersion = 70016
 )
@@ -0,0 +1,340 @@
+package bitcoin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// This file contains a small secp256k1 implementation which is just enough for the BIP324 key exchange:
+// scalar multiplication, x-only ECDH and the ElligatorSwift encoding of public keys.
+// It is written on top of math/big and is NOT constant time, which is acceptable for a sniffer that only
+// uses ephemeral keys to talk to public nodes.
+
+var (
+	// secp256k1 field size p = 2^256 - 2^32 - 977
+	secpP, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	// secp256k1 group order n
+	secpN, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	// secp256k1 generator
+	secpGx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secpGy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	// secp256k1 curve constant b in y^2 = x^3 + b
+	secpB = big.NewInt(7)
+	// (p + 1) / 4, the exponent computing square roots since p = 3 mod 4
+	secpSqrtExp = new(big.Int).Rsh(new(big.Int).Add(secpP, big.NewInt(1)), 2)
+	// sqrt(-3), the exact root used by the BIP324 reference decoder
+	secpMinus3Sqrt = new(big.Int).Exp(new(big.Int).Sub(secpP, big.NewInt(3)), secpSqrtExp, secpP)
+)
+
+var (
+	// ErrInvalidPrivateKey means the given private key is zero or not less than the group order
+	ErrInvalidPrivateKey = errors.New("invalid secp256k1 private key")
+	// ErrPointAtInfinity means an operation resulted in the point at infinity
+	ErrPointAtInfinity = errors.New("secp256k1 point at infinity")
+)
+
+// fe is a shorthand of field operations modulo p, all results are reduced into [0, p)
+func feMod(a *big.Int) *big.Int     { return a.Mod(a, secpP) }
+func feAdd(a, b *big.Int) *big.Int  { return feMod(new(big.Int).Add(a, b)) }
+func feSub(a, b *big.Int) *big.Int  { return feMod(new(big.Int).Sub(a, b)) }
+func feMul(a, b *big.Int) *big.Int  { return feMod(new(big.Int).Mul(a, b)) }
+func feNeg(a *big.Int) *big.Int     { return feMod(new(big.Int).Neg(a)) }
+func feInv(a *big.Int) *big.Int     { return new(big.Int).ModInverse(a, secpP) }
+func feDiv(a, b *big.Int) *big.Int  { return feMul(a, feInv(b)) }
+func feSquare(a *big.Int) *big.Int  { return feMul(a, a) }
+func feCube(a *big.Int) *big.Int    { return feMul(feSquare(a), a) }
+func feSmall(i int64) *big.Int      { return feMod(big.NewInt(i)) }
+func feIsZero(a *big.Int) bool      { return a.Sign() == 0 }
+func feEqual(a, b *big.Int) bool    { return a.Cmp(b) == 0 }
+func feFromBytes(b []byte) *big.Int { return feMod(new(big.Int).SetBytes(b)) }
+
+// feSqrt returns a square root of a, or nil when a is not a quadratic residue
+func feSqrt(a *big.Int) *big.Int {
+	r := new(big.Int).Exp(a, secpSqrtExp, secpP)
+	if !feEqual(feSquare(r), feMod(new(big.Int).Set(a))) {
+		return nil
+	}
+	return r
+}
+
+// feBytes serializes a field element as 32 bytes big endian
+func feBytes(a *big.Int) [32]byte {
+	var b [32]byte
+	a.FillBytes(b[:])
+	return b
+}
+
+// validX checks whether x is the X coordinate of a point on the curve
+func validX(x *big.Int) bool {
+	return feSqrt(feAdd(feCube(x), secpB)) != nil
+}
+
+// jacobianPoint is a curve point in jacobian coordinates (X/Z^2, Y/Z^3), Z = 0 means infinity
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+func (p *jacobianPoint) infinity() bool {
+	return feIsZero(p.z)
+}
+
+func (p *jacobianPoint) double() *jacobianPoint {
+	if p.infinity() || feIsZero(p.y) {
+		return &jacobianPoint{big.NewInt(0), big.NewInt(1), big.NewInt(0)}
+	}
+	// dbl-2009-l for a = 0
+	a := feSquare(p.x)
+	b := feSquare(p.y)
+	c := feSquare(b)
+	d := feMul(feSmall(2), feSub(feSub(feSquare(feAdd(p.x, b)), a), c))
+	e := feMul(feSmall(3), a)
+	f := feSquare(e)
+	x := feSub(f, feMul(feSmall(2), d))
+	y := feSub(feMul(e, feSub(d, x)), feMul(feSmall(8), c))
+	z := feMul(feSmall(2), feMul(p.y, p.z))
+	return &jacobianPoint{x, y, z}
+}
+
+func (p *jacobianPoint) add(q *jacobianPoint) *jacobianPoint {
+	if p.infinity() {
+		return q
+	}
+	if q.infinity() {
+		return p
+	}
+	// add-2007-bl
+	z1z1 := feSquare(p.z)
+	z2z2 := feSquare(q.z)
+	u1 := feMul(p.x, z2z2)
+	u2 := feMul(q.x, z1z1)
+	s1 := feMul(p.y, feMul(q.z, z2z2))
+	s2 := feMul(q.y, feMul(p.z, z1z1))
+	if feEqual(u1, u2) {
+		if feEqual(s1, s2) {
+			return p.double()
+		}
+		return &jacobianPoint{big.NewInt(0), big.NewInt(1), big.NewInt(0)}
+	}
+	h := feSub(u2, u1)
+	i := feSquare(feMul(feSmall(2), h))
+	j := feMul(h, i)
+	r := feMul(feSmall(2), feSub(s2, s1))
+	v := feMul(u1, i)
+	x := feSub(feSub(feSquare(r), j), feMul(feSmall(2), v))
+	y := feSub(feMul(r, feSub(v, x)), feMul(feSmall(2), feMul(s1, j)))
+	z := feMul(feSub(feSub(feSquare(feAdd(p.z, q.z)), z1z1), z2z2), h)
+	return &jacobianPoint{x, y, z}
+}
+
+// affineX returns the affine X coordinate of the point
+func (p *jacobianPoint) affineX() *big.Int {
+	return feDiv(p.x, feSquare(p.z))
+}
+
+// scalarMult computes k * (x, y) using double-and-add
+func scalarMult(k, x, y *big.Int) *jacobianPoint {
+	result := &jacobianPoint{big.NewInt(0), big.NewInt(1), big.NewInt(0)}
+	base := &jacobianPoint{x, y, big.NewInt(1)}
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		result = result.double()
+		if k.Bit(i) == 1 {
+			result = result.add(base)
+		}
+	}
+	return result
+}
+
+// liftX returns the point with the given X coordinate and an even Y, or nil when there is no such point
+func liftX(x *big.Int) (*big.Int, *big.Int) {
+	y := feSqrt(feAdd(feCube(x), secpB))
+	if y == nil {
+		return nil, nil
+	}
+	if y.Bit(0) == 1 {
+		y = feNeg(y)
+	}
+	return x, y
+}
+
+// xswiftec decodes the field elements (u, t) into an X coordinate on the curve, as specified in BIP324
+func xswiftec(u, t *big.Int) *big.Int {
+	if feIsZero(u) {
+		u = big.NewInt(1)
+	}
+	if feIsZero(t) {
+		t = big.NewInt(1)
+	}
+	if feIsZero(feAdd(feAdd(feCube(u), feSquare(t)), secpB)) {
+		t = feMul(feSmall(2), t)
+	}
+	x := feDiv(feSub(feAdd(feCube(u), secpB), feSquare(t)), feMul(feSmall(2), t))
+	y := feDiv(feAdd(x, t), feMul(secpMinus3Sqrt, u))
+	for _, candidate := range []*big.Int{
+		feAdd(u, feMul(feSmall(4), feSquare(y))),
+		feDiv(feSub(feNeg(feDiv(x, y)), u), feSmall(2)),
+		feDiv(feSub(feDiv(x, y), u), feSmall(2)),
+	} {
+		if validX(candidate) {
+			return candidate
+		}
+	}
+	// unreachable, one of the candidates is always on the curve
+	return nil
+}
+
+// xswiftecInv returns a t such that xswiftec(u, t) = x, or nil if the selected case has no solution.
+// The lowest two bits of c select which of the three decoder candidates (and which conic point) to target,
+// the third bit selects the sign of t.
+func xswiftecInv(x, u *big.Int, c int) *big.Int {
+	var v, s *big.Int
+	if c&2 == 0 {
+		// target the second or third candidate, the decoder prefers the second one so -x-u must be invalid
+		if validX(feSub(feNeg(x), u)) {
+			return nil
+		}
+		v = x
+		if c&1 == 1 {
+			v = feSub(feNeg(x), u)
+		}
+		s = feDiv(feNeg(feAdd(feCube(u), secpB)), feAdd(feAdd(feSquare(u), feMul(u, v)), feSquare(v)))
+	} else {
+		// target the first candidate u + 4Y^2
+		s = feSub(x, u)
+		if feIsZero(s) {
+			return nil
+		}
+		r := feSqrt(feNeg(feMul(s, feAdd(feMul(feSmall(4), feAdd(feCube(u), secpB)), feMul(feSmall(3), feMul(s, feSquare(u)))))))
+		if r == nil {
+			return nil
+		}
+		if c&1 == 1 {
+			if feIsZero(r) {
+				return nil
+			}
+			r = feNeg(r)
+		}
+		v = feDiv(feSub(feDiv(r, s), u), feSmall(2))
+	}
+	w := feSqrt(s)
+	if w == nil {
+		return nil
+	}
+	if c&4 == 4 {
+		w = feNeg(w)
+	}
+	return feMul(w, feSub(feDiv(feMul(u, feSub(secpMinus3Sqrt, big.NewInt(1))), feSmall(2)), v))
+}
+
+// EllSwiftPrivateKey is a secp256k1 private key used for the BIP324 key exchange
+type EllSwiftPrivateKey struct {
+	d       *big.Int
+	encoded [64]byte
+}
+
+// NewEllSwiftPrivateKey generates a random private key and the ElligatorSwift encoding of its public key
+func NewEllSwiftPrivateKey(rng io.Reader) (*EllSwiftPrivateKey, error) {
+	var buf [32]byte
+	for {
+		if _, err := io.ReadFull(rng, buf[:]); err != nil {
+			return nil, err
+		}
+		if key, err := EllSwiftPrivateKeyFromBytes(buf[:], rng); err == nil {
+			return key, nil
+		} else if err != ErrInvalidPrivateKey {
+			return nil, err
+		}
+	}
+}
+
+// EllSwiftPrivateKeyFromBytes creates a private key from its 32 bytes big endian representation,
+// rng provides the randomness used by the ElligatorSwift encoding
+func EllSwiftPrivateKeyFromBytes(b []byte, rng io.Reader) (*EllSwiftPrivateKey, error) {
+	d := new(big.Int).SetBytes(b)
+	if d.Sign() == 0 || d.Cmp(secpN) >= 0 {
+		return nil, ErrInvalidPrivateKey
+	}
+	pub := scalarMult(d, secpGx, secpGy)
+	encoded, err := ellswiftEncode(pub.affineX(), rng)
+	if err != nil {
+		return nil, err
+	}
+	return &EllSwiftPrivateKey{d: d, encoded: encoded}, nil
+}
+
+// EllSwift returns the 64 bytes ElligatorSwift encoding of the public key
+func (k *EllSwiftPrivateKey) EllSwift() [64]byte {
+	return k.encoded
+}
+
+// ECDH computes the BIP324 shared secret with the remote encoded public key.
+// initiator tells whether we are the side that initiated the connection.
+func (k *EllSwiftPrivateKey) ECDH(theirs [64]byte, initiator bool) ([32]byte, error) {
+	x, y := liftX(ellswiftDecode(theirs))
+	if x == nil {
+		return [32]byte{}, ErrPointAtInfinity
+	}
+	shared := scalarMult(k.d, x, y)
+	if shared.infinity() {
+		return [32]byte{}, ErrPointAtInfinity
+	}
+	sharedX := feBytes(shared.affineX())
+
+	var msg []byte
+	if initiator {
+		msg = append(append(msg, k.encoded[:]...), theirs[:]...)
+	} else {
+		msg = append(append(msg, theirs[:]...), k.encoded[:]...)
+	}
+	msg = append(msg, sharedX[:]...)
+	return taggedHash("bip324_ellswift_xonly_ecdh", msg), nil
+}
+
+// ellswiftEncode finds a random (u, t) encoding of the X coordinate
+func ellswiftEncode(x *big.Int, rng io.Reader) ([64]byte, error) {
+	var encoded [64]byte
+	var buf [33]byte
+	for {
+		if _, err := io.ReadFull(rng, buf[:]); err != nil {
+			return encoded, err
+		}
+		u := feFromBytes(buf[:32])
+		if feIsZero(u) {
+			continue
+		}
+		t := xswiftecInv(x, u, int(buf[32]&7))
+		if t == nil {
+			continue
+		}
+		// double check the encoding, so an edge case in the inverse never produces an undecodable key
+		if !feEqual(xswiftec(u, t), x) {
+			continue
+		}
+		ub, tb := feBytes(u), feBytes(t)
+		copy(encoded[:32], ub[:])
+		copy(encoded[32:], tb[:])
+		return encoded, nil
+	}
+}
+
+// ellswiftDecode decodes the 64 bytes ElligatorSwift encoding into an X coordinate
+func ellswiftDecode(encoded [64]byte) *big.Int {
+	return xswiftec(feFromBytes(encoded[:32]), feFromBytes(encoded[32:]))
+}
+
+// taggedHash computes sha256(sha256(tag) || sha256(tag) || msg) as defined in BIP340
+func taggedHash(tag string, msg []byte) [32]byte {
+	th := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(th[:])
+	h.Write(th[:])
+	h.Write(msg)
+	var out [32]byte
+	copy(out[:], h.Sum(nil))
+	return out
+}
+
+// defaultRand is the randomness source used by the transport handshake
+var defaultRand io.Reader = rand.Reader
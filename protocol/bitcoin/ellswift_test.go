@@ -0,0 +1,125 @@
+package bitcoin
+
+import (
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readVectors reads the rows of a csv file of the BIP324 test vectors in testdata, keyed by the columns of its
+// header. The files are copied unchanged from the bip-0324 directory of the bips repository at 9783d61f1b9c.
+func readVectors(t *testing.T, name string) []map[string]string {
+	f, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) < 2 {
+		t.Fatalf("%s has no vectors", name)
+	}
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]string, len(record))
+		for i, column := range records[0] {
+			row[column] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// unhex decodes a hex field of the test vectors
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEllSwiftDecode(t *testing.T) {
+	for _, row := range readVectors(t, "ellswift_decode_test_vectors.csv") {
+		var encoded [64]byte
+		copy(encoded[:], unhex(t, row["ellswift"]))
+		x := feBytes(ellswiftDecode(encoded))
+		assert.Equal(t, row["x"], hex.EncodeToString(x[:]), row["comment"])
+	}
+}
+
+func TestEllSwiftGenerator(t *testing.T) {
+	key, err := EllSwiftPrivateKeyFromBytes(big.NewInt(1).FillBytes(make([]byte, 32)), rand.Reader)
+	assert.Nil(t, err)
+	assert.Equal(t, secpGx, ellswiftDecode(key.EllSwift()))
+
+	_, err = EllSwiftPrivateKeyFromBytes(make([]byte, 32), rand.Reader)
+	assert.Equal(t, ErrInvalidPrivateKey, err)
+	_, err = EllSwiftPrivateKeyFromBytes(secpN.Bytes(), rand.Reader)
+	assert.Equal(t, ErrInvalidPrivateKey, err)
+}
+
+func TestEllSwiftInverse(t *testing.T) {
+	var buf [32]byte
+	for i := 0; i < 32; i++ {
+		_, _ = rand.Read(buf[:])
+		x, _ := liftX(feFromBytes(buf[:]))
+		if x == nil {
+			continue
+		}
+		_, _ = rand.Read(buf[:])
+		u := feFromBytes(buf[:])
+		for c := 0; c < 8; c++ {
+			if tt := xswiftecInv(x, u, c); tt != nil {
+				assert.Equal(t, x, xswiftec(u, tt))
+			}
+		}
+	}
+}
+
+func TestEllSwiftInverseVectors(t *testing.T) {
+	for _, row := range readVectors(t, "xswiftec_inv_test_vectors.csv") {
+		u, x := feFromBytes(unhex(t, row["u"])), feFromBytes(unhex(t, row["x"]))
+		for c := 0; c < 8; c++ {
+			want := row[fmt.Sprintf("case%d_t", c)]
+			tt := xswiftecInv(x, u, c)
+			if want == "" {
+				assert.Nil(t, tt, "case %d of %s", c, row["comment"])
+				continue
+			}
+			if assert.NotNil(t, tt, "case %d of %s", c, row["comment"]) {
+				b := feBytes(tt)
+				assert.Equal(t, want, hex.EncodeToString(b[:]))
+				assert.Equal(t, x, xswiftec(u, tt))
+			}
+		}
+	}
+}
+
+func TestEllSwiftECDH(t *testing.T) {
+	a, err := NewEllSwiftPrivateKey(rand.Reader)
+	assert.Nil(t, err)
+	b, err := NewEllSwiftPrivateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	sa, err := a.ECDH(b.EllSwift(), true)
+	assert.Nil(t, err)
+	sb, err := b.ECDH(a.EllSwift(), false)
+	assert.Nil(t, err)
+	assert.Equal(t, sa, sb)
+
+	// the secret commits to the roles
+	sc, err := b.ECDH(a.EllSwift(), true)
+	assert.Nil(t, err)
+	assert.NotEqual(t, sa, sc)
+}
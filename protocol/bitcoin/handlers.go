@@ -2,7 +2,6 @@ package bitcoin
 
 import (
 	"fmt"
-	"net"
 	"time"
 
 	"github.com/AlaricGilbert/argos-core/argos"
@@ -25,7 +24,7 @@ type CommandHandler func(ctx *Ctx)
 
 var commandHandlers = map[string]CommandHandler{
 	CommandReject:      handleNop,
-	CommandVerack:      handleNop,
+	CommandVerack:      handleVerack,
 	CommandPong:        handleNop,
 	CommandSendHeaders: handleSendHeaders,
 	CommandVersion:     handleVersion,
@@ -35,6 +34,7 @@ var commandHandlers = map[string]CommandHandler{
 	CommandTx:          handleTx,
 	CommandPing:        handlePing,
 	CommandAddr:        handleAddr,
+	CommandGetAddr:     handleNop,
 	CommandFilterAdd:   handleFilterAdd,
 	CommandFilterClear: handleFilterClear,
 	CommandFilterLoad:  handleFilterLoad,
@@ -42,8 +42,21 @@ var commandHandlers = map[string]CommandHandler{
 	CommandHeaders:     handleHeaders,
 	CommandSendCmpct:   handleSendCmpct,
 	CommandFeeFilter:   handleFeeFilter,
+	// the features are only enabled when both sides offer them, and we never do
+	CommandWtxidRelay: handleNop,
+	CommandSendAddrV2: handleNop,
+	// we never send sendtxrcncl, so reconciliation is declined; the remaining Erlay messages
+	// are only answered in a way that makes the remote fall back to plain inv announcements
+	CommandSendTxRcncl:  handleSendTxRcncl,
+	CommandReqRecon:     handleReqRecon,
+	CommandReqSketchExt: handleReqSketchExt,
+	CommandSketch:       handleSketch,
+	CommandReconcilDiff: handleReconcilDiff,
 }
 
+// fetched are the transactions already requested from a remote
+var fetched = newRecentSet(MaxFetchedTransactions)
+
 func deserializePayload[T any](ctx *Ctx) *T {
 	var t T
 	if _, ctx.err = serialization.Deserialize(ctx.payload, &t); ctx.err != nil {
@@ -57,12 +70,39 @@ func deserializePayload[T any](ctx *Ctx) *T {
 
 func handleNop(ctx *Ctx) {}
 
+func handleVerack(ctx *Ctx) {
+	if n, ok := ctx.peer.s.(argos.HandshakeNotifier); ok {
+		n.NotifyHandshake(ctx.peer.addr.TCPAddr)
+	}
+	// the handshake completed, ask the remote not to announce transactions below our fee rate
+	if ctx.peer.settings.FeeFilter > 0 {
+		if ctx.err = ctx.peer.sendFeeFilter(ctx.peer.settings.FeeFilter); ctx.err != nil {
+			return
+		}
+	}
+	// ask outbound remotes for the nodes they know as reference implementations do, inbound ones may be
+	// crawlers fingerprinting us so they are not asked
+	if !ctx.peer.inbound {
+		ctx.err = ctx.peer.sendGetAddr()
+	}
+}
+
 func handleSendHeaders(ctx *Ctx) {
 	ctx.peer.sendheaders = true
 }
 
 func handleVersion(ctx *Ctx) {
-	if _ = deserializePayload[Version](ctx); ctx.err == nil {
+	if version := deserializePayload[Version](ctx); ctx.err == nil {
+		// inbound remotes are not chosen by us, so only heights announced by outbound ones are trusted
+		if !ctx.peer.inbound {
+			updateTipHeight(version.StartHeight)
+		}
+		// inbound remotes expect our version before the verack
+		if ctx.peer.inbound {
+			if ctx.err = ctx.peer.sendVersion(); ctx.err != nil {
+				return
+			}
+		}
 		ctx.err = ctx.peer.sendVerack()
 	}
 }
@@ -70,6 +110,7 @@ func handleVersion(ctx *Ctx) {
 func handleInv(ctx *Ctx) {
 	if inv := deserializePayload[Inv](ctx); ctx.err == nil {
 		revTime := time.Now()
+		var fetches []Inventory
 		for _, ii := range inv.Inventory {
 			// we only support transactions here
 			if ii.Type.Tx() {
@@ -77,9 +118,18 @@ func handleInv(ctx *Ctx) {
 					Source:    ctx.peer.addr.TCPAddr,
 					Timestamp: revTime,
 					TxID:      ii.Hash,
+					// peers offering reconciliation may announce fewer transactions by inv
+					OfferedTxRcncl: ctx.peer.OfferedTxRcncl(),
+					Direction:      ctx.peer.Direction(),
 				})
+				if ctx.peer.settings.FetchTransactions && fetched.add(ii.Hash) {
+					fetches = append(fetches, Inventory{Type: MSG_WITNESS_TX, Hash: ii.Hash})
+				}
 			}
 		}
+		if len(fetches) > 0 {
+			ctx.err = ctx.peer.sendGetData(fetches...)
+		}
 	}
 }
 
@@ -94,9 +144,8 @@ func handleNotFound(ctx *Ctx) {
 }
 
 func handleTx(ctx *Ctx) {
-	if tx := deserializePayload[Transaction](ctx); ctx.err == nil {
-		ctx.peer.txs[ctx.payloadhash] = *tx
-	}
+	// bodies are only fetched to behave like a relaying node, so they are dropped once parsed
+	_ = deserializePayload[Transaction](ctx)
 }
 
 func handlePing(ctx *Ctx) {
@@ -107,30 +156,43 @@ func handlePing(ctx *Ctx) {
 
 func handleAddr(ctx *Ctx) {
 	if addr := deserializePayload[Addr](ctx); ctx.err == nil {
-		var addrlist []net.TCPAddr
+		var addrlist []argos.NodeAddress
 		for _, address := range addr.AddrList {
-			addrlist = append(addrlist, *address.TCPAddr())
+			addrlist = append(addrlist, argos.NodeAddress{
+				Address:  *address.TCPAddr(),
+				Services: uint64(address.Services),
+			})
 		}
 		ctx.peer.s.NodeConn(ctx.peer.addr.TCPAddr, addrlist)
 	}
 }
 
 func handleFilterAdd(ctx *Ctx) {
+	var rejectData [32]byte
 	if add := deserializePayload[FilterAdd](ctx); ctx.err == nil {
-		if ctx.peer.filterLoad != nil {
-			ctx.peer.filterLoad.Filter = append(ctx.peer.filterLoad.Filter, add.Data...)
+		if ctx.peer.filter == nil {
+			ctx.err = ctx.peer.sendReject(ctx.command, REJECT_INVALID, "no filter loaded", rejectData)
+			return
+		}
+		if err := ctx.peer.filter.Add(add); err != nil {
+			ctx.err = ctx.peer.sendReject(ctx.command, REJECT_INVALID, err.Error(), rejectData)
 		}
 	}
 }
 
 func handleFilterLoad(ctx *Ctx) {
+	var rejectData [32]byte
 	if load := deserializePayload[FilterLoad](ctx); ctx.err == nil {
-		ctx.peer.filterLoad = load
+		if filter, err := NewBloomFilterFromLoad(load); err != nil {
+			ctx.err = ctx.peer.sendReject(ctx.command, REJECT_INVALID, err.Error(), rejectData)
+		} else {
+			ctx.peer.filter = filter
+		}
 	}
 }
 
 func handleFilterClear(ctx *Ctx) {
-	ctx.peer.filterLoad = nil
+	ctx.peer.filter = nil
 }
 
 func handleFeeFilter(ctx *Ctx) {
@@ -168,3 +230,30 @@ func handleSendCmpct(ctx *Ctx) {
 		ctx.peer.announce = cmpct.Announce
 	}
 }
+
+func handleSendTxRcncl(ctx *Ctx) {
+	if txrcncl := deserializePayload[SendTxRcncl](ctx); ctx.err == nil {
+		ctx.peer.txrcncl = txrcncl
+		ctx.peer.logger().WithField("txrcncl", txrcncl).Info("bitcoin peer offered transaction reconciliation")
+	}
+}
+
+func handleReqRecon(ctx *Ctx) {
+	if _ = deserializePayload[ReqRecon](ctx); ctx.err == nil {
+		ctx.err = ctx.peer.sendSketch(nil)
+	}
+}
+
+func handleReqSketchExt(ctx *Ctx) {
+	ctx.err = ctx.peer.sendSketch(nil)
+}
+
+func handleSketch(ctx *Ctx) {
+	if _ = deserializePayload[Sketch](ctx); ctx.err == nil {
+		ctx.err = ctx.peer.sendReconcilDiff(false)
+	}
+}
+
+func handleReconcilDiff(ctx *Ctx) {
+	_ = deserializePayload[ReconcilDiff](ctx)
+}
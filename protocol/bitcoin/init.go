@@ -1,7 +1,10 @@
 package bitcoin
 
 import (
+	"errors"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 
 	"github.com/AlaricGilbert/argos-core/argos"
 	"github.com/AlaricGilbert/argos-core/argos/serialization"
@@ -9,6 +12,136 @@ import (
 
 var once sync.Once
 
+// ErrUnknownNetwork means the network in the options has no known magic
+var ErrUnknownNetwork = errors.New("unknown bitcoin network")
+
+// Options controls the behaviour of the bitcoin peers
+type Options struct {
+	// V2Transport makes peers try the BIP324 encrypted transport first and fall back to v1 when the remote refuses it
+	V2Transport bool `json:"v2_transport"`
+	// ProtocolVersion is the protocol version sent in our version messages
+	ProtocolVersion int32 `json:"protocol_version"`
+	// Services is the services bitfield sent in our version messages
+	Services ServiceType `json:"services"`
+	// AdvertisedAddress is our reachable address in "ip:port" form, a zero address is sent when it is empty
+	AdvertisedAddress string `json:"advertised_address"`
+	// UserAgent is the user agent sent in our version messages
+	UserAgent string `json:"user_agent"`
+	// StartHeight is the lowest start height sent in our version messages, the highest height announced by
+	// outbound remotes is used instead once it is higher
+	StartHeight int32 `json:"start_height"`
+	// Relay asks the remotes to announce transactions, see BIP37
+	Relay bool `json:"relay"`
+	// Seeds are the static seed nodes in "ip:port" form
+	Seeds []string `json:"seeds"`
+	// SeedFile is a file listing seed nodes, one "ip:port" per line
+	SeedFile string `json:"seed_file"`
+	// DNSSeeds enables querying the DNS seeds
+	DNSSeeds bool `json:"dns_seeds"`
+	// FixedSeeds enables the fixed seed nodes embedded into the binary
+	FixedSeeds bool `json:"fixed_seeds"`
+	// SeedServices are the services the nodes provided by DNS seeds should serve, 0 disables the filtering
+	SeedServices ServiceType `json:"seed_services"`
+	// Network is the network joined by the peers, e.g. main, testnet3 or signet. The DNS and fixed seeds
+	// only serve the main network
+	Network string `json:"network"`
+	// FeeFilter asks the remotes not to announce transactions paying less than the fee rate in satoshis
+	// per kilobyte, 0 disables it, see BIP133
+	FeeFilter int64 `json:"fee_filter"`
+	// FetchTransactions makes peers request the bodies of the announced transactions
+	FetchTransactions bool `json:"fetch_transactions"`
+}
+
+// DefaultOptions returns the options used when SetOptions is never called
+func DefaultOptions() Options {
+	return Options{
+		V2Transport:     true,
+		ProtocolVersion: ProtocolVersion,
+		Services:        NODE_WITNESS,
+		UserAgent:       UserAgent,
+		Relay:           true,
+		DNSSeeds:        true,
+		FixedSeeds:      true,
+		SeedServices:    NODE_NETWORK | NODE_WITNESS,
+		Network:         "main",
+	}
+}
+
+// settings are the options in use with the values derived from them, they are never modified once in use so
+// that peers can keep the settings they were created with
+type settings struct {
+	Options
+	// advertised is the parsed AdvertisedAddress
+	advertised netip.AddrPort
+	// magic is the magic of Network
+	magic NetworkMagic
+}
+
+var (
+	current   = &settings{Options: DefaultOptions(), magic: MagicMain}
+	currentMu sync.RWMutex
+)
+
+// tipHeight is the highest start height announced by outbound remotes
+var tipHeight int32
+
+// loadSettings returns the settings in use
+func loadSettings() *settings {
+	currentMu.RLock()
+	defer currentMu.RUnlock()
+	return current
+}
+
+// GetOptions returns the options in use
+func GetOptions() Options {
+	return loadSettings().Options
+}
+
+// SetOptions replaces the options used by peers created afterwards, the running peers keep the options they
+// were created with. The options should be derived from DefaultOptions so that the unset fields keep their
+// defaults.
+func SetOptions(o Options) error {
+	var addr netip.AddrPort
+	var err error
+	if o.AdvertisedAddress != "" {
+		if addr, err = netip.ParseAddrPort(o.AdvertisedAddress); err != nil {
+			return err
+		}
+	}
+	if o.Network == "" {
+		o.Network = "main"
+	}
+	m := MagicFromName(o.Network)
+	if m == 0 {
+		return ErrUnknownNetwork
+	}
+	// the slices are copied so that the caller modifying them later does not affect the settings in use
+	o.Seeds = append([]string(nil), o.Seeds...)
+
+	currentMu.Lock()
+	defer currentMu.Unlock()
+	current = &settings{Options: o, advertised: addr, magic: m}
+	return nil
+}
+
+// updateTipHeight raises the tracked tip height to the given height
+func updateTipHeight(height int32) {
+	for {
+		old := atomic.LoadInt32(&tipHeight)
+		if height <= old || atomic.CompareAndSwapInt32(&tipHeight, old, height) {
+			return
+		}
+	}
+}
+
+// startHeight returns the start height sent in our version messages
+func (s *settings) startHeight() int32 {
+	if height := atomic.LoadInt32(&tipHeight); height > s.StartHeight {
+		return height
+	}
+	return s.StartHeight
+}
+
 func initOnce() {
 	_ = serialization.RegisterSerializer("bitcoin", &BitcoinSerializer{})
 }
@@ -16,7 +149,8 @@ func initOnce() {
 func Init() error {
 	once.Do(initOnce)
 	argos.RegisterPeerConstructor("bitcoin", NewPeer)
-	argos.RegisterSeedProvider("bitcoin", LookupBTCNetwork)
-	argos.RegisterRandomRemoteAddressProvider("bitcoin", LookupRandomBTCHostAddress)
+	argos.RegisterInboundPeerConstructor("bitcoin", NewInboundPeer)
+	argos.RegisterSeedProvider("bitcoin", Seeds)
+	argos.RegisterRandomRemoteAddressProvider("bitcoin", RandomSeedAddress)
 	return nil
 }
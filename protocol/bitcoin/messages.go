@@ -6,6 +6,9 @@ import (
 	"net"
 	"net/netip"
 	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos/serialization"
+	"github.com/cloudwego/netpoll"
 )
 
 // MessageHeader is the header of all messages, contains a magic number which used for identify the network and locate the message start in network stream
@@ -255,6 +258,20 @@ func (tx Transaction) String() string {
 	)
 }
 
+// TxID computes the transaction id, which is the hash of the transaction serialized without witness data
+func (tx *Transaction) TxID() [32]byte {
+	var stripped = *tx
+	stripped.Flag = [2]uint8{0, 0}
+
+	buf := netpoll.NewLinkBuffer()
+	defer buf.Close()
+	// serializing into link buffer never fails
+	_, _ = serialization.Serialize(buf, &stripped)
+	_ = buf.Flush()
+	data, _ := buf.ReadBinary(buf.Len())
+	return hash(data)
+}
+
 // Block message is sent in response to a getdata message which requests transaction information from a block hash.
 type Block struct {
 	Version    int32         // Block version information (note, this is signed)
@@ -459,3 +476,55 @@ type HeaderAndShortIDs struct {
 	ShortIDCount VarInt
 	ShortIDs     [][6]byte `size:"ShortIDCount"`
 }
+
+// SendTxRcncl is sent between version and verack to signal support of transaction reconciliation (BIP330).
+// Reconciliation is only enabled when both sides sent it, so a peer that never answers declines to reconcile.
+type SendTxRcncl struct {
+	Version uint32 // the highest reconciliation protocol version supported by the sender
+	Salt    uint64 // the salt used by the sender to compute short transaction ids
+}
+
+// String implements fmt.Stringer
+func (s SendTxRcncl) String() string {
+	return fmt.Sprintf("{Version: %d, Salt: 0x%x}", s.Version, s.Salt)
+}
+
+// ReqRecon is sent by the reconciliation initiator to request a sketch of the responder's reconciliation set.
+type ReqRecon struct {
+	SetSize uint16 // size of the initiator's reconciliation set
+	Q       uint16 // coefficient used to estimate the set difference, scaled by 2^16
+}
+
+// String implements fmt.Stringer
+func (r ReqRecon) String() string {
+	return fmt.Sprintf("{SetSize: %d, Q: %d}", r.SetSize, r.Q)
+}
+
+// Sketch is sent in response to reqrecon or reqsketchext. An empty sketch tells the initiator that the responder
+// is unable to reconcile, so it should fall back to announcing the whole set by inv.
+type Sketch struct {
+	Count      VarInt
+	SketchData []byte `size:"Count"` // minisketch serialization of the responder's reconciliation set
+}
+
+// String implements fmt.Stringer
+func (s Sketch) String() string {
+	return fmt.Sprintf("{SketchData: %s}", hex.EncodeToString(s.SketchData))
+}
+
+// ReconcilDiff is sent by the initiator once it finished decoding a sketch.
+type ReconcilDiff struct {
+	Success     uint8    // whether the sketch was decoded successfully
+	AskCount    VarInt   // number of short ids to follow
+	AskShortIDs []uint32 `size:"AskCount"` // short ids of transactions the initiator is missing
+}
+
+// String implements fmt.Stringer
+func (r ReconcilDiff) String() string {
+	return fmt.Sprintf("{Success: %d, AskShortIDs: %s}",
+		r.Success,
+		FmtSlice(r.AskShortIDs, func(t uint32) string {
+			return fmt.Sprintf("0x%x", t)
+		}),
+	)
+}
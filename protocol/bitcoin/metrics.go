@@ -0,0 +1,29 @@
+package bitcoin
+
+import (
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
+	"github.com/rcrowley/go-metrics"
+)
+
+// unsupportedCommand is the command label of the received messages without handlers, so the commands made
+// up by remotes do not create metrics
+const unsupportedCommand = "unsupported"
+
+// messageCounters count the messages received by all peers by command
+var messageCounters = make(map[string]metrics.Counter, len(commandHandlers)+1)
+
+func init() {
+	for command := range commandHandlers {
+		messageCounters[command] = metrics.GetOrRegisterCounter(exporter.Name("bitcoin.messages", "command", command), nil)
+	}
+	messageCounters[unsupportedCommand] = metrics.GetOrRegisterCounter(exporter.Name("bitcoin.messages", "command", unsupportedCommand), nil)
+}
+
+// countMessage counts a received message of the command
+func countMessage(command string) {
+	if counter, ok := messageCounters[command]; ok {
+		counter.Inc(1)
+	} else {
+		messageCounters[unsupportedCommand].Inc(1)
+	}
+}
@@ -2,9 +2,11 @@ package bitcoin
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/AlaricGilbert/argos-core/argos"
@@ -14,19 +16,29 @@ import (
 )
 
 type Peer struct {
-	s           argos.Sniffer
-	addr        *netpoll.TCPAddr
-	localAddr   *netpoll.TCPAddr
-	conn        *netpoll.TCPConnection
-	txs         map[[32]byte]Transaction
+	s         argos.Sniffer
+	addr      *netpoll.TCPAddr
+	localAddr *netpoll.TCPAddr
+	conn      netpoll.Connection
+	// ctx is cancelled by Halt so that a dial in progress gives up, conn is set under mu so that Halt sees it
+	ctx         context.Context
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+	inbound     bool
 	announce    bool
 	sendheaders bool
-	filterLoad  *FilterLoad
-	feeFilter   int64
-	mock        bool
-	mockReader  netpoll.Reader
-	mockWriter  netpoll.Writer
-	nonce       uint64
+	// filter is the BIP37 filter loaded by the remote, kept so that filteradd is answered as specified. The
+	// sniffer never relays transactions, getdata is answered by notfound, so nothing is matched against it.
+	filter     *BloomFilter
+	feeFilter  int64
+	txrcncl    *SendTxRcncl
+	settings   *settings
+	preferV2   bool
+	v2         *v2Transport
+	mock       bool
+	mockReader netpoll.Reader
+	mockWriter netpoll.Writer
+	nonce      uint64
 }
 
 func (d *Peer) logger() *logrus.Entry {
@@ -56,12 +68,30 @@ func (d *Peer) send(command string, data any) error {
 
 	msg, _ := buf.ReadBinary(msgLen)
 
+	// v2 transport has its own packet framing and authentication
+	if d.v2 != nil {
+		d.logger().WithFields(logrus.Fields{
+			"command": command,
+			"message": data,
+		}).Info("bitcoin peer sending v2 message")
+
+		w := d.writer()
+		if _, err = w.WriteBinary(d.v2.encrypt(v2EncodeMessage(command, msg), false)); err != nil {
+			return err
+		}
+		if err = w.Flush(); err != nil {
+			return err
+		}
+		_ = buf.Close()
+		return nil
+	}
+
 	var cmd [12]byte
 	copy(cmd[:], []byte(command))
 
 	_, sum := checksum(msg)
 	header := &MessageHeader{
-		Magic:    MagicMain,
+		Magic:    d.settings.magic,
 		Command:  cmd,
 		Length:   uint32(msgLen),
 		Checksum: sum,
@@ -102,18 +132,26 @@ func (d *Peer) sendReject(msg string, code byte, reason string, data [32]byte) e
 }
 
 func (d *Peer) sendVersion() error {
-	addr := d.addr
-	addr.IP = addr.IP.To16()
+	received := d.addr.TCPAddr
+	received.IP = received.IP.To16()
+
+	// the reference implementation sends a zero address when it does not know its own address
+	from := net.TCPAddr{IP: net.IPv6zero}
+	if d.settings.advertised.IsValid() {
+		from = *net.TCPAddrFromAddrPort(d.settings.advertised)
+		from.IP = from.IP.To16()
+	}
+
 	return d.send(CommandVersion, &Version{
-		Version:      70015,
-		Services:     0,
+		Version:      d.settings.ProtocolVersion,
+		Services:     d.settings.Services,
 		Timestamp:    time.Now().Unix(),
-		AddrReceived: *newNetworkAddress(0, &addr.TCPAddr),
-		AddrFrom:     *newNetworkAddress(0, &addr.TCPAddr),
+		AddrReceived: *newNetworkAddress(0, &received),
+		AddrFrom:     *newNetworkAddress(d.settings.Services, &from),
 		Nonce:        d.nonce,
-		UserAgent:    UserAgent,
-		StartHeight:  0,
-		Relay:        true,
+		UserAgent:    VarString(d.settings.UserAgent),
+		StartHeight:  d.settings.startHeight(),
+		Relay:        d.settings.Relay,
 	})
 }
 
@@ -121,6 +159,10 @@ func (d *Peer) sendVerack() error {
 	return d.send(CommandVerack, nil)
 }
 
+func (d *Peer) sendGetAddr() error {
+	return d.send(CommandGetAddr, nil)
+}
+
 func (d *Peer) sendInv(invs ...Inventory) error {
 	return d.send(CommandInv, &Inv{
 		Count:     VarInt(len(invs)),
@@ -135,6 +177,10 @@ func (d *Peer) sendGetData(invs ...Inventory) error {
 	})
 }
 
+func (d *Peer) sendFeeFilter(feeRate int64) error {
+	return d.send(CommandFeeFilter, FeeFilter(feeRate))
+}
+
 func (d *Peer) sendNotFound(invs ...Inventory) error {
 	return d.send(CommandNotFound, &Inv{
 		Count:     VarInt(len(invs)),
@@ -148,6 +194,21 @@ func (d *Peer) sendPong(nonce uint64) error {
 	})
 }
 
+func (d *Peer) sendSketch(data []byte) error {
+	return d.send(CommandSketch, &Sketch{
+		Count:      VarInt(len(data)),
+		SketchData: data,
+	})
+}
+
+func (d *Peer) sendReconcilDiff(success bool) error {
+	var diff = &ReconcilDiff{}
+	if success {
+		diff.Success = 1
+	}
+	return d.send(CommandReconcilDiff, diff)
+}
+
 func (d *Peer) reader() netpoll.Reader {
 	if d.mock && d.mockReader != nil {
 		return d.mockReader
@@ -261,6 +322,107 @@ func (d *Peer) header(ctx *Ctx) {
 	ctx.err = argos.ErrPeerNotRunning
 }
 
+// readV2Message reads the next non-decoy v2 packet and fills the message header
+func (d *Peer) readV2Message(ctx *Ctx) []byte {
+	var ignore bool
+	var contents, payload []byte
+	var command string
+	for {
+		if ignore, contents, ctx.err = d.v2.readPacket(d.reader()); ctx.err != nil {
+			return nil
+		}
+		if !ignore {
+			break
+		}
+	}
+
+	if command, payload, ctx.err = v2DecodeMessage(contents); ctx.err != nil {
+		return nil
+	}
+
+	ctx.header.Magic = d.settings.magic
+	copy(ctx.header.Command[:], command)
+	ctx.header.Length = uint32(len(payload))
+	return payload
+}
+
+// handshakeV2 tries to establish BIP324 v2 transport on the current connection
+func (d *Peer) handshakeV2() error {
+	var err error
+	if !d.mock {
+		_ = d.conn.SetReadTimeout(V2HandshakeTimeout)
+		defer func() {
+			_ = d.conn.SetReadTimeout(0)
+		}()
+	}
+
+	if d.v2, err = v2Handshake(d.reader(), d.writer(), d.settings.magic, !d.inbound); err != nil {
+		d.v2 = nil
+		return err
+	}
+	d.logger().Info("bitcoin peer v2 transport established")
+	return nil
+}
+
+func (d *Peer) dial() error {
+	ctx, cancel := context.WithTimeout(d.ctx, DialTimeout)
+	defer cancel()
+	conn, err := netpoll.DialTCP(ctx, "tcp", nil, d.addr)
+	if err != nil {
+		d.logger().WithError(err).Error("peer connect failed")
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// the peer halted while the connection was being established
+	if d.ctx.Err() != nil {
+		conn.Close()
+		return argos.ErrPeerNotRunning
+	}
+	d.conn = conn
+	d.localAddr = &netpoll.TCPAddr{
+		TCPAddr: *d.conn.LocalAddr().(*net.TCPAddr),
+	}
+	return nil
+}
+
+// connect dials the remote and establishes the transport of an outbound connection
+func (d *Peer) connect() error {
+	var err error
+	if err = d.dial(); err != nil {
+		return err
+	}
+
+	// v1 only nodes drop the connection when receiving our v2 public key, reconnect with v1 transport then
+	if d.preferV2 {
+		if err = d.handshakeV2(); err != nil {
+			d.logger().WithError(err).Info("bitcoin peer v2 handshake failed, falling back to v1 transport")
+			d.conn.Close()
+			if err = d.dial(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// accept establishes the transport of an inbound connection, the remote is expected to use v2 transport
+// unless its first bytes are the beginning of a v1 version message
+func (d *Peer) accept() error {
+	if !d.preferV2 {
+		return nil
+	}
+	if err := d.handshakeV2(); err != nil {
+		if errors.Is(err, ErrV2V1Detected) {
+			d.logger().Info("bitcoin peer inbound connection uses v1 transport")
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
 func (d *Peer) handle() error {
 	var rejectData [32]byte
 	var data []byte
@@ -278,30 +440,41 @@ func (d *Peer) handle() error {
 		}
 	}()
 
-	if d.header(ctx); ctx.err != nil {
-		return ctx.err
-	}
+	if d.v2 != nil {
+		// packets are authenticated by the v2 transport, so we only fill the header for handlers
+		if data = d.readV2Message(ctx); ctx.err != nil {
+			return ctx.err
+		}
+		ctx.command = SliceToString(ctx.header.Command[:])
+		ctx.payloadhash, ctx.checksum = checksum(data)
+		ctx.header.Checksum = ctx.checksum
+	} else {
+		if d.header(ctx); ctx.err != nil {
+			return ctx.err
+		}
 
-	ctx.command = SliceToString(ctx.header.Command[:])
-	// received unexpected message that more than buffer limit
-	// consider it's a message that with wrong transmission status
-	// send a reject message
-	if ctx.header.Length > BitcoinMessageMaxLength {
-		ctx.err = d.sendReject(ctx.command, REJECT_INVALID, "message too long", rejectData)
-		return ctx.err
-	}
+		ctx.command = SliceToString(ctx.header.Command[:])
+		// received unexpected message that more than buffer limit
+		// consider it's a message that with wrong transmission status
+		// send a reject message
+		if ctx.header.Length > BitcoinMessageMaxLength {
+			ctx.err = d.sendReject(ctx.command, REJECT_INVALID, "message too long", rejectData)
+			return ctx.err
+		}
 
-	if data, ctx.err = d.reader().ReadBinary(int(ctx.header.Length)); ctx.err != nil {
-		return ctx.err
-	} else if ctx.payloadhash, ctx.checksum = checksum(data); ctx.header.Checksum != ctx.checksum {
-		ctx.err = d.sendReject(ctx.command, REJECT_INVALID, "message checksum invalid", rejectData)
-		return ctx.err
+		if data, ctx.err = d.reader().ReadBinary(int(ctx.header.Length)); ctx.err != nil {
+			return ctx.err
+		} else if ctx.payloadhash, ctx.checksum = checksum(data); ctx.header.Checksum != ctx.checksum {
+			ctx.err = d.sendReject(ctx.command, REJECT_INVALID, "message checksum invalid", rejectData)
+			return ctx.err
+		}
 	}
 
 	ctx.payload = netpoll.NewLinkBuffer()
 	_, _ = ctx.payload.WriteBinary(data)
 	ctx.payload.Flush()
 
+	countMessage(ctx.command)
 	if handler, ok := commandHandlers[ctx.command]; ok {
 		handler(ctx)
 	} else {
@@ -314,28 +487,34 @@ func (d *Peer) Spin() error {
 	var err error
 
 	defer func() {
+		d.mu.Lock()
+		d.cancel()
+		d.mu.Unlock()
 		if d.conn != nil {
 			d.conn.Close()
 		}
 		d.logger().Info("bitcoin peer spin exited")
 	}()
 
-	d.logger().Info("bitcoin peer spinning")
+	d.logger().WithField("direction", d.Direction()).Info("bitcoin peer spinning")
 	if !d.mock {
 		d.nonce = rand.Uint64()
 
-		if d.conn, err = netpoll.DialTCP(context.Background(), "tcp", nil, d.addr); err != nil {
-			d.logger().WithError(err).Error("peer connect failed")
-			return err
+		if d.inbound {
+			err = d.accept()
+		} else {
+			err = d.connect()
 		}
-
-		d.localAddr = &netpoll.TCPAddr{
-			TCPAddr: *d.conn.LocalAddr().(*net.TCPAddr),
+		if err != nil {
+			return err
 		}
 	}
 
-	if err = d.sendVersion(); err != nil {
-		return err
+	// the remote sends its version first on inbound connections, and we answer it in handleVersion
+	if !d.inbound {
+		if err = d.sendVersion(); err != nil {
+			return err
+		}
 	}
 
 	for d.mock || d.conn.IsActive() {
@@ -347,21 +526,70 @@ func (d *Peer) Spin() error {
 	return nil
 }
 
+// Halt stops the peer, a peer still connecting gives up connecting
 func (d *Peer) Halt() error {
 	d.logger().Info("bitcoin peer spin halting")
-	if d.conn == nil || !d.conn.IsActive() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ctx.Err() != nil {
+		return argos.ErrPeerNotRunning
+	}
+	d.cancel()
+	if d.conn == nil {
+		return nil
+	}
+	if !d.conn.IsActive() {
 		return argos.ErrPeerNotRunning
 	}
 	return d.conn.Close()
 }
 
+// Direction reports whether the connection was initiated by the sniffer or by the remote
+func (d *Peer) Direction() argos.Direction {
+	if d.inbound {
+		return argos.Inbound
+	}
+	return argos.Outbound
+}
+
+// OfferedTxRcncl reports whether the remote sent sendtxrcncl to offer BIP330 transaction reconciliation
+func (d *Peer) OfferedTxRcncl() bool {
+	return d.txrcncl != nil
+}
+
 func NewPeer(sniffer argos.Sniffer, addr *net.TCPAddr) argos.Peer {
+	s := loadSettings()
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Peer{
 		s: sniffer,
 		addr: &netpoll.TCPAddr{
 			TCPAddr: *addr,
 		},
-		txs: make(map[[32]byte]Transaction),
+		ctx:      ctx,
+		cancel:   cancel,
+		settings: s,
+		preferV2: s.V2Transport,
+	}
+}
+
+// NewInboundPeer creates a peer on a connection accepted by the sniffer
+func NewInboundPeer(sniffer argos.Sniffer, conn netpoll.Connection) argos.Peer {
+	s := loadSettings()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Peer{
+		s: sniffer,
+		addr: &netpoll.TCPAddr{
+			TCPAddr: *conn.RemoteAddr().(*net.TCPAddr),
+		},
+		localAddr: &netpoll.TCPAddr{
+			TCPAddr: *conn.LocalAddr().(*net.TCPAddr),
+		},
+		conn:     conn,
+		inbound:  true,
+		ctx:      ctx,
+		cancel:   cancel,
+		settings: s,
+		preferV2: s.V2Transport,
 	}
 }
 
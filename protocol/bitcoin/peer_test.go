@@ -0,0 +1,364 @@
+package bitcoin
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/AlaricGilbert/argos-core/argos/serialization"
+	"github.com/cloudwego/netpoll"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSniffer is a argos.Sniffer which only records what peers reported
+type testSniffer struct {
+	notifies   []argos.TransactionNotify
+	handshakes int
+}
+
+func (s *testSniffer) Logger() *logrus.Logger { return logrus.StandardLogger() }
+func (s *testSniffer) NotifyTransaction(n argos.TransactionNotify) {
+	s.notifies = append(s.notifies, n)
+}
+func (s *testSniffer) NotifyHandshake(address net.TCPAddr) {
+	s.handshakes++
+}
+func (s *testSniffer) Connect(address net.TCPAddr)                        {}
+func (s *testSniffer) Listen(address string) error                        { return nil }
+func (s *testSniffer) NodeConn(src net.TCPAddr, conn []argos.NodeAddress) {}
+func (s *testSniffer) NodeExit(address net.TCPAddr)                       {}
+func (s *testSniffer) Spin(node net.TCPAddr)                              {}
+func (s *testSniffer) Halt()                                              {}
+
+func TestPeerV2Transport(t *testing.T) {
+	initOnce()
+
+	a, b := v2Pipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	peer := NewPeer(&testSniffer{}, a.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	peer.Mock(netpoll.NewReader(a), netpoll.NewWriter(a))
+
+	responded := make(chan *v2Transport)
+	go func() {
+		rt, _ := v2Handshake(netpoll.NewReader(b), netpoll.NewWriter(b), MagicMain, false)
+		responded <- rt
+	}()
+
+	assert.Nil(t, peer.handshakeV2())
+	rt := <-responded
+	assert.NotNil(t, rt)
+
+	// the responder pings the peer through v2 transport, and the peer should pong
+	r, w := netpoll.NewReader(b), netpoll.NewWriter(b)
+	ping := netpoll.NewLinkBuffer()
+	_, _ = serialization.Serialize(ping, &Ping{Nonce: 0x1234})
+	_ = ping.Flush()
+	payload, _ := ping.ReadBinary(ping.Len())
+	_, _ = w.WriteBinary(rt.encrypt(nil, true))
+	_, _ = w.WriteBinary(rt.encrypt(v2EncodeMessage(CommandPing, payload), false))
+	assert.Nil(t, w.Flush())
+
+	assert.Nil(t, peer.handle())
+
+	_, contents, err := rt.readPacket(r)
+	assert.Nil(t, err)
+	command, payload, err := v2DecodeMessage(contents)
+	assert.Nil(t, err)
+	assert.Equal(t, CommandPong, command)
+
+	pong := netpoll.NewLinkBuffer()
+	_, _ = pong.WriteBinary(payload)
+	_ = pong.Flush()
+	var p Pong
+	_, err = serialization.Deserialize(pong, &p)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0x1234), p.Nonce)
+}
+
+func TestPeerInboundV1(t *testing.T) {
+	initOnce()
+
+	a, b := v2Pipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	peer := NewPeer(&testSniffer{}, a.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	peer.inbound = true
+	peer.preferV2 = true
+	peer.Mock(netpoll.NewReader(a), netpoll.NewWriter(a))
+	assert.Equal(t, argos.Inbound, peer.Direction())
+
+	// the remote connects with v1 transport and sends its version first
+	remote := NewPeer(&testSniffer{}, b.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	remote.Mock(netpoll.NewReader(b), netpoll.NewWriter(b))
+	assert.Nil(t, remote.sendVersion())
+
+	// v1 transport is detected without consuming the version message
+	assert.Nil(t, peer.accept())
+	assert.Nil(t, peer.v2)
+	assert.Nil(t, peer.handle())
+
+	// the peer answers with its own version followed by verack
+	var commands []string
+	for i := 0; i < 2; i++ {
+		ctx := &Ctx{peer: remote}
+		remote.header(ctx)
+		assert.Nil(t, ctx.err)
+		commands = append(commands, SliceToString(ctx.header.Command[:]))
+		_, err := remote.reader().ReadBinary(int(ctx.header.Length))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, []string{CommandVersion, CommandVerack}, commands)
+}
+
+func TestPeerSendVersion(t *testing.T) {
+	initOnce()
+	defer func() {
+		_ = SetOptions(DefaultOptions())
+	}()
+
+	o := DefaultOptions()
+	o.Services = NODE_WITNESS | NODE_NETWORK_LIMITED
+	o.AdvertisedAddress = "203.0.113.7:8333"
+	o.UserAgent = "/Satoshi:27.0.0/"
+	o.StartHeight = 800000
+	o.Relay = false
+	assert.Nil(t, SetOptions(o))
+	assert.NotNil(t, SetOptions(Options{AdvertisedAddress: "not an address"}))
+
+	// a higher height announced by an outbound remote replaces the configured one
+	updateTipHeight(800010)
+	defer atomic.StoreInt32(&tipHeight, 0)
+
+	buf := netpoll.NewLinkBuffer()
+	peer := NewPeer(&testSniffer{}, &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 8333}).(*Peer)
+	peer.Mock(buf, buf)
+	assert.Nil(t, peer.sendVersion())
+
+	var header MessageHeader
+	var ver Version
+	_, err := serialization.Deserialize(buf, &header)
+	assert.Nil(t, err)
+	_, err = serialization.Deserialize(buf, &ver)
+	assert.Nil(t, err)
+
+	assert.Equal(t, int32(ProtocolVersion), ver.Version)
+	assert.Equal(t, o.Services, ver.Services)
+	assert.Equal(t, "198.51.100.1:8333", ver.AddrReceived.TCPAddr().String())
+	assert.Equal(t, "203.0.113.7:8333", ver.AddrFrom.TCPAddr().String())
+	assert.Equal(t, o.Services, ver.AddrFrom.Services)
+	assert.Equal(t, VarString(o.UserAgent), ver.UserAgent)
+	assert.Equal(t, int32(800010), ver.StartHeight)
+	assert.False(t, ver.Relay)
+}
+
+func TestPeerTaskOptions(t *testing.T) {
+	initOnce()
+	defer func() {
+		_ = SetOptions(DefaultOptions())
+	}()
+
+	o := DefaultOptions()
+	o.Network = "signet"
+	o.FeeFilter = 1000
+	o.FetchTransactions = true
+	assert.Nil(t, SetOptions(o))
+	fetched = newRecentSet(MaxFetchedTransactions)
+	assert.Equal(t, ErrUnknownNetwork, SetOptions(Options{Network: "nonexistent"}))
+
+	a, b := v2Pipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	s := &testSniffer{}
+	peer := NewPeer(s, a.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	peer.Mock(netpoll.NewReader(a), netpoll.NewWriter(a))
+	remote := NewPeer(&testSniffer{}, b.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	remote.Mock(netpoll.NewReader(b), netpoll.NewWriter(b))
+
+	// the peer sends its fee filter and getaddr after the verack, and fetches the announced transactions
+	var txid = [32]byte{1, 2, 3}
+	assert.Nil(t, remote.sendVerack())
+	assert.Nil(t, remote.sendInv(Inventory{Type: MSG_TX, Hash: txid}))
+	assert.Nil(t, peer.handle())
+	assert.Nil(t, peer.handle())
+	assert.Equal(t, 1, s.handshakes)
+	assert.Equal(t, 1, len(s.notifies))
+
+	ctx := &Ctx{peer: remote}
+	remote.header(ctx)
+	assert.Nil(t, ctx.err)
+	assert.Equal(t, MagicSignet, ctx.header.Magic)
+	assert.Equal(t, CommandFeeFilter, SliceToString(ctx.header.Command[:]))
+	var filter FeeFilter
+	_, err := serialization.Deserialize(remote.reader(), &filter)
+	assert.Nil(t, err)
+	assert.Equal(t, FeeFilter(1000), filter)
+
+	// then asks the outbound remote for addresses
+	ctx = &Ctx{peer: remote}
+	remote.header(ctx)
+	assert.Nil(t, ctx.err)
+	assert.Equal(t, CommandGetAddr, SliceToString(ctx.header.Command[:]))
+
+	ctx = &Ctx{peer: remote}
+	remote.header(ctx)
+	assert.Nil(t, ctx.err)
+	assert.Equal(t, CommandGetData, SliceToString(ctx.header.Command[:]))
+	var getdata GetData
+	_, err = serialization.Deserialize(remote.reader(), &getdata)
+	assert.Nil(t, err)
+	assert.Equal(t, []Inventory{{Type: MSG_WITNESS_TX, Hash: txid}}, getdata.Inventory)
+
+	// a transaction announced again is not fetched twice, so the pong is the next message
+	assert.Nil(t, remote.sendInv(Inventory{Type: MSG_TX, Hash: txid}))
+	assert.Nil(t, remote.send(CommandPing, &Ping{Nonce: 1}))
+	assert.Nil(t, peer.handle())
+	assert.Nil(t, peer.handle())
+
+	ctx = &Ctx{peer: remote}
+	remote.header(ctx)
+	assert.Nil(t, ctx.err)
+	assert.Equal(t, CommandPong, SliceToString(ctx.header.Command[:]))
+}
+
+func TestPeerFeatureNegotiation(t *testing.T) {
+	initOnce()
+
+	a, b := v2Pipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	peer := NewPeer(&testSniffer{}, a.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	peer.Mock(netpoll.NewReader(a), netpoll.NewWriter(a))
+	remote := NewPeer(&testSniffer{}, b.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	remote.Mock(netpoll.NewReader(b), netpoll.NewWriter(b))
+
+	// the feature offers of recent remotes are not rejected, so the pong is the first answer
+	assert.Nil(t, remote.send(CommandWtxidRelay, nil))
+	assert.Nil(t, remote.send(CommandSendAddrV2, nil))
+	assert.Nil(t, remote.send(CommandPing, &Ping{Nonce: 1}))
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, peer.handle())
+	}
+
+	ctx := &Ctx{peer: remote}
+	remote.header(ctx)
+	assert.Nil(t, ctx.err)
+	assert.Equal(t, CommandPong, SliceToString(ctx.header.Command[:]))
+}
+
+func TestPeerOfferedTxRcncl(t *testing.T) {
+	initOnce()
+
+	a, b := v2Pipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	s := &testSniffer{}
+	peer := NewPeer(s, a.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	peer.Mock(netpoll.NewReader(a), netpoll.NewWriter(a))
+	remote := NewPeer(&testSniffer{}, b.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	remote.Mock(netpoll.NewReader(b), netpoll.NewWriter(b))
+
+	// the announcements before the offer are not marked
+	assert.Nil(t, remote.sendInv(Inventory{Type: MSG_TX, Hash: [32]byte{1}}))
+	assert.Nil(t, peer.handle())
+	assert.False(t, peer.OfferedTxRcncl())
+
+	// the announcements of a remote offering reconciliation are marked
+	assert.Nil(t, remote.send(CommandSendTxRcncl, &SendTxRcncl{Version: TxReconciliationVersion, Salt: 1}))
+	assert.Nil(t, remote.sendInv(Inventory{Type: MSG_TX, Hash: [32]byte{2}}))
+	assert.Nil(t, peer.handle())
+	assert.True(t, peer.OfferedTxRcncl())
+	assert.Nil(t, peer.handle())
+	assert.Equal(t, 2, len(s.notifies))
+	assert.False(t, s.notifies[0].OfferedTxRcncl)
+	assert.True(t, s.notifies[1].OfferedTxRcncl)
+}
+
+func TestPeerBloomFilter(t *testing.T) {
+	initOnce()
+
+	a, b := v2Pipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	peer := NewPeer(&testSniffer{}, a.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	peer.Mock(netpoll.NewReader(a), netpoll.NewWriter(a))
+	remote := NewPeer(&testSniffer{}, b.RemoteAddr().(*net.TCPAddr)).(*Peer)
+	remote.Mock(netpoll.NewReader(b), netpoll.NewWriter(b))
+
+	// filteradd inserts the element into the loaded filter, filterclear drops it
+	data := []byte{1, 2, 3}
+	assert.Nil(t, remote.send(CommandFilterLoad, NewBloomFilter(10, 0.01, 0, BLOOM_UPDATE_NONE).FilterLoad()))
+	assert.Nil(t, remote.send(CommandFilterAdd, &FilterAdd{Count: VarInt(len(data)), Data: data}))
+	assert.Nil(t, peer.handle())
+	assert.Nil(t, peer.handle())
+	assert.True(t, peer.filter.Contains(data))
+	assert.Nil(t, remote.send(CommandFilterClear, nil))
+	assert.Nil(t, peer.handle())
+	assert.Nil(t, peer.filter)
+
+	// filteradd without a filter is rejected
+	assert.Nil(t, remote.send(CommandFilterAdd, &FilterAdd{Count: VarInt(len(data)), Data: data}))
+	assert.Nil(t, peer.handle())
+	ctx := &Ctx{peer: remote}
+	remote.header(ctx)
+	assert.Nil(t, ctx.err)
+	assert.Equal(t, CommandReject, SliceToString(ctx.header.Command[:]))
+}
+
+func TestPeerHalt(t *testing.T) {
+	initOnce()
+
+	// the remote accepts the connection but never answers, so the peer waits in the v2 handshake
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			defer conn.Close()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+
+	peer := NewPeer(&testSniffer{}, l.Addr().(*net.TCPAddr)).(*Peer)
+	peer.preferV2 = true
+	exited := make(chan error, 1)
+	go func() {
+		exited <- peer.Spin()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, peer.Halt())
+	select {
+	case err = <-exited:
+		assert.NotNil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("halted peer kept spinning")
+	}
+	assert.Equal(t, argos.ErrPeerNotRunning, peer.Halt())
+
+	// a peer halted before spinning never connects
+	peer = NewPeer(&testSniffer{}, l.Addr().(*net.TCPAddr)).(*Peer)
+	assert.Nil(t, peer.Halt())
+	assert.NotNil(t, peer.Spin())
+	assert.Nil(t, peer.conn)
+}
+
+func TestCountMessage(t *testing.T) {
+	inv, unsupported := messageCounters[CommandInv], messageCounters[unsupportedCommand]
+	invs, unsupporteds := inv.Count(), unsupported.Count()
+
+	countMessage(CommandInv)
+	countMessage("madeup")
+	assert.Equal(t, invs+1, inv.Count())
+	assert.Equal(t, unsupporteds+1, unsupported.Count())
+	_, ok := messageCounters["madeup"]
+	assert.False(t, ok)
+}
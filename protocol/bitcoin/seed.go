@@ -1,7 +1,9 @@
 package bitcoin
 
 import (
-	"errors"
+	"bytes"
+	"context"
+	_ "embed"
 	"fmt"
 	"math/rand"
 	"net"
@@ -10,45 +12,177 @@ import (
 	"github.com/AlaricGilbert/argos-core/argos"
 )
 
+// DefaultPort is the default port of the bitcoin main network
+const DefaultPort = 8333
+
+// DNSSeedTimeout limits the time waiting for a DNS seed to respond
+const DNSSeedTimeout = 10 * time.Second
+
+// Resolver resolves the hosts of DNS seeds, *net.Resolver implements it
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+var resolver Resolver = net.DefaultResolver
+
+// SetResolver replaces the resolver querying DNS seeds, e.g. with a fake one in tests
+func SetResolver(r Resolver) {
+	resolver = r
+}
+
+// dnsSeed is a DNS seed host and the service filters it supports
+type dnsSeed struct {
+	host string
+	// filters are the services bitfields the seed is known to filter nodes by, nil means none is declared
+	filters []ServiceType
+}
+
+// supports checks whether the seed declared filtering nodes by all the given services
+func (s *dnsSeed) supports(services ServiceType) bool {
+	if services == 0 {
+		return true
+	}
+	_, ok := Index(s.filters, services)
+	return ok
+}
+
+// filteredHost returns the host providing nodes serving all the given services, which is the "x" prefixed
+// hexadecimal services bitfield subdomain of the seed. The seed itself is returned when it did not declare
+// the filter, its nodes are then not known to serve the services.
+func (s *dnsSeed) filteredHost(services ServiceType) string {
+	if services == 0 || !s.supports(services) {
+		return s.host
+	}
+	return fmt.Sprintf("x%x.%s", uint64(services), s.host)
+}
+
+// serviceFilters returns all the filters from the first to the last
+func serviceFilters(first, last ServiceType) []ServiceType {
+	var filters []ServiceType
+	for s := first; s <= last; s++ {
+		filters = append(filters, s)
+	}
+	return filters
+}
+
 // The DNS host from https://github.com/bitcoin/bitcoin core repository
 // When started for the first time, programs don’t know the IP addresses of any active full nodes. In order to discover
 // some IP addresses, we query one or more DNS names hardcoded here. The response to the lookup should include one or
 // more DNS A records with the IP addresses of full nodes that may accept new incoming connections.
-var btcSeedHosts = []string{
-	"seed.bitcoin.sipa.be.",          // Pieter Wuille, only supports x1, x5, x9, and xd
-	"dnsseed.bluematt.me.",           // Matt Corallo, only supports x9
-	"dnsseed.bitcoin.dashjr.org.",    // Luke Dashjr
-	"seed.bitcoinstats.com.",         // Christian Decker, supports x1 - xf
-	"seed.bitcoin.jonasschnelli.ch.", // Jonas Schnelli, only supports x1, x5, x9, and xd
-	"seed.btc.petertodd.org.",        // Peter Todd, only supports x1, x5, x9, and xd
-	"seed.bitcoin.sprovoost.nl.",     // Sjors Provoost
-	"dnsseed.emzy.de.",               // Stephan Oeste
-	"seed.bitcoin.wiz.biz.",          // Jason Maurice
+var btcSeedHosts = []dnsSeed{
+	{"seed.bitcoin.sipa.be.", []ServiceType{0x1, 0x5, 0x9, 0xd}},          // Pieter Wuille, only supports x1, x5, x9, and xd
+	{"dnsseed.bluematt.me.", []ServiceType{0x9}},                          // Matt Corallo, only supports x9
+	{"dnsseed.bitcoin.dashjr.org.", nil},                                  // Luke Dashjr
+	{"seed.bitcoinstats.com.", serviceFilters(0x1, 0xf)},                  // Christian Decker, supports x1 - xf
+	{"seed.bitcoin.jonasschnelli.ch.", []ServiceType{0x1, 0x5, 0x9, 0xd}}, // Jonas Schnelli, only supports x1, x5, x9, and xd
+	{"seed.btc.petertodd.org.", []ServiceType{0x1, 0x5, 0x9, 0xd}},        // Peter Todd, only supports x1, x5, x9, and xd
+	{"seed.bitcoin.sprovoost.nl.", nil},                                   // Sjors Provoost
+	{"dnsseed.emzy.de.", nil},                                             // Stephan Oeste
+	{"seed.bitcoin.wiz.biz.", nil},                                        // Jason Maurice
 }
 
+//go:embed fixedseeds.txt
+var btcFixedSeeds []byte
+
 var seedRng = rand.New((rand.NewSource(time.Now().Unix())))
 
-// LookupBTCNetwork queries all the possible connected DNS servers and returns the core BTC network seed.
-func LookupBTCNetwork() ([]net.TCPAddr, error) {
-	var result = make([]net.IP, 0)
-	var nodes = make([]net.TCPAddr, 0)
-	for _, host := range btcSeedHosts {
-		ips, err := net.LookupIP(host)
+// lookupIP resolves the host by the injected resolver
+func lookupIP(host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DNSSeedTimeout)
+	defer cancel()
+	return resolver.LookupIP(ctx, "ip", host)
+}
+
+// dnsSeedProvider returns a provider querying a single DNS seed host
+func dnsSeedProvider(host string) argos.SeedProvider {
+	return func() ([]net.TCPAddr, error) {
+		ips, err := lookupIP(host)
 		if err != nil {
-			argos.StandardLogger().Errorf("[LookupBTCNetwork] Get BTC DNS seed from host `%s` failed: %v", host, err)
-			return nil, errors.New(fmt.Sprintf("Get BTC DNS seed from host `%s` failed: %v", host, err))
+			return nil, fmt.Errorf("Get BTC DNS seed from host `%s` failed: %w", host, err)
+		}
+		nodes := make([]net.TCPAddr, 0, len(ips))
+		for _, ip := range ips {
+			nodes = append(nodes, net.TCPAddr{IP: ip, Port: DefaultPort})
 		}
-		result = append(result, ips...)
+		return nodes, nil
 	}
-	for _, ip := range result {
-		nodes = append(nodes, net.TCPAddr{IP: ip, Port: 8333})
+}
+
+// LookupBTCNetwork queries all the DNS seeds and returns the core BTC network seed serving the services
+// in the options, see LookupBTCNetworkWithServices.
+func LookupBTCNetwork() ([]net.TCPAddr, error) {
+	return LookupBTCNetworkWithServices(GetOptions().SeedServices)
+}
+
+// LookupBTCNetworkWithServices queries the DNS seeds for nodes serving all the given services through
+// their filtered subdomains, e.g. x9.seed.bitcoin.sipa.be. for NODE_NETWORK|NODE_WITNESS. The seeds not
+// declaring the filter are queried by their own names, and the seeds failed to respond are skipped, so it
+// only fails when none of them responds.
+func LookupBTCNetworkWithServices(services ServiceType) ([]net.TCPAddr, error) {
+	var providers = make([]argos.SeedProvider, 0, len(btcSeedHosts))
+	for i := range btcSeedHosts {
+		providers = append(providers, dnsSeedProvider(btcSeedHosts[i].filteredHost(services)))
+	}
+	return argos.CompositeSeedProvider(providers...)()
+}
+
+// FixedSeeds returns the fixed seed nodes embedded into the binary, see fixedseeds.txt
+func FixedSeeds() ([]net.TCPAddr, error) {
+	nodes, err := argos.ParseSeeds(bytes.NewReader(btcFixedSeeds), DefaultPort)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, argos.ErrNoSeeds
 	}
 	return nodes, nil
 }
 
+// Seeds provides the seed nodes from the sources enabled in the options: the static seeds, the seed file,
+// the DNS seeds and the fixed seeds. It tolerates the failures of any but not all of them.
+func Seeds() ([]net.TCPAddr, error) {
+	var providers []argos.SeedProvider
+	s := loadSettings()
+
+	if len(s.Seeds) > 0 {
+		var nodes []net.TCPAddr
+		for _, seed := range s.Seeds {
+			node, err := argos.ParseSeed(seed, DefaultPort)
+			if err != nil {
+				argos.StandardLogger().WithError(err).WithField("seed", seed).Warn("invalid static seed skipped")
+				continue
+			}
+			nodes = append(nodes, node)
+		}
+		providers = append(providers, argos.StaticSeedProvider(nodes))
+	}
+	if s.SeedFile != "" {
+		providers = append(providers, argos.FileSeedProvider(s.SeedFile, DefaultPort))
+	}
+	// the DNS and fixed seeds only know nodes of the main network
+	if s.DNSSeeds && s.magic == MagicMain {
+		providers = append(providers, LookupBTCNetwork)
+	}
+	if s.FixedSeeds && s.magic == MagicMain {
+		providers = append(providers, FixedSeeds)
+	}
+	return argos.CompositeSeedProvider(providers...)()
+}
+
+// RandomSeedAddress returns a random node provided by Seeds
+func RandomSeedAddress() (*net.TCPAddr, error) {
+	nodes, err := Seeds()
+	if err != nil {
+		return nil, err
+	}
+	return &nodes[seedRng.Intn(len(nodes))], nil
+}
+
 func LookupRandomBTCNetwork() (net.IP, error) {
-	if ips, err := net.LookupIP(btcSeedHosts[seedRng.Intn(len(btcSeedHosts))]); err != nil {
+	if ips, err := lookupIP(btcSeedHosts[seedRng.Intn(len(btcSeedHosts))].host); err != nil {
 		return nil, err
+	} else if len(ips) == 0 {
+		return nil, argos.ErrNoSeeds
 	} else {
 		return ips[seedRng.Intn(len(ips))], nil
 	}
@@ -58,6 +192,6 @@ func LookupRandomBTCHostAddress() (*net.TCPAddr, error) {
 	if ip, err := LookupRandomBTCNetwork(); err != nil {
 		return nil, err
 	} else {
-		return &net.TCPAddr{IP: ip, Port: 8333}, nil
+		return &net.TCPAddr{IP: ip, Port: DefaultPort}, nil
 	}
 }
@@ -1,8 +1,14 @@
 package bitcoin
 
 import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/AlaricGilbert/argos-core/argos"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,3 +19,130 @@ func TestLookupBTCNetwork(t *testing.T) {
 		t.Logf("Get bitcoin seeds ok:[%v]", ips)
 	}
 }
+
+// fakeResolver answers the hosts in its records and fails the others
+type fakeResolver struct {
+	records map[string][]net.IP
+	queried []string
+}
+
+func (r *fakeResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r.queried = append(r.queried, host)
+	if ips, ok := r.records[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestLookupBTCNetworkWithServices(t *testing.T) {
+	r := &fakeResolver{records: map[string][]net.IP{
+		"x9.seed.bitcoin.sipa.be.":    {net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)},
+		"x9.dnsseed.bluematt.me.":     {net.IPv4(192, 0, 2, 2)},
+		"x9.seed.bitcoin.wiz.biz.":    {net.IPv4(192, 0, 2, 3)},
+		"x809.seed.bitcoin.sipa.be.":  {net.IPv4(192, 0, 2, 4)},
+		"seed.bitcoin.sipa.be.":       {net.IPv4(192, 0, 2, 5)},
+		"dnsseed.bitcoin.dashjr.org.": {net.IPv4(192, 0, 2, 6)},
+		"seed.bitcoin.wiz.biz.":       {net.ParseIP("2001:db8::1")},
+	}}
+	SetResolver(r)
+	defer SetResolver(net.DefaultResolver)
+
+	// failing seeds are tolerated and the duplicated node is removed
+	nodes, err := LookupBTCNetworkWithServices(NODE_NETWORK | NODE_WITNESS)
+	assert.Nil(t, err)
+	var result []string
+	for _, node := range nodes {
+		result = append(result, node.String())
+	}
+	assert.Equal(t, []string{"192.0.2.1:8333", "192.0.2.2:8333", "192.0.2.6:8333", "[2001:db8::1]:8333"}, result)
+	assert.Equal(t, len(btcSeedHosts), len(r.queried))
+
+	// the seeds not declaring the filter are queried by their own names instead of a guessed subdomain
+	assert.Contains(t, r.queried, "dnsseed.bitcoin.dashjr.org.")
+	assert.NotContains(t, r.queried, "x9.dnsseed.bitcoin.dashjr.org.")
+	assert.NotContains(t, r.queried, "x9.seed.bitcoin.wiz.biz.")
+
+	// none of the seeds declared the filter, so all of them are queried by their own names
+	for _, services := range []ServiceType{NODE_NETWORK | NODE_WITNESS | NODE_P2P_V2, 0} {
+		r.queried = nil
+		nodes, err = LookupBTCNetworkWithServices(services)
+		assert.Nil(t, err)
+		result = nil
+		for _, node := range nodes {
+			result = append(result, node.String())
+		}
+		assert.Equal(t, []string{"192.0.2.5:8333", "192.0.2.6:8333", "[2001:db8::1]:8333"}, result)
+		assert.Equal(t, len(btcSeedHosts), len(r.queried))
+		for i := range btcSeedHosts {
+			assert.Contains(t, r.queried, btcSeedHosts[i].host)
+		}
+	}
+
+	// all seeds failed
+	SetResolver(&fakeResolver{})
+	_, err = LookupBTCNetworkWithServices(NODE_NETWORK | NODE_WITNESS)
+	assert.NotNil(t, err)
+}
+
+func TestSeedsWithoutDNS(t *testing.T) {
+	defer func() {
+		_ = SetOptions(DefaultOptions())
+	}()
+
+	path := filepath.Join(t.TempDir(), "seeds.txt")
+	assert.Nil(t, os.WriteFile(path, []byte("192.0.2.2\n192.0.2.3:18333\n"), 0644))
+
+	o := DefaultOptions()
+	o.DNSSeeds = false
+	o.FixedSeeds = false
+	o.Seeds = []string{"192.0.2.1:8333", "192.0.2.2"}
+	o.SeedFile = path
+	assert.Nil(t, SetOptions(o))
+
+	nodes, err := Seeds()
+	assert.Nil(t, err)
+	var result []string
+	for _, node := range nodes {
+		result = append(result, node.String())
+	}
+	assert.Equal(t, []string{"192.0.2.1:8333", "192.0.2.2:8333", "192.0.2.3:18333"}, result)
+
+	node, err := RandomSeedAddress()
+	assert.Nil(t, err)
+	assert.Contains(t, result, node.String())
+
+	// the fixed seeds are left when the other sources are disabled
+	o.Seeds, o.SeedFile = nil, ""
+	o.FixedSeeds = true
+	assert.Nil(t, SetOptions(o))
+	nodes, err = Seeds()
+	assert.Nil(t, err)
+	fixed, err := FixedSeeds()
+	assert.Nil(t, err)
+	assert.Equal(t, fixed, nodes)
+
+	// and none is left without them
+	o.FixedSeeds = false
+	assert.Nil(t, SetOptions(o))
+	_, err = Seeds()
+	assert.True(t, errors.Is(err, argos.ErrNoSeeds))
+}
+
+func TestFixedSeeds(t *testing.T) {
+	nodes, err := FixedSeeds()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, nodes)
+
+	var ipv4, ipv6 int
+	for _, node := range nodes {
+		assert.Equal(t, DefaultPort, node.Port)
+		if node.IP.To4() != nil {
+			ipv4++
+		} else {
+			ipv6++
+		}
+	}
+	// the list of bitcoin core has addresses of both networks
+	assert.Greater(t, ipv4, 0)
+	assert.Greater(t, ipv6, 0)
+}
@@ -1,6 +1,7 @@
 package bitcoin
 
 import (
+	"encoding/hex"
 	"testing"
 
 	"github.com/AlaricGilbert/argos-core/argos/serialization"
@@ -325,3 +326,98 @@ func TestDeserializeTx(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, data, serialized)
 }
+
+func TestDeserializeSendTxRcncl(t *testing.T) {
+	initOnce()
+
+	var data = []byte{
+		0x01, 0x00, 0x00, 0x00, 0xEF, 0xCD, 0xAB, 0x89, 0x67, 0x45, 0x23, 0x01,
+	}
+
+	// Payload:
+	//  01 00 00 00                                     - reconciliation protocol version 1
+	//  EF CD AB 89 67 45 23 01                         - salt 0x0123456789abcdef
+	var txrcncl SendTxRcncl
+	var err error
+	var n int
+	var serialized []byte
+
+	buf := netpoll.NewLinkBuffer()
+	_, _ = buf.WriteBinary(data)
+	_ = buf.Flush()
+
+	n, err = serialization.Deserialize(buf, &txrcncl)
+	assert.Nil(t, err)
+	assert.Equal(t, 12, n)
+	assert.Equal(t, uint32(TxReconciliationVersion), txrcncl.Version)
+	assert.Equal(t, uint64(0x0123456789abcdef), txrcncl.Salt)
+
+	n, err = serialization.Serialize(buf, &txrcncl)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n)
+
+	buf.Flush()
+
+	serialized, err = buf.ReadBinary(len(data))
+	assert.Nil(t, err)
+	assert.Equal(t, data, serialized)
+}
+
+func TestDeserializeReconcilDiff(t *testing.T) {
+	initOnce()
+
+	var data = []byte{
+		0x01, 0x02, 0x78, 0x56, 0x34, 0x12, 0xEF, 0xBE, 0xAD, 0xDE,
+	}
+
+	// Payload:
+	//  01                                              - sketch decoded successfully
+	//  02                                              - 2 short ids to follow
+	//  78 56 34 12                                     - short id 0x12345678
+	//  EF BE AD DE                                     - short id 0xdeadbeef
+	var diff ReconcilDiff
+	var err error
+	var n int
+	var serialized []byte
+
+	buf := netpoll.NewLinkBuffer()
+	_, _ = buf.WriteBinary(data)
+	_ = buf.Flush()
+
+	n, err = serialization.Deserialize(buf, &diff)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n)
+	assert.Equal(t, uint8(1), diff.Success)
+	assert.Equal(t, []uint32{0x12345678, 0xdeadbeef}, diff.AskShortIDs)
+
+	n, err = serialization.Serialize(buf, &diff)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n)
+
+	buf.Flush()
+
+	serialized, err = buf.ReadBinary(len(data))
+	assert.Nil(t, err)
+	assert.Equal(t, data, serialized)
+}
+
+func TestTransactionTxID(t *testing.T) {
+	// transaction b4749f017444b051c44dfd2720e88f314ff94f3dd6d56d40ef65854fcd7fff6b from the BIP37 reference test suite
+	var data = mustDecodeHex(t, "01000000010b26e9b7735eb6aabdf358bab62f9816a21ba9ebdb719d5299e88607d722c19000"+
+		"0000008b4830450220070aca44506c5cef3a16ed519d7c3c39f8aab192c4e1c90d065f37b8a4af6141022100a8e160b856c2d43d"+
+		"27d8fba71e5aef6405b8643ac4cb7cb3c462aced7f14711a0141046d11fee51b0e60666d5049a9101a72741df480b96ee26488a4"+
+		"d3466b95c9a40ac5eeef87e10a5cd336c19a84565f80fa6c547957b7700ff4dfbdefe76036c339ffffffff021bff3d1100000000"+
+		"1976a91404943fdd508053c75000106d3bc6e2754dbcff1988ac2f15de00000000001976a914a266436d2965547608b9e15d9032"+
+		"a7b9d64fa43188ac00000000")
+
+	var tx Transaction
+	buf := netpoll.NewLinkBuffer()
+	_, _ = buf.WriteBinary(data)
+	_ = buf.Flush()
+	n, err := serialization.Deserialize(buf, &tx)
+	assert.Nil(t, err)
+	assert.Equal(t, len(data), n)
+
+	txid := tx.TxID()
+	assert.Equal(t, "b4749f017444b051c44dfd2720e88f314ff94f3dd6d56d40ef65854fcd7fff6b", hex.EncodeToString(reversed(txid[:])))
+}
@@ -97,7 +97,7 @@ type ServiceType uint64
 
 // String implements fmt.Stringer
 func (s ServiceType) String() string {
-	return fmt.Sprintf("{NODE_NETWORK: %t, NODE_GETUTXO: %t, NODE_BLOOM: %t, NODE_WITNESS: %t, NODE_XTHIN: %t, NODE_COMPACT_FILTERS: %t, NODE_NETWORK_LIMITED: %t}",
+	return fmt.Sprintf("{NODE_NETWORK: %t, NODE_GETUTXO: %t, NODE_BLOOM: %t, NODE_WITNESS: %t, NODE_XTHIN: %t, NODE_COMPACT_FILTERS: %t, NODE_NETWORK_LIMITED: %t, NODE_P2P_V2: %t}",
 		s.Serves(NODE_NETWORK),
 		s.Serves(NODE_GETUTXO),
 		s.Serves(NODE_BLOOM),
@@ -105,10 +105,11 @@ func (s ServiceType) String() string {
 		s.Serves(NODE_XTHIN),
 		s.Serves(NODE_COMPACT_FILTERS),
 		s.Serves(NODE_NETWORK_LIMITED),
+		s.Serves(NODE_P2P_V2),
 	)
 }
 
-// Serves checks the ServicesType contains the reference ServiceType (which should be set one of: NODE_NETWORK, NODE_GETUTXO, NODE_BLOOM, NODE_WITNESS, NODE_XTHIN, NODE_COMPACT_FILTERS or NODE_NETWORK_LIMITED)
+// Serves checks the ServicesType contains the reference ServiceType (which should be set one of: NODE_NETWORK, NODE_GETUTXO, NODE_BLOOM, NODE_WITNESS, NODE_XTHIN, NODE_COMPACT_FILTERS, NODE_NETWORK_LIMITED or NODE_P2P_V2)
 func (s ServiceType) Serves(reference ServiceType) bool {
 	return (uint64(s) & uint64(reference)) != 0
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"strings"
+	"sync"
 )
 
 // hash returns the hash result of sha256(sha256(data))
@@ -52,3 +53,37 @@ func FmtSlice[T any](slice []T, dataFmt func(T) string) string {
 	builder.WriteRune(']')
 	return builder.String()
 }
+
+// recentSet remembers the latest hashes added to it up to its capacity, the oldest ones are forgotten first
+type recentSet struct {
+	mu   sync.Mutex
+	seen map[[32]byte]struct{}
+	ring [][32]byte
+	next int
+}
+
+func newRecentSet(capacity int) *recentSet {
+	return &recentSet{
+		seen: make(map[[32]byte]struct{}, capacity),
+		ring: make([][32]byte, 0, capacity),
+	}
+}
+
+// add adds the hash and reports whether it was not remembered yet
+func (s *recentSet) add(h [32]byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.seen[h]; ok {
+		return false
+	}
+	if len(s.ring) < cap(s.ring) {
+		s.ring = append(s.ring, h)
+	} else {
+		delete(s.seen, s.ring[s.next])
+		s.ring[s.next] = h
+		s.next = (s.next + 1) % len(s.ring)
+	}
+	s.seen[h] = struct{}{}
+	return true
+}
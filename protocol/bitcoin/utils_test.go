@@ -25,3 +25,15 @@ func TestSliceToString(t *testing.T) {
 	var arr = []byte{'H', 'e', 'l', 'l', '0', ',', ' ', 'w', '0', 'r', 'l', 'd', '!', '\000'}
 	assert.Equal(t, "Hell0, w0rld!", SliceToString(arr))
 }
+
+func TestRecentSet(t *testing.T) {
+	s := newRecentSet(2)
+	assert.True(t, s.add([32]byte{1}))
+	assert.False(t, s.add([32]byte{1}))
+	assert.True(t, s.add([32]byte{2}))
+	// the oldest hash is forgotten when a third one is added
+	assert.True(t, s.add([32]byte{3}))
+	assert.True(t, s.add([32]byte{1}))
+	assert.False(t, s.add([32]byte{3}))
+	assert.Len(t, s.seen, 2)
+}
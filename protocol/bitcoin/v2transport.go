@@ -0,0 +1,413 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/cloudwego/netpoll"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// v2RekeyInterval is the number of messages (or length chunks) encrypted with a single key
+	v2RekeyInterval = 224
+	// v2LengthFieldLength is the size of the encrypted packet length field
+	v2LengthFieldLength = 3
+	// v2HeaderLength is the size of the packet header which carries the ignore bit
+	v2HeaderLength = 1
+	// v2IgnoreBit marks decoy packets which must be ignored by the receiver
+	v2IgnoreBit = 0x80
+	// v2GarbageTerminatorLength is the size of the garbage terminators
+	v2GarbageTerminatorLength = 16
+	// v2MaxGarbageLength is the maximum size of the garbage sent after the public key
+	v2MaxGarbageLength = 4095
+	// v2PubKeyLength is the size of an ElligatorSwift encoded public key
+	v2PubKeyLength = 64
+)
+
+var (
+	// ErrV2V1Detected means the responder received a v1 version message instead of a v2 public key
+	ErrV2V1Detected = errors.New("v2 handshake failed: remote is using v1 transport")
+	// ErrV2GarbageTooLong means the remote garbage terminator was not found within the maximum garbage length
+	ErrV2GarbageTooLong = errors.New("v2 handshake failed: garbage terminator not found")
+	// ErrV2PacketTooLong means the remote sent a packet longer than the maximum message length
+	ErrV2PacketTooLong = errors.New("v2 packet too long")
+	// ErrV2InvalidMessage means the remote sent a packet which is not a valid message encoding
+	ErrV2InvalidMessage = errors.New("v2 packet contains invalid message")
+)
+
+// v2ShortIDs maps BIP324 one byte message ids into commands, index 0 means the long 12 bytes command encoding
+var v2ShortIDs = []string{
+	"",
+	CommandAddr,
+	"block",
+	"blocktxn",
+	"cmpctblock",
+	CommandFeeFilter,
+	CommandFilterAdd,
+	CommandFilterClear,
+	CommandFilterLoad,
+	"getblocks",
+	"getblocktxn",
+	CommandGetData,
+	CommandGetHeaders,
+	CommandHeaders,
+	CommandInv,
+	"mempool",
+	"merkleblock",
+	CommandNotFound,
+	CommandPing,
+	CommandPong,
+	CommandSendCmpct,
+	CommandTx,
+	"getcfilters",
+	"cfilter",
+	"getcfheaders",
+	"cfheaders",
+	"getcfcheckpt",
+	"cfcheckpt",
+	"addrv2",
+}
+
+var v2ShortIDIndex = func() map[string]byte {
+	index := make(map[string]byte, len(v2ShortIDs))
+	for i, command := range v2ShortIDs[1:] {
+		index[command] = byte(i + 1)
+	}
+	return index
+}()
+
+// fsChaCha20 is the forward secure stream cipher used to encrypt the packet lengths
+type fsChaCha20 struct {
+	key          [32]byte
+	cipher       *chacha20.Cipher
+	chunkCounter uint32
+	rekeyCounter uint64
+}
+
+func newFSChaCha20(key [32]byte) *fsChaCha20 {
+	c := &fsChaCha20{key: key}
+	c.reset()
+	return c
+}
+
+func (c *fsChaCha20) reset() {
+	var nonce [chacha20.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], c.rekeyCounter)
+	// key and nonce have valid sizes, so creating cipher never fails
+	c.cipher, _ = chacha20.NewUnauthenticatedCipher(c.key[:], nonce[:])
+}
+
+// crypt encrypts or decrypts a chunk, the key is replaced by the next keystream bytes every v2RekeyInterval chunks
+func (c *fsChaCha20) crypt(dst, src []byte) {
+	c.cipher.XORKeyStream(dst, src)
+	if c.chunkCounter++; c.chunkCounter == v2RekeyInterval {
+		var key [32]byte
+		c.cipher.XORKeyStream(key[:], key[:])
+		c.key = key
+		c.chunkCounter = 0
+		c.rekeyCounter++
+		c.reset()
+	}
+}
+
+// fsChaCha20Poly1305 is the forward secure AEAD used to encrypt the packet contents
+type fsChaCha20Poly1305 struct {
+	key           [32]byte
+	packetCounter uint64
+}
+
+func (c *fsChaCha20Poly1305) nonce() []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint32(nonce[:4], uint32(c.packetCounter%v2RekeyInterval))
+	binary.LittleEndian.PutUint64(nonce[4:], c.packetCounter/v2RekeyInterval)
+	return nonce[:]
+}
+
+// next advances the packet counter and rekeys when a rekey interval finished
+func (c *fsChaCha20Poly1305) next(aead cipher.AEAD) {
+	if (c.packetCounter+1)%v2RekeyInterval == 0 {
+		nonce := c.nonce()
+		copy(nonce[:4], []byte{0xFF, 0xFF, 0xFF, 0xFF})
+		var zero [32]byte
+		copy(c.key[:], aead.Seal(nil, nonce, zero[:], nil)[:32])
+	}
+	c.packetCounter++
+}
+
+func (c *fsChaCha20Poly1305) seal(dst, aad, plaintext []byte) []byte {
+	aead, _ := chacha20poly1305.New(c.key[:])
+	dst = aead.Seal(dst, c.nonce(), plaintext, aad)
+	c.next(aead)
+	return dst
+}
+
+func (c *fsChaCha20Poly1305) open(aad, ciphertext []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.New(c.key[:])
+	plaintext, err := aead.Open(nil, c.nonce(), ciphertext, aad)
+	c.next(aead)
+	return plaintext, err
+}
+
+// v2Transport holds the ciphers of an established BIP324 session
+type v2Transport struct {
+	sendL                 *fsChaCha20
+	sendP                 *fsChaCha20Poly1305
+	recvL                 *fsChaCha20
+	recvP                 *fsChaCha20Poly1305
+	sendGarbageTerminator [v2GarbageTerminatorLength]byte
+	recvGarbageTerminator [v2GarbageTerminatorLength]byte
+	sessionID             [32]byte
+	// garbage is authenticated as the associated data of the first packet in each direction
+	sendGarbage []byte
+	recvGarbage []byte
+}
+
+// newV2Transport derives the session keys from the ECDH shared secret
+func newV2Transport(secret [32]byte, magic NetworkMagic, initiator bool) *v2Transport {
+	salt := make([]byte, len("bitcoin_v2_shared_secret")+4)
+	copy(salt, "bitcoin_v2_shared_secret")
+	binary.LittleEndian.PutUint32(salt[len("bitcoin_v2_shared_secret"):], uint32(magic))
+	prk := hkdf.Extract(sha256.New, secret[:], salt)
+
+	expand := func(info string) (out [32]byte) {
+		// reading 32 bytes from hkdf never fails
+		_, _ = io.ReadFull(hkdf.Expand(sha256.New, prk, []byte(info)), out[:])
+		return
+	}
+
+	initiatorL, initiatorP := expand("initiator_L"), expand("initiator_P")
+	responderL, responderP := expand("responder_L"), expand("responder_P")
+	terminators := expand("garbage_terminators")
+
+	t := &v2Transport{sessionID: expand("session_id")}
+	if initiator {
+		t.sendL, t.sendP = newFSChaCha20(initiatorL), &fsChaCha20Poly1305{key: initiatorP}
+		t.recvL, t.recvP = newFSChaCha20(responderL), &fsChaCha20Poly1305{key: responderP}
+		copy(t.sendGarbageTerminator[:], terminators[:v2GarbageTerminatorLength])
+		copy(t.recvGarbageTerminator[:], terminators[v2GarbageTerminatorLength:])
+	} else {
+		t.sendL, t.sendP = newFSChaCha20(responderL), &fsChaCha20Poly1305{key: responderP}
+		t.recvL, t.recvP = newFSChaCha20(initiatorL), &fsChaCha20Poly1305{key: initiatorP}
+		copy(t.sendGarbageTerminator[:], terminators[v2GarbageTerminatorLength:])
+		copy(t.recvGarbageTerminator[:], terminators[:v2GarbageTerminatorLength])
+	}
+	return t
+}
+
+// encrypt builds an encrypted packet from the given contents
+func (t *v2Transport) encrypt(contents []byte, ignore bool) []byte {
+	plaintext := make([]byte, v2HeaderLength+len(contents))
+	if ignore {
+		plaintext[0] = v2IgnoreBit
+	}
+	copy(plaintext[v2HeaderLength:], contents)
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(contents)))
+
+	packet := make([]byte, v2LengthFieldLength, v2LengthFieldLength+len(plaintext)+chacha20poly1305.Overhead)
+	t.sendL.crypt(packet, length[:v2LengthFieldLength])
+
+	aad := t.sendGarbage
+	t.sendGarbage = nil
+	return t.sendP.seal(packet, aad, plaintext)
+}
+
+// readPacket reads and decrypts the next packet from the reader
+func (t *v2Transport) readPacket(r netpoll.Reader) (ignore bool, contents []byte, err error) {
+	var encLength, ciphertext, plaintext []byte
+	var length [4]byte
+
+	if encLength, err = r.ReadBinary(v2LengthFieldLength); err != nil {
+		return
+	}
+	t.recvL.crypt(length[:v2LengthFieldLength], encLength)
+
+	// the contents contains a message type (at most 1 + 12 bytes) and the message payload
+	size := int(binary.LittleEndian.Uint32(length[:]))
+	if size > BitcoinMessageMaxLength+1+12 {
+		err = ErrV2PacketTooLong
+		return
+	}
+
+	if ciphertext, err = r.ReadBinary(v2HeaderLength + size + chacha20poly1305.Overhead); err != nil {
+		return
+	}
+
+	aad := t.recvGarbage
+	t.recvGarbage = nil
+	if plaintext, err = t.recvP.open(aad, ciphertext); err != nil {
+		return
+	}
+
+	return plaintext[0]&v2IgnoreBit != 0, plaintext[v2HeaderLength:], nil
+}
+
+// v2EncodeMessage encodes the command using short message id if possible and appends the payload
+func v2EncodeMessage(command string, payload []byte) []byte {
+	if id, ok := v2ShortIDIndex[command]; ok {
+		return append([]byte{id}, payload...)
+	}
+	contents := make([]byte, 1+12, 1+12+len(payload))
+	copy(contents[1:], command)
+	return append(contents, payload...)
+}
+
+// v2DecodeMessage splits the packet contents into the command and the payload,
+// unknown short message ids are decoded as an empty command
+func v2DecodeMessage(contents []byte) (string, []byte, error) {
+	if len(contents) == 0 {
+		return "", nil, ErrV2InvalidMessage
+	}
+	if id := contents[0]; id != 0 {
+		if int(id) < len(v2ShortIDs) {
+			return v2ShortIDs[id], contents[1:], nil
+		}
+		return "", contents[1:], nil
+	}
+	if len(contents) < 1+12 {
+		return "", nil, ErrV2InvalidMessage
+	}
+	return SliceToString(contents[1 : 1+12]), contents[1+12:], nil
+}
+
+// v1Prefix returns the first 16 bytes of a v1 version message on given network,
+// a v2 responder uses it to detect peers which are still using v1 transport
+func v1Prefix(magic NetworkMagic) []byte {
+	prefix := make([]byte, 4+12)
+	binary.LittleEndian.PutUint32(prefix, uint32(magic))
+	copy(prefix[4:], CommandVersion)
+	return prefix
+}
+
+// v2Handshake performs the BIP324 handshake and returns the established transport.
+// When initiator is false and the remote turns out to be a v1 peer, ErrV2V1Detected is returned and
+// no bytes are consumed from the reader, so the connection can be handled by the v1 transport.
+func v2Handshake(r netpoll.Reader, w netpoll.Writer, magic NetworkMagic, initiator bool) (*v2Transport, error) {
+	var err error
+	var key *EllSwiftPrivateKey
+	var theirs [v2PubKeyLength]byte
+	var secret [32]byte
+	var buf []byte
+
+	if !initiator {
+		if buf, err = r.Peek(len(v1Prefix(magic))); err != nil {
+			return nil, err
+		}
+		if bytes.Equal(buf, v1Prefix(magic)) {
+			return nil, ErrV2V1Detected
+		}
+	}
+
+	if key, err = NewEllSwiftPrivateKey(defaultRand); err != nil {
+		return nil, err
+	}
+
+	garbage, err := v2Garbage()
+	if err != nil {
+		return nil, err
+	}
+
+	// the initiator sends its key first, the responder waits for it to detect v1 peers
+	sendKey := func() error {
+		encoded := key.EllSwift()
+		if _, err := w.WriteBinary(encoded[:]); err != nil {
+			return err
+		}
+		if _, err := w.WriteBinary(garbage); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	if initiator {
+		if err = sendKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	if buf, err = r.ReadBinary(v2PubKeyLength); err != nil {
+		return nil, err
+	}
+	copy(theirs[:], buf)
+
+	if !initiator {
+		if err = sendKey(); err != nil {
+			return nil, err
+		}
+	}
+
+	if secret, err = key.ECDH(theirs, initiator); err != nil {
+		return nil, err
+	}
+
+	t := newV2Transport(secret, magic, initiator)
+
+	// send garbage terminator and our version packet, which authenticates the garbage we sent
+	t.sendGarbage = garbage
+	if _, err = w.WriteBinary(t.sendGarbageTerminator[:]); err != nil {
+		return nil, err
+	}
+	if _, err = w.WriteBinary(t.encrypt(nil, false)); err != nil {
+		return nil, err
+	}
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+
+	// skip the remote garbage until the garbage terminator
+	if t.recvGarbage, err = v2ReadGarbage(r, t.recvGarbageTerminator); err != nil {
+		return nil, err
+	}
+
+	// the remote version packet may be preceded by decoy packets, its contents are reserved for
+	// future extensions and ignored
+	for {
+		var ignore bool
+		if ignore, _, err = t.readPacket(r); err != nil {
+			return nil, err
+		}
+		if !ignore {
+			break
+		}
+	}
+
+	return t, nil
+}
+
+// v2Garbage generates random garbage with random length
+func v2Garbage() ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(defaultRand, length[:]); err != nil {
+		return nil, err
+	}
+	garbage := make([]byte, int(binary.LittleEndian.Uint16(length[:]))%(v2MaxGarbageLength+1))
+	if _, err := io.ReadFull(defaultRand, garbage); err != nil {
+		return nil, err
+	}
+	return garbage, nil
+}
+
+// v2ReadGarbage reads until the garbage terminator and returns the garbage before it
+func v2ReadGarbage(r netpoll.Reader, terminator [v2GarbageTerminatorLength]byte) ([]byte, error) {
+	var garbage []byte
+	var b byte
+	var err error
+	for len(garbage) <= v2MaxGarbageLength+v2GarbageTerminatorLength {
+		if b, err = r.ReadByte(); err != nil {
+			return nil, err
+		}
+		garbage = append(garbage, b)
+		if bytes.HasSuffix(garbage, terminator[:]) {
+			return garbage[:len(garbage)-v2GarbageTerminatorLength], nil
+		}
+	}
+	return nil, ErrV2GarbageTooLong
+}
@@ -0,0 +1,169 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cloudwego/netpoll"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV2MessageEncoding(t *testing.T) {
+	contents := v2EncodeMessage(CommandInv, []byte{1, 2, 3})
+	assert.Equal(t, []byte{14, 1, 2, 3}, contents)
+
+	command, payload, err := v2DecodeMessage(contents)
+	assert.Nil(t, err)
+	assert.Equal(t, CommandInv, command)
+	assert.Equal(t, []byte{1, 2, 3}, payload)
+
+	contents = v2EncodeMessage(CommandVersion, []byte{4})
+	assert.Equal(t, 1+12+1, len(contents))
+
+	command, payload, err = v2DecodeMessage(contents)
+	assert.Nil(t, err)
+	assert.Equal(t, CommandVersion, command)
+	assert.Equal(t, []byte{4}, payload)
+
+	_, _, err = v2DecodeMessage([]byte{0, 'v'})
+	assert.Equal(t, ErrV2InvalidMessage, err)
+}
+
+func TestV2TransportRekey(t *testing.T) {
+	var secret [32]byte
+	initiator := newV2Transport(secret, MagicMain, true)
+	responder := newV2Transport(secret, MagicMain, false)
+	assert.Equal(t, initiator.sendGarbageTerminator, responder.recvGarbageTerminator)
+	assert.Equal(t, initiator.sessionID, responder.sessionID)
+
+	buf := netpoll.NewLinkBuffer()
+	// run across several rekey intervals
+	for i := 0; i < 3*v2RekeyInterval; i++ {
+		contents := []byte{byte(i), byte(i >> 8)}
+		_, _ = buf.WriteBinary(initiator.encrypt(contents, i%7 == 0))
+		_ = buf.Flush()
+
+		ignore, received, err := responder.readPacket(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, i%7 == 0, ignore)
+		assert.Equal(t, contents, received)
+	}
+
+	// tampered packets are rejected
+	packet := initiator.encrypt([]byte{1}, false)
+	packet[len(packet)-1] ^= 1
+	_, _ = buf.WriteBinary(packet)
+	_ = buf.Flush()
+	_, _, err := responder.readPacket(buf)
+	assert.NotNil(t, err)
+}
+
+func TestV2PacketEncodingVectors(t *testing.T) {
+	for _, row := range readVectors(t, "packet_encoding_test_vectors.csv") {
+		initiating := row["in_initiating"] == "1"
+		d := new(big.Int).SetBytes(unhex(t, row["in_priv_ours"]))
+		x := feBytes(scalarMult(d, secpGx, secpGy).affineX())
+		assert.Equal(t, row["mid_x_ours"], hex.EncodeToString(x[:]))
+
+		// the vectors fix our encoding, which is random otherwise
+		key := &EllSwiftPrivateKey{d: d}
+		copy(key.encoded[:], unhex(t, row["in_ellswift_ours"]))
+		x = feBytes(ellswiftDecode(key.encoded))
+		assert.Equal(t, row["mid_x_ours"], hex.EncodeToString(x[:]))
+		var theirs [64]byte
+		copy(theirs[:], unhex(t, row["in_ellswift_theirs"]))
+		x = feBytes(ellswiftDecode(theirs))
+		assert.Equal(t, row["mid_x_theirs"], hex.EncodeToString(x[:]))
+
+		secret, err := key.ECDH(theirs, initiating)
+		assert.Nil(t, err)
+		assert.Equal(t, row["mid_shared_secret"], hex.EncodeToString(secret[:]))
+
+		transport := newV2Transport(secret, MagicMain, initiating)
+		initiatorL, responderL := transport.sendL.key, transport.recvL.key
+		initiatorP, responderP := transport.sendP.key, transport.recvP.key
+		if !initiating {
+			initiatorL, responderL = responderL, initiatorL
+			initiatorP, responderP = responderP, initiatorP
+		}
+		assert.Equal(t, row["mid_initiator_l"], hex.EncodeToString(initiatorL[:]))
+		assert.Equal(t, row["mid_initiator_p"], hex.EncodeToString(initiatorP[:]))
+		assert.Equal(t, row["mid_responder_l"], hex.EncodeToString(responderL[:]))
+		assert.Equal(t, row["mid_responder_p"], hex.EncodeToString(responderP[:]))
+		assert.Equal(t, row["mid_send_garbage_terminator"], hex.EncodeToString(transport.sendGarbageTerminator[:]))
+		assert.Equal(t, row["mid_recv_garbage_terminator"], hex.EncodeToString(transport.recvGarbageTerminator[:]))
+		assert.Equal(t, row["out_session_id"], hex.EncodeToString(transport.sessionID[:]))
+
+		// the packet of the vector follows in_idx empty packets
+		idx, err := strconv.Atoi(row["in_idx"])
+		assert.Nil(t, err)
+		for i := 0; i < idx; i++ {
+			transport.encrypt(nil, false)
+		}
+		multiply, err := strconv.Atoi(row["in_multiply"])
+		assert.Nil(t, err)
+		transport.sendGarbage = unhex(t, row["in_aad"])
+		ciphertext := hex.EncodeToString(transport.encrypt(bytes.Repeat(unhex(t, row["in_contents"]), multiply), row["in_ignore"] == "1"))
+		if want := row["out_ciphertext"]; want != "" {
+			assert.Equal(t, want, ciphertext, "vector %s", row["in_idx"])
+		}
+		if want := row["out_ciphertext_endswith"]; want != "" {
+			assert.True(t, strings.HasSuffix(ciphertext, want), "vector %s", row["in_idx"])
+		}
+	}
+}
+
+// v2Pipe returns both sides of a local tcp connection, so handshakes are not blocked by unbuffered pipes
+func v2Pipe(t *testing.T) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	assert.Nil(t, err)
+	return conn, <-accepted
+}
+
+func TestV2Handshake(t *testing.T) {
+	a, b := v2Pipe(t)
+	defer a.Close()
+	defer b.Close()
+
+	type result struct {
+		t   *v2Transport
+		err error
+	}
+	responded := make(chan result)
+	go func() {
+		rt, err := v2Handshake(netpoll.NewReader(b), netpoll.NewWriter(b), MagicMain, false)
+		responded <- result{rt, err}
+	}()
+
+	it, err := v2Handshake(netpoll.NewReader(a), netpoll.NewWriter(a), MagicMain, true)
+	assert.Nil(t, err)
+	r := <-responded
+	assert.Nil(t, r.err)
+	assert.Equal(t, it.sessionID, r.t.sessionID)
+}
+
+func TestV2HandshakeDetectsV1(t *testing.T) {
+	buf := netpoll.NewLinkBuffer()
+	_, _ = buf.WriteBinary(append(v1Prefix(MagicMain), 0x65, 0x00, 0x00, 0x00))
+	_ = buf.Flush()
+
+	_, err := v2Handshake(buf, netpoll.NewLinkBuffer(), MagicMain, false)
+	assert.Equal(t, ErrV2V1Detected, err)
+	// nothing consumed, so the v1 transport can still parse the header
+	assert.Equal(t, 20, buf.Len())
+}
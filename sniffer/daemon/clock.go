@@ -0,0 +1,145 @@
+package daemon
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// ClockSamples is the number of recent samples the offset is filtered from, the one with the
+	// lowest round trip delay is trusted the most
+	ClockSamples = 8
+	// DriftSamples is the number of filtered samples the drift of the local clock is estimated from
+	DriftSamples = 64
+	// minDriftSpan is the minimum time spanned by the filtered samples to estimate the drift
+	minDriftSpan = time.Minute
+	// maxDrift bounds the estimated drift, larger estimates come from noise rather than the clocks
+	maxDrift = 500e-6
+	// clockTolerance is the frequency error assumed after the drift is corrected, the uncertainty of the
+	// offset grows by it as the filtered sample ages
+	clockTolerance = 15e-6
+)
+
+// clockSample is an offset measured by a ping
+type clockSample struct {
+	// at is the local time the response was received
+	at time.Time
+	// offset is the master clock minus the local clock
+	offset time.Duration
+	// delay is the round trip delay excluding the processing time of the master
+	delay time.Duration
+}
+
+// newClockSample computes the offset and delay from the four timestamps of a ping as NTP does: t0 when the
+// request was sent, t1 when the master received it, t2 when the master responded and t3 when the response
+// was received, t0 and t3 are read from the local clock while t1 and t2 are read from the master clock
+func newClockSample(t0, t1, t2, t3 int64) clockSample {
+	return clockSample{
+		at:     time.Unix(0, t3),
+		offset: time.Duration(((t1 - t0) + (t2 - t3)) / 2),
+		delay:  time.Duration((t3 - t0) - (t2 - t1)),
+	}
+}
+
+// clock estimates the master clock from the local one, it filters the ping samples and corrects the drift
+// of the local clock
+type clock struct {
+	samples  []clockSample
+	filtered []clockSample
+	// drift is the rate the offset changes, in seconds per second
+	drift float64
+	mu    sync.Mutex
+}
+
+func newClock() *clock {
+	return &clock{}
+}
+
+// Add records the timestamps of a ping, samples with a negative delay are dropped as the clocks moved
+// during the ping
+func (c *clock) Add(t0, t1, t2, t3 int64) {
+	sample := newClockSample(t0, t1, t2, t3)
+	if sample.delay < 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.samples = append(c.samples, sample)
+	if len(c.samples) > ClockSamples {
+		c.samples = c.samples[len(c.samples)-ClockSamples:]
+	}
+
+	best := c.best()
+	if n := len(c.filtered); n > 0 && c.filtered[n-1].at.Equal(best.at) {
+		return
+	}
+	c.filtered = append(c.filtered, best)
+	if len(c.filtered) > DriftSamples {
+		c.filtered = c.filtered[len(c.filtered)-DriftSamples:]
+	}
+	c.estimateDrift()
+}
+
+// best returns the recent sample with the lowest delay, the lock must be held
+func (c *clock) best() clockSample {
+	best := c.samples[0]
+	for _, sample := range c.samples[1:] {
+		if sample.delay < best.delay {
+			best = sample
+		}
+	}
+	return best
+}
+
+// estimateDrift fits the filtered offsets to a line by least squares, the lock must be held. The error of an
+// offset is bounded by its delay, so the samples are weighted by the inverse square of their delays.
+func (c *clock) estimateDrift() {
+	n := len(c.filtered)
+	if n < 2 || c.filtered[n-1].at.Sub(c.filtered[0].at) < minDriftSpan {
+		c.drift = 0
+		return
+	}
+
+	var sw, sx, sy, sxx, sxy float64
+	origin := c.filtered[0].at
+	for _, sample := range c.filtered {
+		// a millisecond is added so that samples with tiny delays do not take all the weight
+		d := sample.delay.Seconds() + 1e-3
+		w := 1 / (d * d)
+		x := sample.at.Sub(origin).Seconds()
+		y := sample.offset.Seconds()
+		sw += w
+		sx += w * x
+		sy += w * y
+		sxx += w * x * x
+		sxy += w * x * y
+	}
+	c.drift = (sw*sxy - sx*sy) / (sw*sxx - sx*sx)
+	c.drift = math.Max(-maxDrift, math.Min(maxDrift, c.drift))
+}
+
+// Offset returns the offset of the master clock at the local time, and its uncertainty: half of the delay
+// of the filtered sample, which bounds the asymmetry of the paths, growing with the age of the sample
+func (c *clock) Offset(at time.Time) (offset, uncertainty time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.samples) == 0 {
+		return 0, 0
+	}
+	best := c.best()
+	age := at.Sub(best.at).Seconds()
+	offset = best.offset + time.Duration(c.drift*age*float64(time.Second))
+	uncertainty = best.delay/2 + time.Duration(clockTolerance*math.Abs(age)*float64(time.Second))
+	return
+}
+
+// Drift returns the estimated drift of the master clock from the local one, in seconds per second
+func (c *clock) Drift() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drift
+}
@@ -0,0 +1,68 @@
+package daemon
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockSample(t *testing.T) {
+	// the master is 1s ahead, the request takes 10ms, the master spends 5ms and the response takes 30ms
+	t0 := int64(0)
+	t1 := t0 + int64(time.Second+10*time.Millisecond)
+	t2 := t1 + int64(5*time.Millisecond)
+	t3 := t2 - int64(time.Second) + int64(30*time.Millisecond)
+
+	sample := newClockSample(t0, t1, t2, t3)
+	assert.Equal(t, 40*time.Millisecond, sample.delay)
+	// the asymmetry of the paths shows as an error of half their difference
+	assert.Equal(t, time.Second-10*time.Millisecond, sample.offset)
+}
+
+func TestClockFilterAndDrift(t *testing.T) {
+	const offset = 2 * time.Second
+	const drift = 20e-6
+
+	rng := rand.New(rand.NewSource(1))
+	c := newClock()
+	start := time.Unix(1700000000, 0)
+	master := func(local time.Time) int64 {
+		return local.Add(offset + time.Duration(drift*local.Sub(start).Seconds()*float64(time.Second))).UnixNano()
+	}
+
+	var now time.Time
+	for i := 0; i < 60; i++ {
+		now = start.Add(time.Duration(i) * 10 * time.Second)
+		out := time.Duration(rng.Intn(5)+1) * time.Millisecond
+		back := time.Duration(rng.Intn(5)+1) * time.Millisecond
+		// some pings are delayed on one path only, they should be filtered out
+		if i%3 == 0 {
+			out += 200 * time.Millisecond
+		}
+		t0 := now
+		t1 := master(t0.Add(out))
+		t2 := t1 + int64(time.Millisecond)
+		t3 := t0.Add(out + time.Millisecond + back)
+		c.Add(t0.UnixNano(), t1, t2, t3.UnixNano())
+	}
+
+	assert.InDelta(t, drift, c.Drift(), 5e-6)
+
+	at := now.Add(5 * time.Second)
+	expected := time.Duration(master(at) - at.UnixNano())
+	estimated, uncertainty := c.Offset(at)
+	assert.InDelta(t, float64(expected), float64(estimated), float64(5*time.Millisecond))
+	assert.True(t, uncertainty > 0 && uncertainty < 10*time.Millisecond, uncertainty)
+
+	// samples with negative delay are dropped
+	c.Add(0, 0, int64(time.Second), int64(time.Millisecond))
+	assert.Equal(t, ClockSamples, len(c.samples))
+}
+
+func TestClockEmpty(t *testing.T) {
+	offset, uncertainty := newClock().Offset(time.Now())
+	assert.Equal(t, time.Duration(0), offset)
+	assert.Equal(t, time.Duration(0), uncertainty)
+}
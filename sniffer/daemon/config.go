@@ -8,16 +8,42 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/AlaricGilbert/argos-core/protocol/bitcoin"
 )
 
+// DefaultConfigFile is the config file of a sniffer run in its working directory
+const DefaultConfigFile = "sniffer.json"
+
 type Config struct {
 	MasterAddress string `json:"master_address"`
 	Identifier    string `json:"identifier"`
+	// ListenAddress is the address accepting inbound connections from nodes, e.g. "0.0.0.0:8333",
+	// inbound connections are disabled when it is empty
+	ListenAddress string `json:"listen_address"`
+	// MaxOutbound is the number of outbound peers the sniffer keeps
+	MaxOutbound int `json:"max_outbound"`
+	// MaxInbound is the number of inbound peers the sniffer accepts, the connections past it are closed at once
+	MaxInbound int `json:"max_inbound"`
+	// Bitcoin controls the bitcoin peers, e.g. what we advertise in version messages
+	Bitcoin bitcoin.Options `json:"bitcoin"`
+	// HMACKey is the key shared with the master to sign our requests and check its answers, it should equal
+	// the HMACKey of the master config. The messages are authenticated but not encrypted.
+	HMACKey string `json:"hmac_key"`
+	// RawObservations streams every announcement of every peer to the master, so the announcements can
+	// be analyzed offline by other estimators
+	RawObservations bool `json:"raw_observations"`
+	// NotifyRetention is the seconds the announcements of a transaction are kept after it was first seen,
+	// DefaultNotifyRetention is used when it is not positive
+	NotifyRetention int `json:"notify_retention"`
+	// MetricsListenAddress serves the metrics of the sniffer in the Prometheus text format at /metrics,
+	// e.g. "127.0.0.1:9333", the metrics are not served when it is empty
+	MetricsListenAddress string `json:"metrics_listen_address"`
 }
 
 func randIdentifier() string {
 	m := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-"
-	rng := rand.New(rand.NewSource(time.Now().Unix()))
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 	l := len(m)
 
 	randRune := func() byte {
@@ -32,65 +58,75 @@ func randIdentifier() string {
 	return b.String()
 }
 
-func newDefaultConfig() *Config {
+// renewIdentifier replaces the random suffix of the identifier and keeps its task prefix
+func renewIdentifier(identifier string) string {
+	if prefix, _, ok := strings.Cut(identifier, "-"); ok {
+		return prefix + "-" + randIdentifier()
+	}
+	return randIdentifier()
+}
+
+// DefaultConfig returns the config of a new sniffer with a random identifier
+func DefaultConfig() *Config {
 	return &Config{
 		MasterAddress: "127.0.0.1:4222",
 		Identifier:    randIdentifier(),
+		MaxOutbound:   DefaultMaxOutbound,
+		MaxInbound:    DefaultMaxInbound,
+		Bitcoin:       bitcoin.DefaultOptions(),
 	}
 }
 
-func (d *SnifferDaemon) ReadConfig() error {
-	var err error
-	defer d.logger.WithError(err).WithField("config", d.config).Warn("read config exited")
-	var bytes []byte
-	var cfgFile = "sniffer.json"
-	var f *os.File
-
-	d.config = newDefaultConfig()
+// LoadConfig reads the config file over the default config, exist is false when the file does not exist
+// and the default config is returned
+func LoadConfig(path string) (config *Config, exist bool, err error) {
+	config = DefaultConfig()
 
-	if _, err = os.Stat(cfgFile); errors.Is(err, os.ErrNotExist) {
-		d.SaveConfig()
-		return nil
+	data, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, false, nil
+	} else if err != nil {
+		return nil, false, err
 	}
 
-	if f, err = os.OpenFile(cfgFile, os.O_RDONLY, 0); err != nil {
-		return err
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, true, err
 	}
+	return config, true, nil
+}
 
-	if bytes, err = ioutil.ReadAll(f); err != nil {
+// Save writes the config to the file, replacing its content
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
 		return err
 	}
+	return ioutil.WriteFile(path, data, 0644)
+}
 
-	if err = json.Unmarshal(bytes, d.config); err != nil {
+// ReadConfig loads the config file of the daemon, the default config is saved when the file does not exist
+func (d *SnifferDaemon) ReadConfig() error {
+	config, exist, err := LoadConfig(d.configFile)
+	if err != nil {
 		return err
 	}
-
-	// if config.Identifier empty, generate a new one
-	if d.config.Identifier == "" {
-		d.config.Identifier = randIdentifier()
-		d.SaveConfig()
+	d.config = config
+
+	// if the file or config.Identifier is missing, save the config with a new identifier
+	if !exist || d.config.Identifier == "" {
+		if d.config.Identifier == "" {
+			d.config.Identifier = randIdentifier()
+		}
+		_ = d.SaveConfig()
 	}
-
 	return nil
 }
 
+// SaveConfig writes the config of the daemon to its config file
 func (d *SnifferDaemon) SaveConfig() error {
-	var bytes []byte
-	var err error
-	var cfgFile = "sniffer.json"
-	var f *os.File
-	if bytes, err = json.MarshalIndent(d.config, "", "    "); err != nil {
-		d.logger.WithError(err).Warn("marshal config failed")
-		return err
-	}
-
-	if f, err = os.OpenFile(cfgFile, os.O_CREATE|os.O_RDWR, os.ModePerm); err != nil {
-		d.logger.WithError(err).Warn("create config and open failed")
-		return err
-	}
-
-	if _, err = f.Write(bytes); err != nil {
+	if err := d.config.Save(d.configFile); err != nil {
 		d.logger.WithError(err).Warn("write config failed")
+		return err
 	}
 	return nil
 }
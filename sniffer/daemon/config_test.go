@@ -1,9 +1,39 @@
 package daemon
 
 import (
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestRandIdentifier(t *testing.T) {
 	t.Log(randIdentifier())
 }
+
+func TestRenewIdentifier(t *testing.T) {
+	renewed := renewIdentifier("hubei-SIp7m1Lkc4")
+	if !strings.HasPrefix(renewed, "hubei-") || renewed == "hubei-SIp7m1Lkc4" {
+		t.Errorf("renewed identifier %s should keep the prefix and change the suffix", renewed)
+	}
+	if renewIdentifier("nosuffix") == "nosuffix" {
+		t.Error("identifier without prefix should be renewed")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DefaultConfigFile)
+
+	config, exist, err := LoadConfig(path)
+	if err != nil || exist || config.MaxOutbound != DefaultMaxOutbound || config.MaxInbound != DefaultMaxInbound {
+		t.Fatalf("missing config should load the default config, got %+v %v %v", config, exist, err)
+	}
+
+	config.MasterAddress = "10.0.0.1:4222"
+	if err = config.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, exist, err := LoadConfig(path)
+	if err != nil || !exist || loaded.MasterAddress != "10.0.0.1:4222" || loaded.Identifier != config.Identifier {
+		t.Errorf("saved config should be loaded, got %+v %v %v", loaded, exist, err)
+	}
+}
@@ -0,0 +1,344 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultMaxOutbound is the number of outbound peers the sniffer keeps when it is not configured
+	DefaultMaxOutbound = 64
+	// DefaultMaxInbound is the number of inbound peers the sniffer accepts when it is not configured
+	DefaultMaxInbound = 125
+	// MaxNewAddresses bounds the addresses which are learned but never connected successfully
+	MaxNewAddresses = 4096
+	// MaxTriedAddresses bounds the addresses which have been connected successfully
+	MaxTriedAddresses = 1024
+	// MaxAttempts is the number of consecutive failures after which a new address is forgotten
+	MaxAttempts = 8
+	// BaseBackoff is the delay before retrying an address after its first failure, it doubles on each further failure
+	BaseBackoff = 30 * time.Second
+	// MaxBackoff caps the delay before retrying an address
+	MaxBackoff = 2 * time.Hour
+	// EvictionInterval is how often the sniffer evicts its least valuable outbound peer when it is full
+	EvictionInterval = 10 * time.Minute
+	// EvictionGracePeriod protects newly connected peers from being evicted before they could announce anything
+	EvictionGracePeriod = 5 * time.Minute
+	// AddrBookFile is the file persisting the address book, it is placed next to the config file
+	AddrBookFile = "addrbook.jsonl"
+	// AddrBookSaveInterval is how often the address book is persisted
+	AddrBookSaveInterval = 5 * time.Minute
+	// maintainInterval is how often the sniffer tops up its outbound peers
+	maintainInterval = time.Second
+)
+
+const (
+	// SourceSeed marks the addresses provided by seeds
+	SourceSeed = "seed"
+)
+
+// addrInfo is the connection history of an address
+type addrInfo struct {
+	address     net.TCPAddr
+	services    uint64
+	source      string
+	attempts    int
+	lastAttempt time.Time
+	lastSuccess time.Time
+	retryAt     time.Time
+}
+
+// addrBook remembers the addresses of nodes, it keeps the addresses we never connected successfully in the
+// new bucket and the others in the tried bucket, both buckets are bounded
+type addrBook struct {
+	new   map[addr]*addrInfo
+	tried map[addr]*addrInfo
+	rng   *rand.Rand
+}
+
+func newAddrBook() *addrBook {
+	return &addrBook{
+		new:   make(map[addr]*addrInfo),
+		tried: make(map[addr]*addrInfo),
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// backoff returns the delay before retrying an address failed the given times consecutively
+func backoff(attempts int) time.Duration {
+	d := BaseBackoff
+	for i := 1; i < attempts && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	return d
+}
+
+// Len returns the number of addresses in the new and tried buckets
+func (b *addrBook) Len() (int, int) {
+	return len(b.new), len(b.tried)
+}
+
+func (b *addrBook) lookup(key addr) *addrInfo {
+	if info, ok := b.tried[key]; ok {
+		return info
+	}
+	return b.new[key]
+}
+
+// Add records an address learned from seeds or addr messages, the source is the seed or the node announcing it.
+// Only the services of known addresses are updated.
+func (b *addrBook) Add(address net.TCPAddr, services uint64, source string) {
+	key := newAddr(address)
+	if info := b.lookup(key); info != nil {
+		if services != 0 {
+			info.services = services
+		}
+		return
+	}
+	if len(b.new) >= MaxNewAddresses {
+		b.evictNew()
+	}
+	b.new[key] = &addrInfo{
+		address:  address,
+		services: services,
+		source:   source,
+	}
+}
+
+// Attempt records a connection attempt to the address
+func (b *addrBook) Attempt(address net.TCPAddr, now time.Time) {
+	b.Add(address, 0, "")
+	b.lookup(newAddr(address)).lastAttempt = now
+}
+
+// Good moves the address into the tried bucket after the remote turned out to be a working node
+func (b *addrBook) Good(address net.TCPAddr, now time.Time) {
+	key := newAddr(address)
+	info, ok := b.tried[key]
+	if !ok {
+		if info, ok = b.new[key]; ok {
+			delete(b.new, key)
+		} else {
+			info = &addrInfo{address: address}
+		}
+		if len(b.tried) >= MaxTriedAddresses {
+			b.evictTried()
+		}
+		b.tried[key] = info
+	}
+	info.attempts = 0
+	info.lastSuccess = now
+	info.retryAt = time.Time{}
+}
+
+// Failed schedules the next attempt of the address with exponential backoff,
+// new addresses failing MaxAttempts times in a row are forgotten
+func (b *addrBook) Failed(address net.TCPAddr, now time.Time) {
+	key := newAddr(address)
+	info := b.lookup(key)
+	if info == nil {
+		return
+	}
+	info.attempts++
+	if _, ok := b.new[key]; ok && info.attempts >= MaxAttempts {
+		delete(b.new, key)
+		return
+	}
+	info.retryAt = now.Add(backoff(info.attempts))
+}
+
+// Defer keeps the address from being selected until the given time
+func (b *addrBook) Defer(address net.TCPAddr, until time.Time) {
+	if info := b.lookup(newAddr(address)); info != nil && info.retryAt.Before(until) {
+		info.retryAt = until
+	}
+}
+
+// Select picks a random address which could be attempted at the given time and is not excluded,
+// tried addresses are preferred half of the time
+func (b *addrBook) Select(now time.Time, exclude func(addr) bool) (net.TCPAddr, bool) {
+	var buckets = []map[addr]*addrInfo{b.tried, b.new}
+	if b.rng.Intn(2) == 0 {
+		buckets[0], buckets[1] = buckets[1], buckets[0]
+	}
+
+	for _, bucket := range buckets {
+		var candidates []*addrInfo
+		for key, info := range bucket {
+			if info.retryAt.After(now) || exclude(key) {
+				continue
+			}
+			candidates = append(candidates, info)
+		}
+		if len(candidates) > 0 {
+			return candidates[b.rng.Intn(len(candidates))].address, true
+		}
+	}
+	return net.TCPAddr{}, false
+}
+
+// evictNew forgets the new address which failed the most times
+func (b *addrBook) evictNew() {
+	var worst *addr
+	var attempts = -1
+	for key, info := range b.new {
+		if info.attempts > attempts {
+			k := key
+			worst = &k
+			attempts = info.attempts
+		}
+	}
+	if worst != nil {
+		delete(b.new, *worst)
+	}
+}
+
+// evictTried moves the tried address which succeeded least recently back into the new bucket
+func (b *addrBook) evictTried() {
+	var oldest *addr
+	var ts time.Time
+	for key, info := range b.tried {
+		if oldest == nil || info.lastSuccess.Before(ts) {
+			k := key
+			oldest = &k
+			ts = info.lastSuccess
+		}
+	}
+	if oldest == nil {
+		return
+	}
+	info := b.tried[*oldest]
+	delete(b.tried, *oldest)
+	if len(b.new) >= MaxNewAddresses {
+		b.evictNew()
+	}
+	b.new[*oldest] = info
+}
+
+// addrRecord is a line of the persisted address book
+type addrRecord struct {
+	Address     string    `json:"address"`
+	Services    uint64    `json:"services"`
+	Source      string    `json:"source"`
+	Tried       bool      `json:"tried"`
+	Attempts    int       `json:"attempts"`
+	LastAttempt time.Time `json:"last_attempt"`
+	LastSuccess time.Time `json:"last_success"`
+}
+
+// Records returns the addresses in the book in the persisted form
+func (b *addrBook) Records() []addrRecord {
+	records := make([]addrRecord, 0, len(b.new)+len(b.tried))
+	for tried, bucket := range map[bool]map[addr]*addrInfo{true: b.tried, false: b.new} {
+		for _, info := range bucket {
+			records = append(records, addrRecord{
+				Address:     info.address.String(),
+				Services:    info.services,
+				Source:      info.source,
+				Tried:       tried,
+				Attempts:    info.attempts,
+				LastAttempt: info.lastAttempt,
+				LastSuccess: info.lastSuccess,
+			})
+		}
+	}
+	return records
+}
+
+// Restore adds the persisted addresses into the book, the backoff of failed addresses is continued
+// and the addresses already in the book are left untouched
+func (b *addrBook) Restore(records []addrRecord) int {
+	var restored = 0
+	for _, record := range records {
+		ap, err := netip.ParseAddrPort(record.Address)
+		if err != nil {
+			continue
+		}
+		address := *net.TCPAddrFromAddrPort(ap)
+		key := newAddr(address)
+		if b.lookup(key) != nil {
+			continue
+		}
+
+		info := &addrInfo{
+			address:     address,
+			services:    record.Services,
+			source:      record.Source,
+			attempts:    record.Attempts,
+			lastAttempt: record.LastAttempt,
+			lastSuccess: record.LastSuccess,
+		}
+		if info.attempts > 0 {
+			info.retryAt = info.lastAttempt.Add(backoff(info.attempts))
+		}
+
+		switch {
+		case record.Tried && len(b.tried) < MaxTriedAddresses:
+			b.tried[key] = info
+		case len(b.new) < MaxNewAddresses:
+			b.new[key] = info
+		default:
+			continue
+		}
+		restored++
+	}
+	return restored
+}
+
+// saveAddrRecords writes the records as json lines, the file is replaced atomically
+func saveAddrRecords(path string, records []addrRecord) error {
+	var err error
+	var f *os.File
+	var tmp = path + ".tmp"
+
+	if f, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644); err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range records {
+		if err = enc.Encode(&records[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadAddrRecords reads the records written by saveAddrRecords, malformed lines are skipped
+// and a missing file is treated as an empty address book
+func loadAddrRecords(path string) ([]addrRecord, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []addrRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record addrRecord
+		if json.Unmarshal(scanner.Bytes(), &record) == nil {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,124 @@
+package daemon
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, BaseBackoff, backoff(1))
+	assert.Equal(t, 2*BaseBackoff, backoff(2))
+	assert.Equal(t, 4*BaseBackoff, backoff(3))
+	assert.Equal(t, MaxBackoff, backoff(100))
+}
+
+func TestAddrBook(t *testing.T) {
+	var now = time.Now()
+	var a = net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8333}
+	var none = func(addr) bool { return false }
+
+	book := newAddrBook()
+	book.Add(a, 0, SourceSeed)
+	book.Add(a, 0, SourceSeed)
+	n, tried := book.Len()
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 0, tried)
+
+	selected, ok := book.Select(now, none)
+	assert.True(t, ok)
+	assert.Equal(t, a.String(), selected.String())
+
+	// excluded addresses are never selected
+	_, ok = book.Select(now, func(addr) bool { return true })
+	assert.False(t, ok)
+
+	// failed addresses are not selected until the backoff expires
+	book.Attempt(a, now)
+	book.Failed(a, now)
+	_, ok = book.Select(now, none)
+	assert.False(t, ok)
+	_, ok = book.Select(now.Add(BaseBackoff), none)
+	assert.True(t, ok)
+
+	// good addresses move into the tried bucket and are retried immediately
+	book.Good(a, now)
+	n, tried = book.Len()
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, tried)
+	_, ok = book.Select(now, none)
+	assert.True(t, ok)
+
+	// tried addresses are never forgotten by failures
+	for i := 0; i < MaxAttempts*2; i++ {
+		book.Failed(a, now)
+	}
+	_, tried = book.Len()
+	assert.Equal(t, 1, tried)
+
+	// new addresses are forgotten after failing too many times
+	var b = net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 8333}
+	book.Add(b, 0, SourceSeed)
+	for i := 0; i < MaxAttempts; i++ {
+		book.Failed(b, now)
+	}
+	n, _ = book.Len()
+	assert.Equal(t, 0, n)
+}
+
+func TestAddrBookBounded(t *testing.T) {
+	var now = time.Now()
+	book := newAddrBook()
+	for i := 0; i < MaxNewAddresses+MaxTriedAddresses+10; i++ {
+		address := net.TCPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 8333}
+		book.Add(address, 0, SourceSeed)
+		if i%2 == 0 {
+			book.Good(address, now.Add(time.Duration(i)*time.Second))
+		}
+	}
+	n, tried := book.Len()
+	assert.Equal(t, MaxNewAddresses, n)
+	assert.Equal(t, MaxTriedAddresses, tried)
+}
+
+func TestAddrBookPersist(t *testing.T) {
+	var now = time.Now().Truncate(time.Second)
+	var a = net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8333}
+	var b = net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 8333}
+	var path = filepath.Join(t.TempDir(), AddrBookFile)
+
+	book := newAddrBook()
+	book.Add(a, 0x409, SourceSeed)
+	book.Good(a, now)
+	book.Add(b, 0x8, a.String())
+	book.Attempt(b, now)
+	book.Failed(b, now)
+	assert.Nil(t, saveAddrRecords(path, book.Records()))
+
+	records, err := loadAddrRecords(path)
+	assert.Nil(t, err)
+	restored := newAddrBook()
+	assert.Equal(t, 2, restored.Restore(records))
+	n, tried := restored.Len()
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 1, tried)
+
+	ia := restored.tried[newAddr(a)]
+	assert.Equal(t, uint64(0x409), ia.services)
+	assert.Equal(t, SourceSeed, ia.source)
+	assert.True(t, now.Equal(ia.lastSuccess))
+
+	// the backoff of the failed address continues after restoring
+	ib := restored.new[newAddr(b)]
+	assert.Equal(t, a.String(), ib.source)
+	assert.Equal(t, 1, ib.attempts)
+	assert.True(t, now.Add(BaseBackoff).Equal(ib.retryAt))
+
+	// a missing file is an empty address book
+	records, err = loadAddrRecords(filepath.Join(t.TempDir(), AddrBookFile))
+	assert.Nil(t, err)
+	assert.Empty(t, records)
+}
@@ -2,29 +2,69 @@ package daemon
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/AlaricGilbert/argos-core/master/auth"
 	"github.com/AlaricGilbert/argos-core/master/kitex_gen/base"
 	"github.com/AlaricGilbert/argos-core/master/kitex_gen/master"
 	am "github.com/AlaricGilbert/argos-core/master/kitex_gen/master/argosmaster"
 	"github.com/AlaricGilbert/argos-core/protocol/bitcoin"
 	"github.com/apache/thrift/lib/go/thrift"
 	"github.com/cloudwego/kitex/client"
-	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
 )
 
+const (
+	// ExitOK means the daemon exited by a signal after shutting down gracefully
+	ExitOK = 0
+	// ExitMasterUnavailable means the daemon exited because the master could not be reached
+	ExitMasterUnavailable = 2
+	// ExitSnifferFailed means the sniffer stopped by itself, e.g. it had no node to connect
+	ExitSnifferFailed = 3
+)
+
+const (
+	// SessionFile keeps the session issued by the master, so the sniffer restarted in time is not taken
+	// as another sniffer holding its identifier
+	SessionFile = "sniffer.session"
+	// maxIdentifierConflicts is the number of identifiers tried when registering to the master
+	maxIdentifierConflicts = 3
+)
+
 type SnifferDaemon struct {
-	logger    *logrus.Logger
-	sniffer   argos.Sniffer
-	master    am.Client
-	protocol  string
-	timeDelta int64
-	config    *Config
+	logger   *logrus.Logger
+	sniffer  *Sniffer
+	master   am.Client
+	reporter *reporter
+	// observer sends the raw observations, nil when the raw observation mode is disabled
+	observer *observer
+	// metrics serves the metrics, nil when the metrics listen address is not configured
+	metrics *http.Server
+	// sniffer, protocol and task are replaced by the ping loop and read by the other goroutines under mu
+	protocol string
+	// task is the last task applied, nil when the master never pushed one
+	task   *master.TaskConfig
+	clock  *clock
+	config *Config
+	// configFile is the path of the config, the state files of the sniffer are kept in its directory
+	configFile string
+	// protocolOverride overrides the protocol assigned by the master when it is not empty
+	protocolOverride string
+	session          string
+	started          time.Time
+	exit             chan int
+	closing          chan struct{}
+	mu               sync.Mutex
 }
 
 var instance *SnifferDaemon
@@ -33,45 +73,368 @@ var instance *SnifferDaemon
 // and to get the task provided by the master
 func (d *SnifferDaemon) ping() {
 	var errTimes = 0
+	var ticker = time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
 	for {
 		var req *master.PingRequest
 		var resp *master.PingResponse
 		var err error
-		time.Sleep(time.Second * 10)
 
-		// when errTimes > 10, we think the connection to the master is broken, so we will exit the loop
-		if errTimes > 10 {
-			d.logger.Fatal("ping failed 10 times, argos master is not available")
+		select {
+		case <-d.closing:
+			return
+		case <-ticker.C:
 		}
 
-		req = &master.PingRequest{
-			Identifier: d.config.Identifier,
-			Timestamp:  time.Now().UnixNano(),
-			DeltaTime:  thrift.Int64Ptr(d.timeDelta),
+		// when errTimes > 10, we think the connection to the master is broken, so we will shut down the daemon
+		if errTimes > 10 {
+			d.logger.Error("ping failed 10 times, argos master is not available")
+			d.stop(ExitMasterUnavailable)
+			return
 		}
 
-		if resp, err = d.master.Ping(context.Background(), req); err != nil {
+		req = d.pingRequest()
+		resp, err = d.master.Ping(context.Background(), req)
+		received := time.Now().UnixNano()
+		if err != nil {
 			d.logger.WithError(err).Error("argos sniffer ping failed")
 			errTimes++
 			continue
 		}
 
-		if resp == nil || resp.Status.Code != 0 {
-			d.logger.WithField("status", resp.Status).Fatal("argos sniffer ping failed")
+		// the response carries the task, so it is only trusted when signed by the master
+		if d.config.HMACKey != "" && resp != nil {
+			if err = auth.VerifyResponse([]byte(d.config.HMACKey), resp, resp.GetAuth(), req.GetAuth()); err != nil {
+				d.logger.WithError(err).WithField("status", resp.GetStatus()).Error("argos sniffer ping response not authenticated")
+				errTimes++
+				continue
+			}
+		}
+
+		// another sniffer took our identifier while we were not able to ping
+		if resp != nil && resp.Status != nil && resp.Status.Code == base.StatusIdentifierConflict {
+			d.resolveConflict()
+			continue
+		}
+
+		if resp == nil || resp.Status == nil || resp.Status.Code != 0 {
+			d.logger.WithField("status", resp.GetStatus()).Error("argos sniffer ping failed")
 			errTimes++
 			continue
 		}
+		errTimes = 0
+		d.setSession(resp.GetSession())
 
 		// if the master is available, we will sync the time with master
-		d.timeDelta = (resp.GetTimeSync().RecvTimestamp - resp.GetTimeSync().SendTimestamp) / 2
+		d.syncClock(req, resp, received)
+
+		// apply the task in process when the master changed it, masters pushing no task only change the protocol
+		if task := resp.GetTask(); task != nil {
+			if current := d.currentTask(); current == nil || current.GetRevision() != task.GetRevision() {
+				d.applyTask(task)
+			}
+		} else if protocol := d.taskProtocol(resp.GetProtocol()); d.currentProtocol() != protocol {
+			d.switchProtocol(protocol)
+		}
+	}
+}
+
+// syncClock adds the timestamps of a ping answered at received to the clock
+func (d *SnifferDaemon) syncClock(req *master.PingRequest, resp *master.PingResponse, received int64) {
+	ts := resp.GetTimeSync()
+	if ts == nil {
+		return
+	}
+	// masters before nanosecond response timestamps send seconds, their processing time is taken as zero
+	responded := ts.RespTimestamp
+	if responded < ts.RecvTimestamp {
+		responded = ts.RecvTimestamp
+	}
+	d.clock.Add(req.Timestamp, ts.RecvTimestamp, responded, received)
+}
+
+// pingRequest builds the heartbeat sent to the master, it carries the status of the sniffer
+func (d *SnifferDaemon) pingRequest() *master.PingRequest {
+	var outbound, inbound int
+	d.mu.Lock()
+	s := d.sniffer
+	d.mu.Unlock()
+	// the first ping is sent before the sniffer is created
+	if s != nil {
+		outbound, inbound = s.PeerCount()
+	}
+	offset, _ := d.clock.Offset(time.Now())
+	req := &master.PingRequest{
+		Identifier:    d.identifier(),
+		Timestamp:     time.Now().UnixNano(),
+		DeltaTime:     thrift.Int64Ptr(int64(offset)),
+		OutboundPeers: thrift.Int32Ptr(int32(outbound)),
+		InboundPeers:  thrift.Int32Ptr(int32(inbound)),
+		Uptime:        thrift.Int64Ptr(int64(time.Since(d.started).Seconds())),
+		Build:         thrift.StringPtr(argos.Version()),
+	}
+	if d.session != "" {
+		req.Session = thrift.StringPtr(d.session)
+	}
+	if d.config.HMACKey != "" {
+		req.Auth = &base.Auth{}
+		if err := auth.Sign([]byte(d.config.HMACKey), req, req.Auth); err != nil {
+			d.logger.WithError(err).Error("sign ping failed")
+		}
+	}
+	return req
+}
+
+// identifier returns the identifier of the sniffer, it is renewed when it conflicts with another sniffer
+func (d *SnifferDaemon) identifier() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.config.Identifier
+}
+
+// resolveConflict renews the identifier suffix after the master found another live sniffer holding the
+// identifier, the prefix is kept so the sniffer keeps its task
+func (d *SnifferDaemon) resolveConflict() {
+	d.mu.Lock()
+	old := d.config.Identifier
+	d.config.Identifier = renewIdentifier(old)
+	identifier := d.config.Identifier
+	d.mu.Unlock()
+
+	d.logger.WithFields(logrus.Fields{
+		"old": old,
+		"new": identifier,
+	}).Warn("identifier held by another live sniffer, identifier renewed")
+	d.setSession("")
+	_ = d.SaveConfig()
+}
+
+// currentSession returns the session issued by the master, the reports are sent with it
+func (d *SnifferDaemon) currentSession() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.session
+}
+
+// currentProtocol returns the protocol sniffed, it changes when the master switches the protocol
+func (d *SnifferDaemon) currentProtocol() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.protocol
+}
+
+// setSession keeps the session issued by the master and persists it
+func (d *SnifferDaemon) setSession(session string) {
+	d.mu.Lock()
+	changed := session != d.session
+	d.session = session
+	d.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	var err error
+	if session == "" {
+		err = os.Remove(d.path(SessionFile))
+	} else {
+		err = os.WriteFile(d.path(SessionFile), []byte(session), 0600)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		d.logger.WithError(err).Warn("save session failed")
+	}
+}
+
+// loadSession reads the session persisted by the last run
+func (d *SnifferDaemon) loadSession() {
+	if data, err := os.ReadFile(d.path(SessionFile)); err == nil {
+		d.session = strings.TrimSpace(string(data))
+	} else if !os.IsNotExist(err) {
+		d.logger.WithError(err).Warn("load session failed")
+	}
+}
+
+// path returns the path of the state file of the sniffer, which is next to the config file
+func (d *SnifferDaemon) path(name string) string {
+	return filepath.Join(filepath.Dir(d.configFile), name)
+}
+
+// taskProtocol returns the protocol to sniff when the master assigns the protocol, the overridden one
+// is kept regardless of the master
+func (d *SnifferDaemon) taskProtocol(protocol string) string {
+	if d.protocolOverride != "" {
+		return d.protocolOverride
+	}
+	return protocol
+}
 
-		// check the if the protocol has changed, if so, we will exit the sniffer and restart it
-		// it should be noticed that restart should be done in some shell scripts, not in the sniffer codes
-		if d.protocol != resp.GetProtocol() {
-			d.logger.WithField("protocol", resp.GetProtocol()).Info("protocol changed, restarting sniffer")
-			os.Exit(0)
+// supportedProtocol checks whether a peer implementation of the protocol is registered
+func supportedProtocol(protocol string) bool {
+	for _, p := range argos.GetSupportedProtocols() {
+		if p == protocol {
+			return true
 		}
 	}
+	return false
+}
+
+// stop makes Spin shut down the daemon with the exit code, only the first code is kept
+func (d *SnifferDaemon) stop(code int) {
+	select {
+	case d.exit <- code:
+	default:
+	}
+}
+
+// switchProtocol replaces the sniffer with a new one sniffing the protocol, the old sniffer is halted
+// and run picks up the new one
+func (d *SnifferDaemon) switchProtocol(protocol string) {
+	d.logger.WithField("protocol", protocol).Info("protocol changed, switching sniffer")
+
+	d.mu.Lock()
+	old := d.sniffer
+	d.protocol = protocol
+	d.sniffer = d.newSniffer(protocol, d.task)
+	d.mu.Unlock()
+
+	old.Halt()
+}
+
+// taskOptions overrides the local bitcoin options by the task, the fields unset in the task keep the local ones
+func taskOptions(local bitcoin.Options, task *master.TaskConfig) bitcoin.Options {
+	o := local
+	if task.GetNetwork() != "" {
+		o.Network = task.GetNetwork()
+	}
+	if task.IsSetFeeFilter() {
+		o.FeeFilter = task.GetFeeFilter()
+	}
+	if task.IsSetFetchTransactions() {
+		o.FetchTransactions = task.GetFetchTransactions()
+	}
+	if len(task.GetSeeds()) > 0 {
+		o.Seeds = task.GetSeeds()
+	}
+	return o
+}
+
+// setTaskOptions applies the bitcoin options of the task, the local options are kept when the task is invalid
+func (d *SnifferDaemon) setTaskOptions(task *master.TaskConfig) {
+	if err := bitcoin.SetOptions(taskOptions(d.config.Bitcoin, task)); err != nil {
+		d.logger.WithError(err).WithField("network", task.GetNetwork()).Error("task bitcoin options invalid, local options kept")
+		_ = bitcoin.SetOptions(d.config.Bitcoin)
+	}
+}
+
+// newSniffer creates a sniffer of the protocol configured by the task, the task may be nil
+func (d *SnifferDaemon) newSniffer(protocol string, task *master.TaskConfig) *Sniffer {
+	s := NewSniffer(d.logger, protocol, d.config.MaxOutbound)
+	s.addrBookFile = d.path(AddrBookFile)
+	s.observing = d.observer != nil
+	if d.config.MaxInbound > 0 {
+		s.maxInbound = d.config.MaxInbound
+	}
+	if d.config.NotifyRetention > 0 {
+		s.notifies.retention = time.Duration(d.config.NotifyRetention) * time.Second
+	}
+	if task != nil {
+		d.configure(s, task)
+	}
+	return s
+}
+
+// configure applies the sniffer settings of the task to the sniffer
+func (d *SnifferDaemon) configure(s *Sniffer, task *master.TaskConfig) {
+	maxOutbound := d.config.MaxOutbound
+	if task.GetMaxPeers() > 0 {
+		maxOutbound = int(task.GetMaxPeers())
+	}
+	s.Configure(task.GetEstimators(), task.GetThresholds(), maxOutbound)
+}
+
+// currentTask returns the task applied last, nil when the master pushed none
+func (d *SnifferDaemon) currentTask() *master.TaskConfig {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.task
+}
+
+// applyTask applies the task pushed by the master, the sniffer is replaced when the protocol or the network
+// changed, otherwise it is reconfigured in place
+func (d *SnifferDaemon) applyTask(task *master.TaskConfig) {
+	d.logger.WithField("task", task).Info("task changed, applying")
+
+	network := bitcoin.GetOptions().Network
+	d.setTaskOptions(task)
+	d.mu.Lock()
+	d.task = task
+	d.mu.Unlock()
+
+	if protocol := d.taskProtocol(task.GetProtocol()); d.currentProtocol() != protocol || network != bitcoin.GetOptions().Network {
+		d.switchProtocol(protocol)
+		return
+	}
+
+	d.mu.Lock()
+	s := d.sniffer
+	d.mu.Unlock()
+	d.configure(s, task)
+	if len(task.GetSeeds()) > 0 {
+		go s.Reseed()
+	}
+}
+
+// run spins the current sniffer, and the next one after the protocol switched, until the daemon is closing
+func (d *SnifferDaemon) run() {
+	for {
+		s := d.GetSniffer()
+
+		// accept inbound connections when a listen address is configured
+		if d.config.ListenAddress != "" {
+			go func() {
+				if err := s.Listen(d.config.ListenAddress); err != nil {
+					d.logger.WithError(err).Error("argos sniffer listen failed")
+				}
+			}()
+		}
+
+		s.Spin(net.TCPAddr{})
+
+		select {
+		case <-d.closing:
+			return
+		default:
+		}
+		if s == d.GetSniffer() {
+			d.logger.Error("argos sniffer stopped unexpectedly")
+			d.stop(ExitSnifferFailed)
+			return
+		}
+	}
+}
+
+// shutdown halts the sniffer, flushes the queued reports and persists the ones not sent, then returns the exit code
+func (d *SnifferDaemon) shutdown(code int) int {
+	d.logger.WithField("code", code).Info("argos sniffer daemon shutting down")
+	close(d.closing)
+
+	// the address book is persisted when the sniffer is halted
+	d.GetSniffer().Halt()
+
+	if journaled := d.reporter.Close(ReportFlushTimeout); journaled > 0 {
+		d.logger.WithField("reports", journaled).Warn("unsent reports left in journal")
+	}
+	if d.observer != nil {
+		if unsent := d.observer.Close(ReportFlushTimeout); unsent > 0 {
+			d.logger.WithField("observations", unsent).Warn("unsent observations dropped")
+		}
+	}
+
+	if d.metrics != nil {
+		_ = d.metrics.Close()
+	}
+
+	d.logger.Info("argos sniffer daemon exited")
+	return code
 }
 
 // GetLogger returns the logger of the daemon
@@ -81,6 +444,8 @@ func (d *SnifferDaemon) GetLogger() *logrus.Logger {
 
 // GetSniffer returns the sniffer of the daemon
 func (d *SnifferDaemon) GetSniffer() argos.Sniffer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.sniffer
 }
 
@@ -98,40 +463,140 @@ func (d *SnifferDaemon) GetNodes() []net.TCPAddr {
 	return nil
 }
 
-// Spin runs the ping and sniffer loop
-func (d *SnifferDaemon) Spin() {
-	// start the ping loop
+// Spin runs the ping, report and sniffer loops until SIGINT or SIGTERM is received or the daemon fails,
+// then shuts down gracefully and returns the exit code
+func (d *SnifferDaemon) Spin() int {
+	var code int
+	var signals = make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	d.metrics = d.serveMetrics()
+
+	// start the ping and report loop
 	go d.ping()
+	go d.reporter.Run()
+	if d.observer != nil {
+		go d.observer.Run()
+	}
 
-	// get the init node
+	// start the sniffer loop
+	go d.run()
 
-	if addr, err := argos.GetRandomRemoteAddress(d.protocol); err != nil {
-		d.logger.WithError(err).Fatal("get seed nodes failed")
-		os.Exit(-1)
-	} else {
-		// start the sniffer loop
-		d.sniffer.Spin(*addr)
+	select {
+	case sig := <-signals:
+		d.logger.WithField("signal", sig).Info("argos sniffer daemon received signal")
+		code = ExitOK
+	case code = <-d.exit:
 	}
+	return d.shutdown(code)
 }
 
-func Init() {
+// sendReport sends a batch of reports to the master and returns the number of reports handled by the master,
+// reports rejected by the master are dropped instead of being retried
+func (d *SnifferDaemon) sendReport(reqs []*master.ReportRequest) (int, error) {
+	req := &master.ReportBatchRequest{
+		Identifier: d.identifier(),
+		Reports:    reqs,
+		Session:    thrift.StringPtr(d.currentSession()),
+	}
+	if d.config.HMACKey != "" {
+		req.Auth = &base.Auth{}
+		if err := auth.Sign([]byte(d.config.HMACKey), req, req.Auth); err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := d.master.ReportBatch(context.Background(), req)
+	if err != nil {
+		return 0, err
+	}
+	// unauthenticated reports are kept, they are sent once the auth key is fixed, and the reports sent before the
+	// first ping issued the session are sent again once it did
+	if resp.Status != nil && (resp.Status.Code == base.StatusUnauthenticated || resp.Status.Code == base.StatusInvalidSession) {
+		return 0, errors.New(resp.Status.Message)
+	}
+	// the reports the master failed to store are kept
+	if resp.Status != nil && resp.Status.Code == base.StatusInternalError {
+		return int(resp.GetHandled()), errors.New(resp.Status.Message)
+	}
+	if resp.Status != nil && resp.Status.Code != 0 {
+		d.logger.WithField("status", resp.Status).Warn("argos sniffer report batch rejected")
+	} else if rejected := len(reqs) - int(resp.GetAccepted()); rejected > 0 {
+		d.logger.WithField("rejected", rejected).Warn("argos sniffer reports rejected")
+	}
+	return len(reqs), nil
+}
+
+// sendObservations sends a batch of raw observations to the master, observations rejected by the master are dropped
+func (d *SnifferDaemon) sendObservations(observations []*master.Observation) error {
+	req := &master.ObservationBatchRequest{
+		Identifier:   d.identifier(),
+		Protocol:     d.currentProtocol(),
+		Observations: observations,
+		Session:      thrift.StringPtr(d.currentSession()),
+	}
+	if d.config.HMACKey != "" {
+		req.Auth = &base.Auth{}
+		if err := auth.Sign([]byte(d.config.HMACKey), req, req.Auth); err != nil {
+			return err
+		}
+	}
+
+	resp, err := d.master.ReportObservations(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	if resp.Status != nil && (resp.Status.Code == base.StatusUnauthenticated || resp.Status.Code == base.StatusInvalidSession) {
+		return errors.New(resp.Status.Message)
+	}
+	if resp.Status != nil && resp.Status.Code != 0 {
+		d.logger.WithField("status", resp.Status).Warn("argos sniffer observation batch rejected")
+	} else if rejected := len(observations) - int(resp.GetAccepted()); rejected > 0 {
+		d.logger.WithField("rejected", rejected).Warn("argos sniffer observations rejected")
+	}
+	return nil
+}
+
+// Options are the command line options of the daemon
+type Options struct {
+	// ConfigFile is the path of the config, the address book, session and report journal are kept next to it
+	ConfigFile string
+	// LogDir is the directory the log files are written to, LogLevel is the lowest level logged
+	LogDir   string
+	LogLevel string
+	// Protocol overrides the protocol assigned by the master when it is not empty
+	Protocol string
+}
+
+// DefaultOptions returns the options of a sniffer run in its working directory
+func DefaultOptions() Options {
+	return Options{
+		ConfigFile: DefaultConfigFile,
+		LogDir:     "logs",
+		LogLevel:   "info",
+	}
+}
+
+func Init(opts Options) {
 	if instance != nil {
 		instance.logger.Fatal("argos sniffer daemon already initialized")
 	}
 
-	instance = &SnifferDaemon{}
+	instance = &SnifferDaemon{
+		configFile:       opts.ConfigFile,
+		protocolOverride: opts.Protocol,
+		started:          time.Now(),
+		exit:             make(chan int, 1),
+		closing:          make(chan struct{}),
+	}
 
 	var err error
 
 	// it should be noticed that logger should be initialized BEFORE any other global consts except the daemon instance
-	instance.logger = logrus.New()
-	instance.logger.AddHook(lfshook.NewHook(
-		fmt.Sprintf("logs/%s.log", time.Now().Format(time.RFC3339)),
-		&logrus.TextFormatter{
-			FullTimestamp: true,
-			DisableColors: true,
-		},
-	))
+	if instance.logger, err = argos.NewLogger(opts.LogDir, "", opts.LogLevel); err != nil {
+		logrus.WithError(err).Fatal("argos sniffer logger init failed")
+	}
 
 	argos.SetLogger(instance.logger)
 
@@ -140,32 +605,68 @@ func Init() {
 		instance.logger.WithError(err).Fatal("read config failed")
 	}
 
-	instance.sniffer = NewSniffer(instance.logger)
-
 	if err = bitcoin.Init(); err != nil {
 		instance.logger.WithError(err).Fatal("bitcoin init failed")
 	}
 
+	if opts.Protocol != "" && !supportedProtocol(opts.Protocol) {
+		instance.logger.WithField("protocol", opts.Protocol).Fatal("protocol not supported")
+	}
+
+	if err = bitcoin.SetOptions(instance.config.Bitcoin); err != nil {
+		instance.logger.WithError(err).Fatal("bitcoin options invalid")
+	}
+
 	if instance.master, err = am.NewClient("argos.master", client.WithHostPorts(instance.config.MasterAddress)); err != nil {
 		instance.logger.WithError(err).Fatal("argos master client init failed")
 	}
 
-	resp, err := instance.master.Ping(context.Background(), &master.PingRequest{
-		Identifier: instance.config.Identifier,
-		Timestamp:  time.Now().UnixNano(),
-	})
+	// register to the master, taking a new identifier when another live sniffer holds ours
+	instance.loadSession()
+	instance.clock = newClock()
+	var req *master.PingRequest
+	var resp *master.PingResponse
+	var received int64
+	for conflicts := 0; ; conflicts++ {
+		req = instance.pingRequest()
+		resp, err = instance.master.Ping(context.Background(), req)
+		received = time.Now().UnixNano()
+		if err != nil {
+			instance.logger.WithError(err).Fatal("argos sniffer register failed")
+		}
+		// the first response carries the session, the clock sample and the task, so it is verified as the
+		// responses of the ping loop
+		if instance.config.HMACKey != "" && resp != nil {
+			if err = auth.VerifyResponse([]byte(instance.config.HMACKey), resp, resp.GetAuth(), req.GetAuth()); err != nil {
+				instance.logger.WithError(err).WithField("status", resp.GetStatus()).Fatal("argos sniffer register response not authenticated")
+			}
+		}
+		if resp == nil || resp.Status == nil || resp.Status.Code != base.StatusIdentifierConflict || conflicts >= maxIdentifierConflicts {
+			break
+		}
+		instance.resolveConflict()
+	}
 
-	if err != nil {
-		instance.logger.WithError(err).Fatal("argos sniffer register failed")
+	if resp == nil || resp.Status == nil || resp.Status.Code != 0 {
+		instance.logger.WithField("status", resp.GetStatus()).Fatal("argos sniffer register failed")
 	}
 
-	if resp == nil || resp.Status.Code != 0 {
-		instance.logger.WithField("status", resp.Status).Fatal("argos sniffer register failed")
+	// save session, clock sample and protocol
+	instance.setSession(resp.GetSession())
+	instance.syncClock(req, resp, received)
+	instance.protocol = instance.taskProtocol(resp.GetProtocol())
+	if task := resp.GetTask(); task != nil {
+		instance.setTaskOptions(task)
+		instance.task = task
 	}
+	if instance.config.RawObservations {
+		instance.observer = newObserver(instance.logger, MaxQueuedObservations, instance.sendObservations)
+	}
+	instance.sniffer = instance.newSniffer(instance.protocol, instance.task)
 
-	// save time delta and protocol
-	instance.timeDelta = (resp.GetTimeSync().RecvTimestamp - resp.GetTimeSync().SendTimestamp) / 2
-	instance.protocol = resp.GetProtocol()
+	// the reports journaled by the last run are replayed by the reporter
+	instance.reporter = newReporter(instance.logger, instance.path(ReportJournalFile), MaxQueuedReports, instance.sendReport)
+	instance.registerMetrics()
 }
 
 func Instance() *SnifferDaemon {
@@ -175,22 +676,48 @@ func Instance() *SnifferDaemon {
 	return instance
 }
 
-func Report(txid []byte, ip []byte, port int, timestamp time.Time, method string) {
+// Report queues the report of the source estimated by the method, offeredTxRcncl marks that the source sent
+// sendtxrcncl
+func Report(txid []byte, ip []byte, port int, timestamp time.Time, method string, offeredTxRcncl bool) {
 	if instance == nil {
 		panic("argos sniffer daemon not initialized")
 	}
 
-	instance.master.Report(context.Background(), &master.ReportRequest{
-		Identifier: Instance().config.Identifier,
+	// the timestamp is converted to the master clock, so the reports of all sniffers are comparable
+	offset, uncertainty := instance.clock.Offset(timestamp)
+	instance.reporter.Enqueue(&master.ReportRequest{
+		Identifier: instance.identifier(),
 		Method:     method,
 		Transaction: &base.Transaction{
 			Txid:      txid,
-			Timestamp: timestamp.UnixNano() + instance.timeDelta,
+			Timestamp: timestamp.UnixNano() + int64(offset),
 			From: &base.TcpAddress{
 				Ip:   ip,
 				Port: int32(port),
 			},
 		},
-		Protocol: instance.protocol,
+		Protocol:       instance.currentProtocol(),
+		Uncertainty:    thrift.Int64Ptr(int64(uncertainty)),
+		OfferedTxrcncl: thrift.BoolPtr(offeredTxRcncl),
+	})
+}
+
+// Observe queues a raw observation of the transaction announced by the peer at ip:port
+func Observe(txid []byte, ip []byte, port int, timestamp time.Time, offeredTxRcncl bool) {
+	if instance == nil {
+		panic("argos sniffer daemon not initialized")
+	}
+	if instance.observer == nil {
+		return
+	}
+
+	offset, uncertainty := instance.clock.Offset(timestamp)
+	instance.observer.Add(&master.Observation{
+		Txid:           txid,
+		Ip:             ip,
+		Port:           int32(port),
+		Timestamp:      timestamp.UnixNano() + int64(offset),
+		Uncertainty:    int64(uncertainty),
+		OfferedTxrcncl: thrift.BoolPtr(offeredTxRcncl),
 	})
 }
@@ -0,0 +1,69 @@
+package daemon
+
+import (
+	"net/http"
+
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
+	"github.com/rcrowley/go-metrics"
+)
+
+// registerMetrics registers the gauges reading the state of the daemon when they are collected
+func (d *SnifferDaemon) registerMetrics() {
+	peers := func(inbound bool) func() int64 {
+		return func() int64 {
+			d.mu.Lock()
+			s := d.sniffer
+			d.mu.Unlock()
+			if s == nil {
+				return 0
+			}
+			o, i := s.PeerCount()
+			if inbound {
+				return int64(i)
+			}
+			return int64(o)
+		}
+	}
+	reports := func(journal bool) func() int64 {
+		return func() int64 {
+			queued, journaled := d.reporter.Len()
+			if journal {
+				return int64(journaled)
+			}
+			return int64(queued)
+		}
+	}
+
+	gauges := map[string]func() int64{
+		exporter.Name("sniffer.peers", "direction", "outbound"): peers(false),
+		exporter.Name("sniffer.peers", "direction", "inbound"):  peers(true),
+		"sniffer.reports.queued":                                reports(false),
+		"sniffer.reports.journaled":                             reports(true),
+	}
+	if d.observer != nil {
+		gauges["sniffer.observations.queued"] = func() int64 {
+			return int64(d.observer.Len())
+		}
+	}
+	for name, f := range gauges {
+		metrics.GetOrRegister(name, metrics.NewFunctionalGauge(f))
+	}
+}
+
+// serveMetrics serves the metrics in the Prometheus text format on the metrics listen address, nil is returned
+// when the address is not configured
+func (d *SnifferDaemon) serveMetrics() *http.Server {
+	if d.config.MetricsListenAddress == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter.Handler(nil))
+	server := &http.Server{Addr: d.config.MetricsListenAddress, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			d.logger.WithError(err).Error("argos sniffer metrics server failed")
+		}
+	}()
+	d.logger.WithField("address", d.config.MetricsListenAddress).Info("argos sniffer serving metrics")
+	return server
+}
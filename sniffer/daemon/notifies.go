@@ -0,0 +1,130 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+)
+
+const (
+	// DefaultNotifyRetention is how long the announcements of a transaction are kept after it was first seen
+	DefaultNotifyRetention = 10 * time.Minute
+	// MaxNotifiedTransactions bounds the transactions kept regardless of the retention, the oldest ones
+	// expire early beyond it
+	MaxNotifiedTransactions = 200000
+	// NotifyStatsInterval is how often the size of the notify store is logged
+	NotifyStatsInterval = time.Minute
+)
+
+var (
+	// notifyTransactions and notifyAnnouncements are the transactions and announcements kept in memory
+	notifyTransactions  = metrics.GetOrRegisterGauge("sniffer.notifies.transactions", nil)
+	notifyAnnouncements = metrics.GetOrRegisterGauge("sniffer.notifies.announcements", nil)
+	// notifyExpired counts the expired transactions, notifyFlushed the ones estimated on expiry
+	notifyExpired = metrics.GetOrRegisterCounter("sniffer.notifies.expired", nil)
+	notifyFlushed = metrics.GetOrRegisterCounter("sniffer.notifies.flushed", nil)
+)
+
+// notifyEntry is the announcements of a transaction
+type notifyEntry struct {
+	txid  [32]byte
+	first time.Time
+	// peers is the earliest announcement of each peer, it is set to nil once the transaction is estimated
+	// or ignored, so the later announcements are skipped until the entry expires
+	peers map[addr]time.Time
+}
+
+// notifyStore keeps the announcements of the transactions for the retention after they were first seen.
+// Entries are queued in the order they were first seen, so the expired ones are always at the head.
+type notifyStore struct {
+	retention time.Duration
+	capacity  int
+	entries   map[[32]byte]*notifyEntry
+	// queue[head:] are the entries alive, in the order they were first seen
+	queue         []*notifyEntry
+	head          int
+	announcements int
+}
+
+// newNotifyStore creates a store keeping up to capacity transactions for the retention
+func newNotifyStore(retention time.Duration, capacity int) *notifyStore {
+	return &notifyStore{
+		retention: retention,
+		capacity:  capacity,
+		entries:   make(map[[32]byte]*notifyEntry),
+	}
+}
+
+// Get returns the entry of the transaction
+func (n *notifyStore) Get(txid [32]byte) (*notifyEntry, bool) {
+	e, ok := n.entries[txid]
+	return e, ok
+}
+
+// Add creates the entry of a transaction first seen at now
+func (n *notifyStore) Add(txid [32]byte, now time.Time) *notifyEntry {
+	e := &notifyEntry{
+		txid:  txid,
+		first: now,
+		peers: make(map[addr]time.Time),
+	}
+	n.entries[txid] = e
+	n.queue = append(n.queue, e)
+	return e
+}
+
+// Announce records the announcement of the peer, only the earliest announcement of each peer is kept
+func (n *notifyStore) Announce(e *notifyEntry, address addr, at time.Time) {
+	if e.peers == nil {
+		return
+	}
+	if tt, ok := e.peers[address]; ok {
+		if at.Before(tt) {
+			e.peers[address] = at
+		}
+		return
+	}
+	e.peers[address] = at
+	n.announcements++
+}
+
+// Done drops the announcements of the transaction, the entry is kept until it expires
+func (n *notifyStore) Done(e *notifyEntry) {
+	n.announcements -= len(e.peers)
+	e.peers = nil
+}
+
+// Expire removes the transactions first seen before the retention and the oldest ones beyond the capacity,
+// flush is called with each removed entry before its announcements are dropped
+func (n *notifyStore) Expire(now time.Time, flush func(e *notifyEntry)) int {
+	expired := 0
+	for n.head < len(n.queue) {
+		e := n.queue[n.head]
+		if now.Sub(e.first) < n.retention && len(n.queue)-n.head <= n.capacity {
+			break
+		}
+		n.queue[n.head] = nil
+		n.head++
+		expired++
+
+		flush(e)
+		n.Done(e)
+		delete(n.entries, e.txid)
+	}
+
+	// compact the queue once most of it is expired
+	if n.head > 1024 && n.head > len(n.queue)/2 {
+		n.queue = append([]*notifyEntry(nil), n.queue[n.head:]...)
+		n.head = 0
+	}
+
+	notifyExpired.Inc(int64(expired))
+	notifyTransactions.Update(int64(len(n.entries)))
+	notifyAnnouncements.Update(int64(n.announcements))
+	return expired
+}
+
+// Len returns the number of transactions and announcements kept
+func (n *notifyStore) Len() (transactions, announcements int) {
+	return len(n.entries), n.announcements
+}
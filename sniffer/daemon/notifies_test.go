@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyStoreExpire(t *testing.T) {
+	n := newNotifyStore(time.Minute, MaxNotifiedTransactions)
+	now := time.Now()
+	a := newAddr(net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8333})
+	b := newAddr(net.TCPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 8333})
+
+	first := n.Add([32]byte{1}, now)
+	n.Announce(first, a, now)
+	n.Announce(first, b, now.Add(time.Second))
+	// only the earliest announcement of a peer is kept
+	n.Announce(first, b, now)
+	assert.Equal(t, now, first.peers[b])
+
+	second := n.Add([32]byte{2}, now.Add(30*time.Second))
+	n.Announce(second, a, now)
+	n.Done(second)
+	transactions, announcements := n.Len()
+	assert.Equal(t, 2, transactions)
+	assert.Equal(t, 2, announcements)
+
+	// the transactions are flushed with their announcements once they expire
+	var flushed []int
+	flush := func(e *notifyEntry) {
+		flushed = append(flushed, len(e.peers))
+	}
+	assert.Equal(t, 0, n.Expire(now.Add(59*time.Second), flush))
+	assert.Equal(t, 1, n.Expire(now.Add(time.Minute), flush))
+	assert.Equal(t, []int{2}, flushed)
+	_, ok := n.Get([32]byte{1})
+	assert.False(t, ok)
+	_, ok = n.Get([32]byte{2})
+	assert.True(t, ok)
+
+	assert.Equal(t, 1, n.Expire(now.Add(2*time.Minute), flush))
+	assert.Equal(t, []int{2, 0}, flushed)
+	transactions, announcements = n.Len()
+	assert.Equal(t, 0, transactions)
+	assert.Equal(t, 0, announcements)
+}
+
+func TestNotifyStoreCapacity(t *testing.T) {
+	n := newNotifyStore(time.Hour, 2)
+	now := time.Now()
+	for i := 0; i < 3000; i++ {
+		n.Add([32]byte{byte(i), byte(i >> 8)}, now)
+		n.Expire(now, func(e *notifyEntry) {})
+	}
+
+	// the oldest transactions expire beyond the capacity, and the queue is compacted
+	transactions, _ := n.Len()
+	assert.Equal(t, 2, transactions)
+	assert.True(t, len(n.queue) < 2048, len(n.queue))
+	_, ok := n.Get([32]byte{byte(2999 % 256), byte(2999 >> 8)})
+	assert.True(t, ok)
+}
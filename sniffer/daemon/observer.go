@@ -0,0 +1,138 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/master"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ObservationFlushInterval is the interval the queued observations are sent to the master
+	ObservationFlushInterval = time.Second
+	// MaxObservationBatch is the maximum number of observations sent by a single ReportObservations call
+	MaxObservationBatch = 1000
+	// MaxQueuedObservations is the number of observations kept while the master is unreachable, the oldest
+	// ones are dropped beyond it
+	MaxQueuedObservations = 100000
+)
+
+// observer queues the raw observations and sends them to the master in batches. Unlike the reports they
+// are not journaled, observations are dropped when the queue is full or the daemon exits before sending them.
+type observer struct {
+	logger   *logrus.Logger
+	send     func(observations []*master.Observation) error
+	capacity int
+	queue    []*master.Observation
+	// dropped is the number of observations dropped since it was last logged, drops is the number dropped
+	// since the observer was created
+	dropped int
+	drops   int
+	closing chan struct{}
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+// newObserver creates an observer keeping up to capacity observations
+func newObserver(logger *logrus.Logger, capacity int, send func(observations []*master.Observation) error) *observer {
+	return &observer{
+		logger:   logger,
+		send:     send,
+		capacity: capacity,
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Add queues the observation, the oldest observation is dropped when the queue is full
+func (o *observer) Add(observation *master.Observation) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.queue) >= o.capacity {
+		o.queue = o.queue[1:]
+		o.dropped++
+		o.drops++
+	}
+	o.queue = append(o.queue, observation)
+}
+
+// Len returns the number of queued observations
+func (o *observer) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.queue)
+}
+
+// flush sends the queued observations in batches, it stops at the first failure leaving the rest queued
+func (o *observer) flush() error {
+	for {
+		o.mu.Lock()
+		n := len(o.queue)
+		if n > MaxObservationBatch {
+			n = MaxObservationBatch
+		}
+		batch := o.queue[:n:n]
+		drops := o.drops
+		if o.dropped > 0 {
+			o.logger.WithField("observations", o.dropped).Warn("observation queue full, observations dropped")
+			o.dropped = 0
+		}
+		o.mu.Unlock()
+
+		if n == 0 {
+			return nil
+		}
+		if err := o.send(batch); err != nil {
+			return err
+		}
+
+		o.mu.Lock()
+		// the observations dropped while sending were the oldest ones, so they were in the batch
+		if sent := n - (o.drops - drops); sent > 0 {
+			o.queue = o.queue[sent:]
+		}
+		o.mu.Unlock()
+	}
+}
+
+// Run sends the queued observations every ObservationFlushInterval until the observer is closed
+func (o *observer) Run() {
+	defer close(o.done)
+	ticker := time.NewTicker(ObservationFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := o.flush(); err != nil {
+				o.logger.WithError(err).Warn("argos sniffer observation report failed")
+			}
+		case <-o.closing:
+			return
+		}
+	}
+}
+
+// Close stops the observer and tries sending the queued observations until the timeout expires, the number
+// of observations not sent is returned
+func (o *observer) Close(timeout time.Duration) int {
+	o.once.Do(func() {
+		close(o.closing)
+	})
+	<-o.done
+
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- o.flush()
+	}()
+	select {
+	case err := <-flushed:
+		if err != nil {
+			o.logger.WithError(err).Warn("argos sniffer observation report failed")
+		}
+	case <-time.After(timeout):
+		o.logger.Warn("argos sniffer observation flush timed out")
+	}
+	return o.Len()
+}
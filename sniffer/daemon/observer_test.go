@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/master"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserverFlush(t *testing.T) {
+	var sizes []int
+	var sent []int64
+
+	o := newObserver(logrus.StandardLogger(), MaxQueuedObservations, func(observations []*master.Observation) error {
+		sizes = append(sizes, len(observations))
+		for _, observation := range observations {
+			sent = append(sent, observation.Timestamp)
+		}
+		return nil
+	})
+	for i := 0; i < MaxObservationBatch+1; i++ {
+		o.Add(&master.Observation{Timestamp: int64(i)})
+	}
+	go o.Run()
+
+	assert.Equal(t, 0, o.Close(time.Second))
+	assert.Equal(t, []int{MaxObservationBatch, 1}, sizes)
+	for i, timestamp := range sent {
+		assert.Equal(t, int64(i), timestamp)
+	}
+}
+
+func TestObserverDrop(t *testing.T) {
+	var available = false
+	var sent []int64
+
+	o := newObserver(logrus.StandardLogger(), 3, func(observations []*master.Observation) error {
+		if !available {
+			return errors.New("master not available")
+		}
+		for _, observation := range observations {
+			sent = append(sent, observation.Timestamp)
+		}
+		return nil
+	})
+
+	// the oldest observations are dropped when the queue is full
+	for i := 0; i < 5; i++ {
+		o.Add(&master.Observation{Timestamp: int64(i)})
+	}
+	assert.Equal(t, 3, o.Len())
+	assert.NotNil(t, o.flush())
+	assert.Equal(t, 3, o.Len())
+
+	available = true
+	assert.Nil(t, o.flush())
+	assert.Equal(t, []int64{2, 3, 4}, sent)
+	assert.Equal(t, 0, o.Len())
+}
+
+func TestObserverDropWhileSending(t *testing.T) {
+	var sent [][]int64
+	var o *observer
+
+	o = newObserver(logrus.StandardLogger(), 3, func(observations []*master.Observation) error {
+		var batch []int64
+		for _, observation := range observations {
+			batch = append(batch, observation.Timestamp)
+		}
+		sent = append(sent, batch)
+		// two observations arrive while the first batch is sent, dropping the oldest ones of the batch
+		if len(sent) == 1 {
+			o.Add(&master.Observation{Timestamp: 3})
+			o.Add(&master.Observation{Timestamp: 4})
+		}
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		o.Add(&master.Observation{Timestamp: int64(i)})
+	}
+
+	assert.Nil(t, o.flush())
+	assert.Equal(t, [][]int64{{0, 1, 2}, {3, 4}}, sent)
+	assert.Equal(t, 0, o.Len())
+}
@@ -0,0 +1,285 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/master"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// ReportJournalFile keeps the reports which overflowed the queue or could not be sent before the daemon exited,
+	// they are replayed once the queue has room and the master is reachable again
+	ReportJournalFile = "reports.journal.jsonl"
+	// ReportFlushTimeout limits the time sending queued reports when the daemon is shutting down
+	ReportFlushTimeout = 10 * time.Second
+	// MaxReportBatch is the maximum number of reports sent by a single ReportBatch call
+	MaxReportBatch = 100
+	// MaxQueuedReports is the number of reports kept in memory, the others are spilled to the journal
+	MaxQueuedReports = 10000
+	// reportRetryInterval is the delay before resending reports after the master failed to receive them,
+	// it doubles on each further failure
+	reportRetryInterval = time.Second
+	// maxReportRetryInterval caps the delay before resending reports
+	maxReportRetryInterval = time.Minute
+)
+
+// reporter queues the reports and sends them to the master in batches and in order, the reports of a batch not
+// handled by the master are retried with backoff. Reports are spilled to the journal when the queue is full, so none of them is lost
+// during master outages.
+type reporter struct {
+	logger *logrus.Logger
+	// send returns the number of leading reports handled by the master, which is all of them without error
+	send     func(reqs []*master.ReportRequest) (int, error)
+	journal  string
+	capacity int
+	queue    []*master.ReportRequest
+	// journaled is the number of reports in the journal
+	journaled int
+	signal    chan struct{}
+	closing   chan struct{}
+	abort     chan struct{}
+	done      chan struct{}
+	once      sync.Once
+	mu        sync.Mutex
+}
+
+// newReporter creates a reporter spilling into the journal, reports left in the journal by the last run are replayed
+func newReporter(logger *logrus.Logger, journal string, capacity int, send func(reqs []*master.ReportRequest) (int, error)) *reporter {
+	r := &reporter{
+		logger:   logger,
+		send:     send,
+		journal:  journal,
+		capacity: capacity,
+		signal:   make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		abort:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if reqs, err := loadReports(journal); err != nil {
+		logger.WithError(err).Warn("load report journal failed")
+	} else if r.journaled = len(reqs); r.journaled > 0 {
+		logger.WithField("reports", r.journaled).Info("report journal loaded")
+	}
+	return r
+}
+
+// Enqueue adds the reports to the end of the queue, the ones exceeding the capacity are spilled to the journal
+func (r *reporter) Enqueue(reqs ...*master.ReportRequest) {
+	r.mu.Lock()
+	// once anything is journaled, new reports are journaled too so they are sent in order
+	room := r.capacity - len(r.queue)
+	if r.journaled > 0 || room < 0 {
+		room = 0
+	}
+	if room > len(reqs) {
+		room = len(reqs)
+	}
+	r.queue = append(r.queue, reqs[:room]...)
+	r.spill(reqs[room:])
+	r.mu.Unlock()
+
+	select {
+	case r.signal <- struct{}{}:
+	default:
+	}
+}
+
+// spill appends the reports to the journal, the lock must be held
+func (r *reporter) spill(reqs []*master.ReportRequest) {
+	if len(reqs) == 0 {
+		return
+	}
+	if err := appendReports(r.journal, reqs); err != nil {
+		r.logger.WithError(err).WithField("reports", len(reqs)).Error("spill reports to journal failed, reports lost")
+		return
+	}
+	r.journaled += len(reqs)
+}
+
+// replay moves the journaled reports into the queue as many as the capacity allows, the lock must be held
+func (r *reporter) replay() {
+	reqs, err := loadReports(r.journal)
+	if err != nil {
+		r.logger.WithError(err).Warn("load report journal failed")
+		return
+	}
+	if err = os.Remove(r.journal); err != nil && !os.IsNotExist(err) {
+		r.logger.WithError(err).Warn("remove report journal failed")
+		return
+	}
+	r.journaled = 0
+
+	room := r.capacity - len(r.queue)
+	if room > len(reqs) {
+		room = len(reqs)
+	}
+	r.queue = append(r.queue, reqs[:room]...)
+	r.spill(reqs[room:])
+	r.logger.WithField("reports", room).Info("reports replayed from journal")
+}
+
+// Len returns the number of queued and journaled reports
+func (r *reporter) Len() (int, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queue), r.journaled
+}
+
+// next returns the next batch, the journal is replayed once the queue is drained
+func (r *reporter) next() []*master.ReportRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 && r.journaled > 0 {
+		r.replay()
+	}
+	n := len(r.queue)
+	if n > MaxReportBatch {
+		n = MaxReportBatch
+	}
+	return r.queue[:n:n]
+}
+
+func (r *reporter) pop(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queue = r.queue[n:]
+}
+
+// Run sends the queued reports until the reporter is closed and the queue is drained, or the flush is aborted
+func (r *reporter) Run() {
+	var failures = 0
+	defer close(r.done)
+	for {
+		batch := r.next()
+		if len(batch) == 0 {
+			select {
+			case <-r.signal:
+				continue
+			case <-r.closing:
+				return
+			}
+		}
+
+		sent, err := r.send(batch)
+		r.pop(sent)
+		if err != nil {
+			failures++
+			delay := reportRetryInterval
+			for i := 1; i < failures && delay < maxReportRetryInterval; i++ {
+				delay *= 2
+			}
+			if delay > maxReportRetryInterval {
+				delay = maxReportRetryInterval
+			}
+			r.logger.WithError(err).WithField("retry", delay).Warn("argos sniffer report failed")
+
+			select {
+			case <-time.After(delay):
+			case <-r.abort:
+				// the master is still not available, leave the remaining reports to the journal
+				return
+			}
+			continue
+		}
+		failures = 0
+	}
+}
+
+// Close stops the reporter after the queue is drained or the timeout expires, the reports not sent are
+// spilled to the journal and their number is returned
+func (r *reporter) Close(timeout time.Duration) int {
+	r.once.Do(func() {
+		close(r.closing)
+	})
+
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		r.logger.Warn("argos sniffer report flush timed out")
+		close(r.abort)
+		<-r.done
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) > 0 {
+		// the queued reports are older than the journaled ones, so they are put in front of the journal
+		journaled, err := loadReports(r.journal)
+		if err == nil {
+			err = writeReports(r.journal, append(r.queue, journaled...))
+		}
+		if err != nil {
+			r.logger.WithError(err).WithField("reports", len(r.queue)).Error("journal unsent reports failed, reports lost")
+		} else {
+			r.journaled = len(r.queue) + len(journaled)
+		}
+		r.queue = nil
+	}
+	return r.journaled
+}
+
+// appendReports appends the reports to the file as json lines
+func appendReports(path string, reqs []*master.ReportRequest) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return encodeReports(f, reqs)
+}
+
+// writeReports replaces the file with the reports atomically
+func writeReports(path string, reqs []*master.ReportRequest) error {
+	var tmp = path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err = encodeReports(f, reqs); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// encodeReports writes the reports as json lines and closes the file
+func encodeReports(f *os.File, reqs []*master.ReportRequest) error {
+	var err error
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, req := range reqs {
+		if err = enc.Encode(req); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadReports reads the reports written by appendReports, malformed lines are skipped
+func loadReports(path string) ([]*master.ReportRequest, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var reqs []*master.ReportRequest
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req master.ReportRequest
+		if json.Unmarshal(scanner.Bytes(), &req) == nil {
+			reqs = append(reqs, &req)
+		}
+	}
+	return reqs, scanner.Err()
+}
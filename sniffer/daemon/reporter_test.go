@@ -0,0 +1,133 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/master/kitex_gen/master"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReporterFlush(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	var batches = 0
+	var failures = 2
+
+	journal := filepath.Join(t.TempDir(), ReportJournalFile)
+	r := newReporter(logrus.StandardLogger(), journal, MaxQueuedReports, func(reqs []*master.ReportRequest) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		// the master is not available for the first attempts
+		if failures > 0 {
+			failures--
+			return 0, errors.New("master not available")
+		}
+		batches++
+		for _, req := range reqs {
+			sent = append(sent, req.Method)
+		}
+		return len(reqs), nil
+	})
+
+	// queued before running, so they are sent in a single batch
+	r.Enqueue(&master.ReportRequest{Method: "FTE"}, &master.ReportRequest{Method: "RCE"})
+	go r.Run()
+
+	assert.Equal(t, 0, r.Close(ReportFlushTimeout))
+	assert.Equal(t, []string{"FTE", "RCE"}, sent)
+	assert.Equal(t, 1, batches)
+}
+
+func TestReporterPartialBatch(t *testing.T) {
+	var sent []string
+	var failures = 1
+
+	journal := filepath.Join(t.TempDir(), ReportJournalFile)
+	r := newReporter(logrus.StandardLogger(), journal, MaxQueuedReports, func(reqs []*master.ReportRequest) (int, error) {
+		// the master fails after handling the first report, which is not resent
+		n := len(reqs)
+		if failures > 0 {
+			failures--
+			n = 1
+		}
+		for _, req := range reqs[:n] {
+			sent = append(sent, req.Method)
+		}
+		if n < len(reqs) {
+			return n, errors.New("database not available")
+		}
+		return n, nil
+	})
+
+	r.Enqueue(&master.ReportRequest{Method: "0"}, &master.ReportRequest{Method: "1"}, &master.ReportRequest{Method: "2"})
+	go r.Run()
+
+	assert.Equal(t, 0, r.Close(ReportFlushTimeout))
+	assert.Equal(t, []string{"0", "1", "2"}, sent)
+}
+
+func TestReporterBatchSize(t *testing.T) {
+	var sizes []int
+
+	journal := filepath.Join(t.TempDir(), ReportJournalFile)
+	r := newReporter(logrus.StandardLogger(), journal, MaxQueuedReports, func(reqs []*master.ReportRequest) (int, error) {
+		sizes = append(sizes, len(reqs))
+		return len(reqs), nil
+	})
+
+	for i := 0; i < MaxReportBatch*2+1; i++ {
+		r.Enqueue(&master.ReportRequest{})
+	}
+	go r.Run()
+
+	assert.Equal(t, 0, r.Close(ReportFlushTimeout))
+	assert.Equal(t, []int{MaxReportBatch, MaxReportBatch, 1}, sizes)
+}
+
+func TestReporterJournal(t *testing.T) {
+	journal := filepath.Join(t.TempDir(), ReportJournalFile)
+	r := newReporter(logrus.StandardLogger(), journal, 2, func(reqs []*master.ReportRequest) (int, error) {
+		return 0, errors.New("master not available")
+	})
+
+	// the reports exceeding the capacity are spilled in order
+	for i := 0; i < 5; i++ {
+		r.Enqueue(&master.ReportRequest{Method: fmt.Sprint(i)})
+	}
+	queued, journaled := r.Len()
+	assert.Equal(t, 2, queued)
+	assert.Equal(t, 3, journaled)
+
+	go r.Run()
+	// the queued reports are journaled in front of the spilled ones when they could not be sent
+	assert.Equal(t, 5, r.Close(time.Second))
+
+	// a restarted reporter replays the journal once the master is reachable
+	var sent []string
+	r = newReporter(logrus.StandardLogger(), journal, 2, func(reqs []*master.ReportRequest) (int, error) {
+		for _, req := range reqs {
+			sent = append(sent, req.Method)
+		}
+		return len(reqs), nil
+	})
+	queued, journaled = r.Len()
+	assert.Equal(t, 0, queued)
+	assert.Equal(t, 5, journaled)
+
+	// new reports are sent after the journaled ones
+	r.Enqueue(&master.ReportRequest{Method: "5"})
+	go r.Run()
+
+	assert.Equal(t, 0, r.Close(ReportFlushTimeout))
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5"}, sent)
+
+	loaded, err := loadReports(journal)
+	assert.Nil(t, err)
+	assert.Empty(t, loaded)
+}
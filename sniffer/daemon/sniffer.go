@@ -1,17 +1,38 @@
 package daemon
 
 import (
+	"context"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/AlaricGilbert/argos-core/argos/exporter"
 	"github.com/AlaricGilbert/argos-core/graph"
+	"github.com/cloudwego/netpoll"
+	"github.com/rcrowley/go-metrics"
 	"github.com/sirupsen/logrus"
 )
 
 const ReportCenterThreshold = 24
 
+const (
+	// EstimatorFirstTimestamp reports the first peer announcing a transaction as its source
+	EstimatorFirstTimestamp = "FTE"
+	// EstimatorReportCenter reports the center of the peers announcing a transaction as its source
+	EstimatorReportCenter = "RCE"
+)
+
+var (
+	// notifications counts the transaction announcements received from all peers
+	notifications = metrics.GetOrRegisterCounter("sniffer.notifications", nil)
+	// estimatorRuns count the runs of each estimator
+	estimatorRuns = map[string]metrics.Counter{
+		EstimatorFirstTimestamp: metrics.GetOrRegisterCounter(exporter.Name("sniffer.estimator.runs", "method", EstimatorFirstTimestamp), nil),
+		EstimatorReportCenter:   metrics.GetOrRegisterCounter(exporter.Name("sniffer.estimator.runs", "method", EstimatorReportCenter), nil),
+	}
+)
+
 type addr struct {
 	IP   [16]byte
 	Port int16
@@ -19,22 +40,62 @@ type addr struct {
 
 func newAddr(address net.TCPAddr) addr {
 	var ip [16]byte
-	copy(ip[:], address.IP)
+	// keep IPv4 addresses in the IPv4-mapped form, so they are keyed the same however they were parsed
+	copy(ip[:], address.IP.To16())
 	return addr{
 		IP:   ip,
 		Port: int16(address.Port),
 	}
 }
 
+// peerInfo tracks a connected peer and how valuable its announcements are to the estimators
+type peerInfo struct {
+	peer      argos.Peer
+	address   net.TCPAddr
+	direction argos.Direction
+	connected time.Time
+	// good means the remote talked to us, so its address is worth retrying
+	good bool
+	// evicted means the peer was halted by the sniffer to make room for others
+	evicted       bool
+	announcements int
+	// delay is the sum of how late the announcements were compared with the first announcement of the same transaction
+	delay time.Duration
+}
+
+// averageDelay is the average delay of the announcements made by the peer
+func (p *peerInfo) averageDelay() time.Duration {
+	if p.announcements == 0 {
+		return 0
+	}
+	return p.delay / time.Duration(p.announcements)
+}
+
 type Sniffer struct {
-	transactions chan argos.TransactionNotify
-	running      bool
-	logger       *logrus.Logger
-	network      *graph.Graph[addr, struct{}]
-	notifies     map[[32]byte]map[addr]time.Time
-	newAddrs     chan net.TCPAddr
-	peers        map[addr]argos.Peer
-	mu           sync.Mutex
+	transactions   chan argos.TransactionNotify
+	protocol       string
+	halted         chan struct{}
+	haltOnce       sync.Once
+	managing       sync.WaitGroup
+	listener       netpoll.EventLoop
+	logger         *logrus.Logger
+	network        *graph.Graph[addr, struct{}]
+	notifies       *notifyStore
+	peers          map[addr]*peerInfo
+	offeredTxRcncl map[addr]struct{}
+	book           *addrBook
+	// addrBookFile is the path the address book is persisted to
+	addrBookFile string
+	maxOutbound  int
+	// maxInbound is the number of inbound peers accepted, the connections past it are closed before handshaking
+	maxInbound int
+	// estimators are the enabled estimators, all of them are enabled when it is nil
+	estimators map[string]struct{}
+	// rceThreshold is the number of announcements collected before running the ReportCenterEstimator
+	rceThreshold int
+	// observing streams every announcement to the master as a raw observation
+	observing bool
+	mu        sync.Mutex
 }
 
 func (s *Sniffer) Logger() *logrus.Logger {
@@ -46,136 +107,228 @@ func (s *Sniffer) NotifyTransaction(notify argos.TransactionNotify) {
 	defer s.mu.Unlock()
 
 	address := newAddr(notify.Source)
+	notifications.Inc(1)
 
-	// when get a transaction, check if it has been ignored
-	// we use [nil] to mark the transaction has been ignored
-	var notifies map[addr]time.Time
-	var ok bool
-	// first check the s.notifies map if it has been notified
-	if notifies, ok = s.notifies[notify.TxID]; !ok {
-		// never notified, create a new map, so the notifies variable is not nil
-		// until we set it to [nil] after the transaction is marked ignored in ReportCenterEstimator.
-		notifies = make(map[addr]time.Time)
-		s.notifies[notify.TxID] = notifies
-	}
-	// then check the notifies map if the transaction has been ignored
-	if notifies == nil {
-		return
+	// raw observations are sent regardless of the estimators, even for the ignored transactions
+	if s.observing {
+		Observe(notify.TxID[:], notify.Source.IP[:], notify.Source.Port, notify.Timestamp, notify.OfferedTxRcncl)
 	}
 
-	func() {
-		if tt, ok := notifies[address]; ok && tt.Before(notify.Timestamp) {
-			return
-		}
+	// remember the peers offering reconciliation, their inv timings may be sparser than others
+	if notify.OfferedTxRcncl {
+		s.offeredTxRcncl[address] = struct{}{}
+	}
+
+	// expire the transactions out of the retention before a new one is added
+	now := time.Now()
+	s.notifies.Expire(now, s.flushNotifies)
 
-		notifies[address] = notify.Timestamp
-	}()
+	// when get a transaction, check if it has been ignored,
+	// the announcements of the ignored transactions are dropped
+	e, ok := s.notifies.Get(notify.TxID)
+	if !ok {
+		e = s.notifies.Add(notify.TxID, now)
+	}
+	if e.peers == nil {
+		return
+	}
+	s.notifies.Announce(e, address, notify.Timestamp)
+	notifies := e.peers
+
+	// score the peer by how late it announces transactions compared with the others
+	if info, ok := s.peers[address]; ok {
+		var first = notify.Timestamp
+		for _, tt := range notifies {
+			if tt.Before(first) {
+				first = tt
+			}
+		}
+		info.announcements++
+		info.delay += notify.Timestamp.Sub(first)
+		s.markGood(info)
+	}
 
 	// FirstTimestampEstimate is the first time a transaction is seen
 	// by a node.
 
 	if len(notifies) == 1 {
-		go Report(notify.TxID[:], notify.Source.IP[:], notify.Source.Port, notify.Timestamp, "FTE")
-	}
-
-	if len(notifies) == ReportCenterThreshold {
-		// generete a subgraph contains all the nodes that have been notified
-		// get all the nodes in the subgraph
-		var nodes = make([]addr, 0)
-		var candicates = make([]addr, 0)
-		var subgraph = graph.NewGraph[addr, struct{}]()
-		for k := range notifies {
-			// check the node is alive
-			if _, ok := s.peers[k]; ok {
-				nodes = append(nodes, k)
-				subgraph.AddVertex(k, struct{}{})
-			}
+		if notify.OfferedTxRcncl {
+			s.logger.WithField("address", notify.Source).Info("first seen transaction announced by a peer offering txrcncl")
+		}
+		if notify.Direction == argos.Inbound {
+			s.logger.WithField("address", notify.Source).Info("first seen transaction announced by an inbound peer")
+		}
+		if s.enabled(EstimatorFirstTimestamp) {
+			estimatorRuns[EstimatorFirstTimestamp].Inc(1)
+			go Report(notify.TxID[:], notify.Source.IP[:], notify.Source.Port, notify.Timestamp, EstimatorFirstTimestamp, notify.OfferedTxRcncl)
 		}
+	}
+
+	if len(notifies) == s.rceThreshold {
+		// the transaction is ignored without estimating when the ReportCenterEstimator is disabled
+		if s.enabled(EstimatorReportCenter) {
+			s.estimateReportCenter(notify.TxID, notifies)
+		}
+		s.notifies.Done(e)
+	}
+}
+
+// flushNotifies runs the ReportCenterEstimator on an expiring transaction which never reached the threshold,
+// the lock must be held
+func (s *Sniffer) flushNotifies(e *notifyEntry) {
+	if len(e.peers) < 2 || !s.enabled(EstimatorReportCenter) {
+		return
+	}
+	if s.estimateReportCenter(e.txid, e.peers) {
+		notifyFlushed.Inc(1)
+	}
+}
+
+// estimateReportCenter runs the ReportCenterEstimator on the announcements of the transaction and reports
+// the estimated source, it returns false when no source is estimated. The lock must be held.
+func (s *Sniffer) estimateReportCenter(txid [32]byte, notifies map[addr]time.Time) bool {
+	estimatorRuns[EstimatorReportCenter].Inc(1)
+
+	// generete a subgraph contains all the nodes that have been notified
+	// get all the nodes in the subgraph
+	var nodes = make([]addr, 0)
+	var candicates = make([]addr, 0)
+	var subgraph = graph.NewGraph[addr, struct{}]()
+	for k := range notifies {
+		// check the node is alive
+		if _, ok := s.peers[k]; ok {
+			nodes = append(nodes, k)
+			subgraph.AddVertex(k, struct{}{})
+		}
+	}
 
-		// get all the edges in the subgraph and add them to the subgraph
-		for _, node := range nodes {
-			for _, nn := range s.network.GetVertex(node).GetNeighbors() {
-				if subgraph.ContainsVertex(nn) {
-					subgraph.AddEdge(node, nn)
-				}
+	// get all the edges in the subgraph and add them to the subgraph
+	for _, node := range nodes {
+		for _, nn := range s.network.GetVertex(node).GetNeighbors() {
+			if subgraph.ContainsVertex(nn) {
+				subgraph.AddEdge(node, nn)
 			}
 		}
+	}
+
+	Yt := len(nodes)
 
-		Yt := len(nodes)
-
-		// run the ReportCenterEstimator in the subgraph
-		// we thinks the subgraph is a k-degree regular tree use dfs to get the height
-		var height = func(g *graph.Graph[addr, struct{}], center, m addr) int {
-			h := 0
-			g.DFS(m, func(key addr, value struct{}) bool {
-				if key == center {
-					return true
-				}
-				h += 1
+	// run the ReportCenterEstimator in the subgraph
+	// we thinks the subgraph is a k-degree regular tree use dfs to get the height
+	var height = func(g *graph.Graph[addr, struct{}], center, m addr) int {
+		h := 0
+		g.DFS(m, func(key addr, value struct{}) bool {
+			if key == center {
 				return true
-			})
-			return h
+			}
+			h += 1
+			return true
+		})
+		return h
+	}
+
+	var max = func(a, b int) int {
+		if a > b {
+			return a
 		}
+		return b
+	}
 
-		var max = func(a, b int) int {
-			if a > b {
-				return a
+	for _, v := range subgraph.GetVertices() {
+		if len(v.GetNeighbors()) == 0 {
+			// if the vertex is a single node, then it is a candidate node
+			candicates = append(candicates, v.GetKey())
+		} else {
+			// if the vertex is not a single node, then it is a center node
+			// we need to use ReportCenterEstimator to check if it is a candidate node
+
+			maxYt := 0
+			for _, n := range v.GetNeighbors() {
+				maxYt = max(maxYt, height(subgraph, v.GetKey(), n))
 			}
-			return b
-		}
 
-		for _, v := range subgraph.GetVertices() {
-			if len(v.GetNeighbors()) == 0 {
-				// if the vertex is a single node, then it is a candidate node
+			if maxYt < Yt/2 {
 				candicates = append(candicates, v.GetKey())
-			} else {
-				// if the vertex is not a single node, then it is a center node
-				// we need to use ReportCenterEstimator to check if it is a candidate node
-
-				maxYt := 0
-				for _, n := range v.GetNeighbors() {
-					maxYt = max(maxYt, height(subgraph, v.GetKey(), n))
-				}
-
-				if maxYt < Yt/2 {
-					candicates = append(candicates, v.GetKey())
-				}
 			}
 		}
+	}
 
-		// finally use first timestamp estimate to get a candidate node
-		var candidate *addr
-		var ts = time.Now()
-		for _, c := range candicates {
-			if tt, ok := notifies[c]; ok && tt.Before(ts) {
-				candidate = &c
-				ts = tt
-			}
+	// finally use first timestamp estimate to get a candidate node
+	var candidate *addr
+	var ts = time.Now()
+	for _, c := range candicates {
+		if tt, ok := notifies[c]; ok && tt.Before(ts) {
+			c := c
+			candidate = &c
+			ts = tt
 		}
+	}
 
-		go Report(notify.TxID[:], candidate.IP[:], int(candidate.Port), ts, "RCE")
+	// none of the announcing peers is still connected
+	if candidate == nil {
+		return false
+	}
 
-		// set the notifies map to [nil]
-		s.notifies[notify.TxID] = nil
+	_, offeredTxRcncl := s.offeredTxRcncl[*candidate]
+	go Report(txid[:], candidate.IP[:], int(candidate.Port), ts, EstimatorReportCenter, offeredTxRcncl)
+	return true
+}
+
+// enabled reports whether the estimator is enabled, the lock must be held
+func (s *Sniffer) enabled(estimator string) bool {
+	if s.estimators == nil {
+		return true
+	}
+	_, ok := s.estimators[estimator]
+	return ok
+}
+
+// Configure applies the settings of the task: the enabled estimators (all of them when empty), the thresholds
+// of the estimators and the number of outbound peers (DefaultMaxOutbound when not positive). The
+// ReportCenterEstimator threshold is the number of announcements it collects, ReportCenterThreshold is used
+// when it is missing or less than 2.
+func (s *Sniffer) Configure(estimators []string, thresholds map[string]float64, maxOutbound int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.estimators = nil
+	if len(estimators) > 0 {
+		s.estimators = make(map[string]struct{}, len(estimators))
+		for _, estimator := range estimators {
+			s.estimators[estimator] = struct{}{}
+		}
+	}
+
+	s.rceThreshold = ReportCenterThreshold
+	if threshold, ok := thresholds[EstimatorReportCenter]; ok && threshold >= 2 {
+		s.rceThreshold = int(threshold)
+	}
+
+	if maxOutbound <= 0 {
+		maxOutbound = DefaultMaxOutbound
 	}
+	s.maxOutbound = maxOutbound
 }
 
-func (s *Sniffer) NodeConn(src net.TCPAddr, conn []net.TCPAddr) {
+func (s *Sniffer) NodeConn(src net.TCPAddr, conn []argos.NodeAddress) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	srcAddr := newAddr(src)
 	s.network.AddVertex(srcAddr, struct{}{})
+	if info, ok := s.peers[srcAddr]; ok {
+		s.markGood(info)
+	}
 
 	connAddrs := make([]addr, len(conn))
-	for i, addr := range conn {
-		connAddrs[i] = newAddr(addr)
+	for i, node := range conn {
+		connAddrs[i] = newAddr(node.Address)
 		if srcAddr == connAddrs[i] {
 			continue
 		}
 		s.network.AddVertex(connAddrs[i], struct{}{})
 		s.network.AddEdge(srcAddr, connAddrs[i])
-		s.newAddrs <- addr
+		s.book.Add(node.Address, node.Services, src.String())
 	}
 }
 
@@ -187,72 +340,413 @@ func (s *Sniffer) NodeExit(address net.TCPAddr) {
 	s.network.RemoveVertex(addr)
 }
 
+// Spin connects the nodes in the address book and the seeds, and manages the connections until the sniffer is halted
 func (s *Sniffer) Spin(node net.TCPAddr) {
-	// query protocol
-	var protocol = "bitcoin"
+	// halted is closed under the lock, so Halt either stops us here or waits for us
+	s.mu.Lock()
+	if s.isHalted() {
+		s.mu.Unlock()
+		return
+	}
+	s.managing.Add(1)
+	s.mu.Unlock()
+	defer s.managing.Done()
+
+	records, err := loadAddrRecords(s.addrBookFile)
+	if err != nil {
+		s.logger.WithError(err).Warn("load address book failed")
+	}
+
+	s.mu.Lock()
+	restored := s.book.Restore(records)
+	enough := restored >= s.maxOutbound
+	if node.Port != 0 {
+		s.book.Add(node, 0, SourceSeed)
+	}
+	s.mu.Unlock()
+	s.logger.WithField("addresses", restored).Info("sniffer address book loaded")
 
+	// the seeds are not queried when the restored book could fill the outbound peers by itself, reconnect the
+	// known nodes at once when it could not and only wait for seeds when we know nothing
+	if enough {
+		s.logger.WithField("addresses", restored).Info("sniffer skipped seeding")
+	} else {
+		go s.connectRandomRemote()
+		if restored > 0 {
+			go s.seed(s.protocol)
+		} else if err = s.seed(s.protocol); err != nil {
+			s.logger.WithError(err).Error("sniffer has no node to connect")
+			return
+		}
+	}
+
+	s.manage()
+}
+
+// connectRandomRemote connects a random remote node of the protocol, which only helps bootstrapping
+func (s *Sniffer) connectRandomRemote() {
+	if address, err := argos.GetRandomRemoteAddress(s.protocol); err != nil {
+		s.logger.WithError(err).Warn("get random remote address failed")
+	} else {
+		s.Connect(*address)
+	}
+}
+
+// seed adds the seed nodes of the protocol into the address book
+func (s *Sniffer) seed(protocol string) error {
 	nodes, err := argos.GetSeedNodes(protocol)
 	if err != nil {
-		s.logger.WithError(err).Fatal("get seed nodes failed")
-		return
+		s.logger.WithError(err).Warn("get seed nodes failed")
+		return err
 	}
 
-	// only print tx into log currently
-	s.running = true
-	go func() {
-		for _, node := range nodes {
-			s.Connect(node)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, node := range nodes {
+		s.book.Add(node, 0, SourceSeed)
+	}
+	return nil
+}
+
+// Reseed adds the seed nodes into the address book again, e.g. after the seeds were changed by the task
+func (s *Sniffer) Reseed() {
+	_ = s.seed(s.protocol)
+}
+
+// saveAddrBook persists the address book, the file is written without holding the lock
+func (s *Sniffer) saveAddrBook() {
+	s.mu.Lock()
+	records := s.book.Records()
+	s.mu.Unlock()
+
+	if err := saveAddrRecords(s.addrBookFile, records); err != nil {
+		s.logger.WithError(err).Warn("save address book failed")
+	}
+}
+
+// manage keeps the outbound peers at the configured count, periodically evicts the least valuable one,
+// expires the announcements out of the retention and persists the address book
+func (s *Sniffer) manage() {
+	var lastEviction = time.Now()
+	var lastSave = time.Now()
+	var lastStats = time.Now()
+	var ticker = time.NewTicker(maintainInterval)
+	defer ticker.Stop()
+	defer s.saveAddrBook()
+
+	for {
+		var now time.Time
+		select {
+		case <-s.halted:
+			return
+		case now = <-ticker.C:
+		}
+
+		s.mu.Lock()
+		s.maintain(now)
+		if now.Sub(lastEviction) >= EvictionInterval {
+			lastEviction = now
+			s.evict(now)
+		}
+		// transactions also expire while no transaction is announced
+		s.notifies.Expire(now, s.flushNotifies)
+		transactions, announcements := s.notifies.Len()
+		s.mu.Unlock()
+
+		if now.Sub(lastStats) >= NotifyStatsInterval {
+			lastStats = now
+			s.logger.WithField("transactions", transactions).WithField("announcements", announcements).
+				WithField("expired", notifyExpired.Count()).WithField("flushed", notifyFlushed.Count()).
+				Info("notify store stats")
+		}
+
+		if now.Sub(lastSave) >= AddrBookSaveInterval {
+			lastSave = now
+			s.saveAddrBook()
 		}
-	}()
-	s.hostConnection()
+	}
 }
 
-func (s *Sniffer) hostConnection() {
-	for address := range s.newAddrs {
-		s.Connect(address)
+// maintain connects addresses from the address book until the outbound peers reach the configured count
+func (s *Sniffer) maintain(now time.Time) {
+	var outbound = 0
+	for _, info := range s.peers {
+		if info.direction == argos.Outbound {
+			outbound++
+		}
+	}
+
+	var connected = func(key addr) bool {
+		_, ok := s.peers[key]
+		return ok
+	}
+
+	for ; outbound < s.maxOutbound; outbound++ {
+		address, ok := s.book.Select(now, connected)
+		if !ok {
+			return
+		}
+		s.connect(address)
+	}
+}
+
+// evict halts the least valuable outbound peer when the outbound peers are full, peers never announcing
+// transactions are evicted first, then the ones announcing with the highest average delay
+func (s *Sniffer) evict(now time.Time) {
+	var outbound = 0
+	var worst *peerInfo
+	for _, info := range s.peers {
+		if info.direction != argos.Outbound {
+			continue
+		}
+		outbound++
+		if info.evicted || now.Sub(info.connected) < EvictionGracePeriod {
+			continue
+		}
+		if worst == nil ||
+			info.announcements == 0 && worst.announcements > 0 ||
+			(info.announcements == 0) == (worst.announcements == 0) && info.averageDelay() > worst.averageDelay() {
+			worst = info
+		}
+	}
+
+	if outbound < s.maxOutbound || worst == nil {
+		return
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"address":       worst.address,
+		"announcements": worst.announcements,
+		"average_delay": worst.averageDelay(),
+	}).Info("sniffer evicting outbound peer")
+	worst.evicted = true
+	go worst.peer.Halt()
+}
+
+// markGood records the remote as a working node the first time it talks to us
+func (s *Sniffer) markGood(info *peerInfo) {
+	if info.good {
+		return
+	}
+	info.good = true
+	if info.direction == argos.Outbound {
+		s.book.Good(info.address, time.Now())
 	}
 }
 
 func (s *Sniffer) Connect(address net.TCPAddr) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.connect(address)
+}
+
+func (s *Sniffer) connect(address net.TCPAddr) {
 	var err error
 	var peer argos.Peer
 	var addr = newAddr(address)
+	if s.isHalted() {
+		return
+	}
 	if _, ok := s.peers[addr]; ok {
 		s.logger.WithField("address", address).Error("sniffer already connected to peer")
 		return
 	}
 
-	if peer, err = argos.NewPeer(Instance().protocol, &address, s); err != nil {
-		delete(s.peers, addr)
+	s.book.Attempt(address, time.Now())
+	if peer, err = argos.NewPeer(s.protocol, &address, s); err != nil {
+		s.book.Failed(address, time.Now())
 		s.logger.WithField("address", address).WithError(err).Error("failed to connect to peer")
 	} else {
+		info := &peerInfo{
+			peer:      peer,
+			address:   address,
+			direction: argos.Outbound,
+			connected: time.Now(),
+		}
 		s.network.AddVertex(addr, struct{}{})
-		s.peers[addr] = peer
-		go func() {
-			peer.Spin()
-			// delete peer
-			s.mu.Lock()
-			defer s.mu.Unlock()
-			delete(s.peers, addr)
-			s.network.RemoveVertex(addr)
-		}()
+		s.peers[addr] = info
+		go s.serve(addr, info)
+	}
+}
+
+// serve spins the peer until it exits, then removes it from the sniffer and schedules the next attempt of its address
+func (s *Sniffer) serve(addr addr, info *peerInfo) {
+	info.peer.Spin()
+	// delete peer
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.peers, addr)
+	delete(s.offeredTxRcncl, addr)
+	s.network.RemoveVertex(addr)
+
+	if info.direction != argos.Outbound {
+		return
+	}
+	now := time.Now()
+	switch {
+	case info.evicted:
+		// leave room for other addresses before trying the evicted one again
+		s.book.Defer(info.address, now.Add(MaxBackoff))
+	case info.good:
+		s.book.Defer(info.address, now.Add(BaseBackoff))
+	default:
+		s.book.Failed(info.address, now)
+	}
+}
+
+// Listen accepts inbound connections on the given address and handles them by the same peer implementation
+// as outbound ones, it blocks until the listener fails
+func (s *Sniffer) Listen(address string) error {
+	listener, err := netpoll.CreateListener("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	// the peer reads the connection by itself, so we only need to spin it when the connection is accepted;
+	// blocking in OnConnect is allowed and keeps the connection from being closed by the event loop
+	loop, err := netpoll.NewEventLoop(nil, netpoll.WithOnConnect(func(ctx context.Context, conn netpoll.Connection) context.Context {
+		s.accept(conn)
+		return ctx
+	}))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.isHalted() {
+		s.mu.Unlock()
+		_ = listener.Close()
+		return argos.ErrPeerHalted
 	}
+	s.listener = loop
+	s.mu.Unlock()
+
+	s.logger.WithField("address", address).Info("sniffer listening for inbound connections")
+	return loop.Serve(listener)
 }
 
+func (s *Sniffer) accept(conn netpoll.Connection) {
+	var err error
+	var peer argos.Peer
+	var address = *conn.RemoteAddr().(*net.TCPAddr)
+	var addr = newAddr(address)
+
+	s.mu.Lock()
+	if s.isHalted() {
+		s.mu.Unlock()
+		_ = conn.Close()
+		return
+	}
+	if _, ok := s.peers[addr]; ok {
+		s.mu.Unlock()
+		s.logger.WithField("address", address).Warn("sniffer already connected to inbound peer")
+		_ = conn.Close()
+		return
+	}
+	if _, inbound := s.peerCount(); inbound >= s.maxInbound {
+		s.mu.Unlock()
+		s.logger.WithField("address", address).Info("sniffer refused inbound peer since inbound peers are full")
+		_ = conn.Close()
+		return
+	}
+
+	if peer, err = argos.NewInboundPeer(s.protocol, conn, s); err != nil {
+		s.mu.Unlock()
+		s.logger.WithField("address", address).WithError(err).Error("failed to accept inbound peer")
+		_ = conn.Close()
+		return
+	}
+
+	s.logger.WithField("address", address).Info("sniffer accepted inbound peer")
+	info := &peerInfo{
+		peer:      peer,
+		address:   address,
+		direction: argos.Inbound,
+		connected: time.Now(),
+	}
+	s.network.AddVertex(addr, struct{}{})
+	s.peers[addr] = info
+	s.mu.Unlock()
+
+	s.serve(addr, info)
+}
+
+// PeerCount returns the numbers of the connected outbound and inbound peers
+func (s *Sniffer) PeerCount() (outbound, inbound int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peerCount()
+}
+
+// peerCount is PeerCount with the lock held
+func (s *Sniffer) peerCount() (outbound, inbound int) {
+	for _, info := range s.peers {
+		if info.direction == argos.Outbound {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+	return
+}
+
+func (s *Sniffer) isHalted() bool {
+	select {
+	case <-s.halted:
+		return true
+	default:
+		return false
+	}
+}
+
+// Halt stops accepting and making connections, halts all the peers and waits until the address book is persisted
 func (s *Sniffer) Halt() {
-	s.running = false
+	// no peer is added nor Spin started once halted is closed under the lock, so the peers and managing seen
+	// below are complete
+	s.mu.Lock()
+	s.haltOnce.Do(func() {
+		close(s.halted)
+	})
+	listener := s.listener
+	peers := make([]argos.Peer, 0, len(s.peers))
+	for _, info := range s.peers {
+		peers = append(peers, info.peer)
+	}
+	s.mu.Unlock()
+
+	if listener != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = listener.Shutdown(ctx)
+		cancel()
+	}
+	for _, peer := range peers {
+		_ = peer.Halt()
+	}
+
+	// Spin returns after the address book is persisted
+	s.managing.Wait()
+	s.logger.WithField("peers", len(peers)).Info("sniffer halted")
 }
 
-func NewSniffer(logger *logrus.Logger) *Sniffer {
+// NewSniffer creates a sniffer of the protocol keeping maxOutbound outbound peers, DefaultMaxOutbound is used
+// when maxOutbound is not positive
+func NewSniffer(logger *logrus.Logger, protocol string, maxOutbound int) *Sniffer {
+	if maxOutbound <= 0 {
+		maxOutbound = DefaultMaxOutbound
+	}
 	return &Sniffer{
-		transactions: make(chan argos.TransactionNotify),
-		newAddrs:     make(chan net.TCPAddr, 1000),
-		notifies:     make(map[[32]byte]map[addr]time.Time),
-		network:      graph.NewGraph[addr, struct{}](),
-		peers:        make(map[addr]argos.Peer),
-		running:      false,
-		logger:       logger,
+		transactions:   make(chan argos.TransactionNotify),
+		notifies:       newNotifyStore(DefaultNotifyRetention, MaxNotifiedTransactions),
+		network:        graph.NewGraph[addr, struct{}](),
+		peers:          make(map[addr]*peerInfo),
+		offeredTxRcncl: make(map[addr]struct{}),
+		book:           newAddrBook(),
+		addrBookFile:   AddrBookFile,
+		maxOutbound:    maxOutbound,
+		maxInbound:     DefaultMaxInbound,
+		rceThreshold:   ReportCenterThreshold,
+		protocol:       protocol,
+		halted:         make(chan struct{}),
+		logger:         logger,
 	}
 }
@@ -0,0 +1,154 @@
+package daemon
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/AlaricGilbert/argos-core/protocol/bitcoin"
+	"github.com/cloudwego/netpoll"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnifferConfigure(t *testing.T) {
+	s := NewSniffer(logrus.StandardLogger(), "bitcoin", 8)
+	assert.True(t, s.enabled(EstimatorFirstTimestamp))
+	assert.True(t, s.enabled(EstimatorReportCenter))
+
+	s.Configure([]string{EstimatorReportCenter}, map[string]float64{EstimatorReportCenter: 3}, 0)
+	assert.False(t, s.enabled(EstimatorFirstTimestamp))
+	assert.True(t, s.enabled(EstimatorReportCenter))
+	assert.Equal(t, 3, s.rceThreshold)
+	assert.Equal(t, DefaultMaxOutbound, s.maxOutbound)
+
+	// invalid thresholds fall back to the default one
+	s.Configure(nil, map[string]float64{EstimatorReportCenter: 1}, 16)
+	assert.True(t, s.enabled(EstimatorFirstTimestamp))
+	assert.Equal(t, ReportCenterThreshold, s.rceThreshold)
+	assert.Equal(t, 16, s.maxOutbound)
+}
+
+func TestSnifferDisabledEstimators(t *testing.T) {
+	s := NewSniffer(logrus.StandardLogger(), "bitcoin", 8)
+	s.Configure([]string{"none"}, map[string]float64{EstimatorReportCenter: 2}, 0)
+
+	// nothing is reported, and the transaction is ignored once the threshold is reached
+	var txid = [32]byte{1}
+	var notified, fte, rce = notifications.Count(), estimatorRuns[EstimatorFirstTimestamp].Count(), estimatorRuns[EstimatorReportCenter].Count()
+	for i := 1; i <= 2; i++ {
+		s.NotifyTransaction(argos.TransactionNotify{
+			Source:    net.TCPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 8333},
+			Timestamp: time.Now(),
+			TxID:      txid,
+		})
+	}
+	e, ok := s.notifies.Get(txid)
+	assert.True(t, ok)
+	assert.Nil(t, e.peers)
+	_, announcements := s.notifies.Len()
+	assert.Equal(t, 0, announcements)
+
+	// the announcements are counted while the disabled estimators are not run
+	assert.Equal(t, notified+2, notifications.Count())
+	assert.Equal(t, fte, estimatorRuns[EstimatorFirstTimestamp].Count())
+	assert.Equal(t, rce, estimatorRuns[EstimatorReportCenter].Count())
+}
+
+func TestSnifferPeerCount(t *testing.T) {
+	s := NewSniffer(logrus.StandardLogger(), "bitcoin", 8)
+	for i, direction := range []argos.Direction{argos.Outbound, argos.Outbound, argos.Inbound} {
+		address := net.TCPAddr{IP: net.IPv4(192, 0, 2, byte(i)), Port: 8333}
+		s.peers[newAddr(address)] = &peerInfo{address: address, direction: direction}
+	}
+	outbound, inbound := s.PeerCount()
+	assert.Equal(t, 2, outbound)
+	assert.Equal(t, 1, inbound)
+}
+
+func TestSnifferMaxInbound(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	s := NewSniffer(logrus.StandardLogger(), "bitcoin", 8)
+	s.maxInbound = 1
+	address := net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8333}
+	s.peers[newAddr(address)] = &peerInfo{address: address, direction: argos.Inbound}
+
+	// the connection past the limit is closed at once instead of being served
+	conn, err := netpoll.DialConnection("tcp", listener.Addr().String(), time.Second)
+	assert.Nil(t, err)
+	s.accept(conn)
+	assert.False(t, conn.IsActive())
+	assert.Equal(t, 1, len(s.peers))
+}
+
+func TestSnifferHaltWhileSpinning(t *testing.T) {
+	// Spin started concurrently with Halt either returns at once or is waited for by Halt
+	for i := 0; i < 20; i++ {
+		s := NewSniffer(logrus.StandardLogger(), "unknown", 8)
+		done := make(chan struct{})
+		go func() {
+			s.Spin(net.TCPAddr{})
+			close(done)
+		}()
+		s.Halt()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("sniffer kept spinning after halt")
+		}
+	}
+
+	// a halted sniffer neither spins nor connects
+	s := NewSniffer(logrus.StandardLogger(), "unknown", 8)
+	s.Halt()
+	s.Spin(net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8333})
+	s.Connect(net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 8333})
+	tried, fresh := s.book.Len()
+	assert.Equal(t, 0, tried+fresh)
+	assert.Empty(t, s.peers)
+}
+
+// failingResolver fails the test when a DNS seed is queried
+type failingResolver struct {
+	t *testing.T
+}
+
+func (r failingResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	r.t.Errorf("DNS seed %s queried", host)
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestSnifferSkipsSeeding(t *testing.T) {
+	assert.Nil(t, bitcoin.Init())
+	bitcoin.SetResolver(failingResolver{t})
+	defer bitcoin.SetResolver(net.DefaultResolver)
+
+	// the restored book could fill the outbound peers, so the sniffer starts without the DNS seeds
+	book := newAddrBook()
+	for port := 1; port <= 2; port++ {
+		book.Add(net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, 0, SourceSeed)
+	}
+	s := NewSniffer(logrus.StandardLogger(), "bitcoin", 2)
+	s.addrBookFile = filepath.Join(t.TempDir(), AddrBookFile)
+	assert.Nil(t, saveAddrRecords(s.addrBookFile, book.Records()))
+
+	done := make(chan struct{})
+	go func() {
+		s.Spin(net.TCPAddr{})
+		close(done)
+	}()
+	time.Sleep(200 * time.Millisecond)
+	s.Halt()
+	<-done
+
+	s.mu.Lock()
+	n, tried := s.book.Len()
+	s.mu.Unlock()
+	assert.Equal(t, 2, n+tried)
+}
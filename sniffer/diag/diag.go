@@ -0,0 +1,196 @@
+// Package diag implements the one-off diagnostics of the sniffer: probing a single node and crawling the
+// network from the seed nodes.
+package diag
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultProbeTimeout is how long a node is listened to by a probe
+	DefaultProbeTimeout = 10 * time.Second
+	// DefaultCrawlConcurrency is the number of nodes probed at the same time by a crawl
+	DefaultCrawlConcurrency = 64
+	// DefaultCrawlMaxNodes is the number of nodes probed by a crawl before it stops
+	DefaultCrawlMaxNodes = 1000
+)
+
+var errListenNotSupported = errors.New("diagnostic sniffer does not accept inbound connections")
+
+// ProbeResult is what a node told us while it was probed
+type ProbeResult struct {
+	Address net.TCPAddr
+	// Reachable is true when the node completed the handshake or shared addresses, a node accepting the
+	// connection without answering is not reachable
+	Reachable bool
+	// Err is the error the peer exited with before the timeout
+	Err error
+	// Addresses are the nodes shared by the node
+	Addresses []argos.NodeAddress
+	// Transactions is the number of transaction announcements received
+	Transactions int
+	Duration     time.Duration
+}
+
+// prober is the sniffer of a single probed peer, it only records what the peer reports
+type prober struct {
+	logger       *logrus.Logger
+	handshaked   bool
+	addresses    []argos.NodeAddress
+	transactions int
+	mu           sync.Mutex
+}
+
+func (p *prober) Logger() *logrus.Logger {
+	return p.logger
+}
+
+func (p *prober) NotifyTransaction(notify argos.TransactionNotify) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transactions++
+}
+
+func (p *prober) NotifyHandshake(address net.TCPAddr) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handshaked = true
+}
+
+func (p *prober) Connect(address net.TCPAddr) {}
+
+func (p *prober) Listen(address string) error {
+	return errListenNotSupported
+}
+
+func (p *prober) NodeConn(src net.TCPAddr, conn []argos.NodeAddress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.addresses = append(p.addresses, conn...)
+}
+
+func (p *prober) NodeExit(address net.TCPAddr) {}
+
+func (p *prober) Spin(node net.TCPAddr) {}
+
+func (p *prober) Halt() {}
+
+// Probe connects the node and listens to it until the timeout or the peer exits, the peers bound the time
+// spent on connecting so a probe takes about the timeout at most
+func Probe(protocol string, address net.TCPAddr, timeout time.Duration, logger *logrus.Logger) (*ProbeResult, error) {
+	p := &prober{logger: logger}
+	peer, err := argos.NewPeer(protocol, &address, p)
+	if err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- peer.Spin()
+	}()
+
+	result := &ProbeResult{Address: address}
+	select {
+	case result.Err = <-exited:
+	case <-time.After(timeout):
+		_ = peer.Halt()
+		<-exited
+	}
+	result.Duration = time.Since(started)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result.Addresses = p.addresses
+	result.Transactions = p.transactions
+	result.Reachable = p.handshaked || len(result.Addresses) > 0
+	return result, nil
+}
+
+// CrawlOptions control how far a crawl goes
+type CrawlOptions struct {
+	// Timeout is how long each node is probed
+	Timeout time.Duration
+	// Concurrency is the number of nodes probed at the same time
+	Concurrency int
+	// MaxNodes is the number of nodes probed before the crawl stops
+	MaxNodes int
+}
+
+// DefaultCrawlOptions returns the options of a crawl of the default size
+func DefaultCrawlOptions() CrawlOptions {
+	return CrawlOptions{
+		Timeout:     DefaultProbeTimeout,
+		Concurrency: DefaultCrawlConcurrency,
+		MaxNodes:    DefaultCrawlMaxNodes,
+	}
+}
+
+// Crawl probes the seed nodes and then the nodes they share breadth first, the reachable nodes are returned
+// in the order they were probed
+func Crawl(protocol string, seeds []net.TCPAddr, opts CrawlOptions, logger *logrus.Logger) ([]net.TCPAddr, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultCrawlConcurrency
+	}
+
+	var reachable []net.TCPAddr
+	var queue []net.TCPAddr
+	seen := make(map[string]struct{})
+	enqueue := func(address net.TCPAddr) {
+		if _, ok := seen[address.String()]; ok {
+			return
+		}
+		seen[address.String()] = struct{}{}
+		queue = append(queue, address)
+	}
+	for _, seed := range seeds {
+		enqueue(seed)
+	}
+
+	for probed := 0; len(queue) > 0 && probed < opts.MaxNodes; {
+		n := opts.Concurrency
+		if n > len(queue) {
+			n = len(queue)
+		}
+		if n > opts.MaxNodes-probed {
+			n = opts.MaxNodes - probed
+		}
+		batch := queue[:n]
+		queue = queue[n:]
+		probed += n
+
+		results := make([]*ProbeResult, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := range batch {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], errs[i] = Probe(protocol, batch[i], opts.Timeout, logger)
+			}(i)
+		}
+		wg.Wait()
+
+		for i, result := range results {
+			if errs[i] != nil {
+				return reachable, errs[i]
+			}
+			if !result.Reachable {
+				continue
+			}
+			reachable = append(reachable, result.Address)
+			for _, address := range result.Addresses {
+				enqueue(address.Address)
+			}
+		}
+		logger.WithField("probed", probed).WithField("reachable", len(reachable)).
+			WithField("queued", len(queue)).Info("crawl progress")
+	}
+	return reachable, nil
+}
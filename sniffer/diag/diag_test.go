@@ -0,0 +1,109 @@
+package diag
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// the nodes of the fake network, each node shares the nodes it links to, nodes without links accept the
+// connection but never complete the handshake and unknown nodes are unreachable
+var fakeNetwork = map[string][]string{
+	"10.0.0.1:1": {"10.0.0.2:1", "10.0.0.3:1"},
+	"10.0.0.2:1": {"10.0.0.1:1", "10.0.0.4:1", "10.0.0.6:1"},
+	"10.0.0.3:1": {},
+	"10.0.0.4:1": {"10.0.0.5:1"},
+	"10.0.0.6:1": nil,
+}
+
+type fakePeer struct {
+	s    argos.Sniffer
+	addr net.TCPAddr
+	halt chan struct{}
+}
+
+func (p *fakePeer) Spin() error {
+	links, ok := fakeNetwork[p.addr.String()]
+	if !ok {
+		return errors.New("connection refused")
+	}
+	if links == nil {
+		<-p.halt
+		return argos.ErrPeerNotRunning
+	}
+	p.s.(argos.HandshakeNotifier).NotifyHandshake(p.addr)
+	var addresses []argos.NodeAddress
+	for _, link := range links {
+		addr, _ := net.ResolveTCPAddr("tcp", link)
+		addresses = append(addresses, argos.NodeAddress{Address: *addr})
+	}
+	if len(addresses) > 0 {
+		p.s.NodeConn(p.addr, addresses)
+	}
+	p.s.NotifyTransaction(argos.TransactionNotify{})
+	<-p.halt
+	return argos.ErrPeerNotRunning
+}
+
+func (p *fakePeer) Halt() error {
+	close(p.halt)
+	return nil
+}
+
+func init() {
+	argos.RegisterPeerConstructor("diagtest", func(s argos.Sniffer, addr *net.TCPAddr) argos.Peer {
+		return &fakePeer{s: s, addr: *addr, halt: make(chan struct{})}
+	})
+}
+
+func addr(s string) net.TCPAddr {
+	a, _ := net.ResolveTCPAddr("tcp", s)
+	return *a
+}
+
+func TestProbe(t *testing.T) {
+	result, err := Probe("diagtest", addr("10.0.0.1:1"), 50*time.Millisecond, logrus.StandardLogger())
+	assert.Nil(t, err)
+	assert.True(t, result.Reachable)
+	assert.Nil(t, result.Err)
+	assert.Len(t, result.Addresses, 2)
+	assert.Equal(t, 1, result.Transactions)
+
+	result, err = Probe("diagtest", addr("10.0.0.9:1"), 50*time.Millisecond, logrus.StandardLogger())
+	assert.Nil(t, err)
+	assert.False(t, result.Reachable)
+	assert.NotNil(t, result.Err)
+
+	// a node accepting the connection without completing the handshake is not reachable
+	result, err = Probe("diagtest", addr("10.0.0.6:1"), 50*time.Millisecond, logrus.StandardLogger())
+	assert.Nil(t, err)
+	assert.False(t, result.Reachable)
+	assert.Nil(t, result.Err)
+
+	_, err = Probe("unknown", addr("10.0.0.1:1"), 50*time.Millisecond, logrus.StandardLogger())
+	assert.Equal(t, argos.ErrProtocolNotImplemented, err)
+}
+
+func TestCrawl(t *testing.T) {
+	opts := CrawlOptions{Timeout: 50 * time.Millisecond, Concurrency: 2, MaxNodes: 10}
+	nodes, err := Crawl("diagtest", []net.TCPAddr{addr("10.0.0.1:1")}, opts, logrus.StandardLogger())
+	assert.Nil(t, err)
+
+	var reached []string
+	for _, node := range nodes {
+		reached = append(reached, node.String())
+	}
+	// 10.0.0.5 and 10.0.0.6 are shared but unreachable
+	assert.Equal(t, []string{"10.0.0.1:1", "10.0.0.2:1", "10.0.0.3:1", "10.0.0.4:1"}, reached)
+
+	// the crawl stops after probing the max nodes
+	opts.MaxNodes = 1
+	nodes, err = Crawl("diagtest", []net.TCPAddr{addr("10.0.0.1:1")}, opts, logrus.StandardLogger())
+	assert.Nil(t, err)
+	assert.Len(t, nodes, 1)
+}
@@ -1,10 +1,221 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AlaricGilbert/argos-core/argos"
+	"github.com/AlaricGilbert/argos-core/protocol/bitcoin"
 	"github.com/AlaricGilbert/argos-core/sniffer/daemon"
+	"github.com/AlaricGilbert/argos-core/sniffer/diag"
+	"github.com/sirupsen/logrus"
 )
 
+const usage = `usage: sniffer <command> [flags]
+
+commands:
+  run                 run the sniffer daemon, the default command
+  init-config         write the default config file
+  crawl               crawl the network from the seed nodes and print the reachable nodes
+  probe <addr>        connect a node and print what it tells us
+  version             print the build version
+
+run "sniffer <command> -h" for the flags of a command
+`
+
+// command is a subcommand of the sniffer binary, it returns the exit code
+type command func(args []string) int
+
+var commands = map[string]command{
+	"run":         runCommand,
+	"init-config": initConfigCommand,
+	"crawl":       crawlCommand,
+	"probe":       probeCommand,
+	"version":     versionCommand,
+}
+
 func main() {
-	daemon.Init()
-	daemon.Instance().Spin()
+	name, args := "run", os.Args[1:]
+	// the daemon is run when no command is given, so the flags of run can be passed directly
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	os.Exit(cmd(args))
+}
+
+// newFlagSet creates the flag set of the command with the flags common to all commands
+func newFlagSet(name string, opts *daemon.Options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&opts.ConfigFile, "config", opts.ConfigFile, "path of the config file, the state files of the sniffer are kept next to it")
+	fs.StringVar(&opts.LogDir, "log-dir", opts.LogDir, "directory of the log files")
+	fs.StringVar(&opts.LogLevel, "log-level", opts.LogLevel, "lowest level logged, one of trace, debug, info, warn, error")
+	fs.StringVar(&opts.Protocol, "protocol", opts.Protocol, "protocol sniffed regardless of the one assigned by the master")
+	return fs
+}
+
+func runCommand(args []string) int {
+	opts := daemon.DefaultOptions()
+	_ = newFlagSet("run", &opts).Parse(args)
+
+	daemon.Init(opts)
+	return daemon.Instance().Spin()
+}
+
+func initConfigCommand(args []string) int {
+	opts := daemon.DefaultOptions()
+	fs := newFlagSet("init-config", &opts)
+	force := fs.Bool("force", false, "overwrite the config file when it exists")
+	_ = fs.Parse(args)
+
+	config, exist, err := daemon.LoadConfig(opts.ConfigFile)
+	if err != nil && !*force {
+		fmt.Fprintf(os.Stderr, "read config failed: %v\n", err)
+		return 1
+	}
+	if exist && !*force {
+		fmt.Fprintf(os.Stderr, "%s exists, use -force to overwrite it\n", opts.ConfigFile)
+		return 1
+	}
+	if err != nil || exist {
+		config = daemon.DefaultConfig()
+	}
+	if err = config.Save(opts.ConfigFile); err != nil {
+		fmt.Fprintf(os.Stderr, "write config failed: %v\n", err)
+		return 1
+	}
+	fmt.Println(opts.ConfigFile)
+	return 0
+}
+
+// diagnose prepares the protocols for a diagnostic command from the config file, the protocol diagnosed
+// and the logger are returned
+func diagnose(name string, opts daemon.Options) (string, *logrus.Logger, bool) {
+	logger, err := argos.NewLogger(opts.LogDir, "_"+name, opts.LogLevel)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logger init failed: %v\n", err)
+		return "", nil, false
+	}
+	argos.SetLogger(logger)
+
+	config, _, err := daemon.LoadConfig(opts.ConfigFile)
+	if err != nil {
+		logger.WithError(err).Error("read config failed")
+		return "", nil, false
+	}
+	if err = bitcoin.Init(); err != nil {
+		logger.WithError(err).Error("bitcoin init failed")
+		return "", nil, false
+	}
+	if err = bitcoin.SetOptions(config.Bitcoin); err != nil {
+		logger.WithError(err).Error("bitcoin options invalid")
+		return "", nil, false
+	}
+
+	protocol := opts.Protocol
+	if protocol == "" {
+		protocol = "bitcoin"
+	}
+	return protocol, logger, true
+}
+
+// defaultPort is the port of the nodes of the protocol when an address has none
+func defaultPort(protocol string) int {
+	switch protocol {
+	case "bitcoin":
+		return bitcoin.DefaultPort
+	}
+	return 0
+}
+
+func crawlCommand(args []string) int {
+	opts := daemon.DefaultOptions()
+	crawl := diag.DefaultCrawlOptions()
+	fs := newFlagSet("crawl", &opts)
+	fs.DurationVar(&crawl.Timeout, "timeout", crawl.Timeout, "how long each node is probed")
+	fs.IntVar(&crawl.Concurrency, "concurrency", crawl.Concurrency, "number of nodes probed at the same time")
+	fs.IntVar(&crawl.MaxNodes, "max-nodes", crawl.MaxNodes, "number of nodes probed before the crawl stops")
+	output := fs.String("output", "", "file the reachable nodes are written to, usable as the seed_file of the bitcoin options")
+	_ = fs.Parse(args)
+
+	protocol, logger, ok := diagnose("crawl", opts)
+	if !ok {
+		return 1
+	}
+	seeds, err := argos.GetSeedNodes(protocol)
+	if err != nil {
+		logger.WithError(err).Error("get seed nodes failed")
+		return 1
+	}
+	nodes, err := diag.Crawl(protocol, seeds, crawl, logger)
+	if err != nil {
+		logger.WithError(err).Error("crawl failed")
+		return 1
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			logger.WithError(err).Error("create output failed")
+			return 1
+		}
+		defer out.Close()
+	}
+	for _, node := range nodes {
+		fmt.Fprintln(out, node.String())
+	}
+	return 0
+}
+
+func probeCommand(args []string) int {
+	opts := daemon.DefaultOptions()
+	fs := newFlagSet("probe", &opts)
+	timeout := fs.Duration("timeout", diag.DefaultProbeTimeout, "how long the node is listened to")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: sniffer probe [flags] <addr>")
+		return 2
+	}
+
+	protocol, logger, ok := diagnose("probe", opts)
+	if !ok {
+		return 1
+	}
+	address, err := argos.ParseSeed(fs.Arg(0), defaultPort(protocol))
+	if err != nil {
+		logger.WithError(err).Error("invalid address")
+		return 2
+	}
+	result, err := diag.Probe(protocol, address, *timeout, logger)
+	if err != nil {
+		logger.WithError(err).Error("probe failed")
+		return 1
+	}
+
+	fmt.Printf("address:      %s\n", result.Address.String())
+	fmt.Printf("reachable:    %t\n", result.Reachable)
+	if result.Err != nil {
+		fmt.Printf("error:        %v\n", result.Err)
+	}
+	fmt.Printf("duration:     %s\n", result.Duration.Round(time.Millisecond))
+	fmt.Printf("transactions: %d\n", result.Transactions)
+	fmt.Printf("addresses:    %d\n", len(result.Addresses))
+	for _, node := range result.Addresses {
+		fmt.Printf("  %s\n", node.Address.String())
+	}
+	if !result.Reachable {
+		return 1
+	}
+	return 0
+}
+
+func versionCommand(args []string) int {
+	fmt.Println(argos.Version())
+	return 0
 }
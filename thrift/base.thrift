@@ -8,11 +8,15 @@ const i32 StatusInvalidArgument = 10001
 // Status Code 20000 to 20999 are reserved for master errors.
 const i32 StatusIdentifierConflict = 20001
 const i32 StatusTransactionConflict = 20002
+const i32 StatusUnauthenticated = 20003
+const i32 StatusInvalidSession = 20004
 
 const string MessageInternalError = "internal error"
 const string MessageInvalidArgument = "invalid argument"
 const string MessageIdentifierConflict = "another client with same identifier already connected"
 const string MessageTransactionConflict = "transaction with same txid already exists"
+const string MessageUnauthenticated = "request not authenticated"
+const string MessageInvalidSession = "session not issued to the sniffer"
 
 
 struct TcpAddress {
@@ -20,6 +24,14 @@ struct TcpAddress {
     2: i32 port
 }
 
+// Auth authenticates a request by the key shared by the sniffers and the master, mac is the HMAC-SHA256
+// of the request serialized with an empty mac
+struct Auth {
+    1: i64 timestamp
+    2: binary nonce
+    3: binary mac
+}
+
 struct ResponseStatus {
     1: i32 code
     2: string message
@@ -11,12 +11,41 @@ struct PingRequest {
 	1: string identifier
     2: i64 timestamp
     3: optional i64 deltaTime
+    4: optional i32 outboundPeers
+    5: optional i32 inboundPeers
+    // uptime is the seconds since the sniffer started
+    6: optional i64 uptime
+    // build describes the sniffer binary, e.g. its module version and vcs revision
+    7: optional string build
+    // session is the token issued to the sniffer process by the master, empty before the first ping
+    8: optional string session
+    9: optional base.Auth auth
+}
+
+// TaskConfig is the task assigned to the sniffers by identifier prefix, sniffers apply it
+// without restarting whenever its revision changes
+struct TaskConfig {
+    1: i64 revision
+    2: string protocol
+    3: string network
+    4: list<string> estimators
+    5: map<string, double> thresholds
+    6: i32 maxPeers
+    // feeFilter and fetchTransactions keep the sniffer settings when unset, 0 and false switch them off
+    7: optional i64 feeFilter
+    8: optional bool fetchTransactions
+    9: list<string> seeds
 }
 
 struct PingResponse {
     1: base.ResponseStatus status
     2: string   protocol
     3: TimeSync timeSync
+    4: optional TaskConfig task
+    // session is the token the sniffer should send in its later pings
+    5: optional string session
+    // auth signs the answers to authenticated pings with the nonce of the ping
+    6: optional base.Auth auth
 }
 
 struct ReportRequest {
@@ -24,13 +53,66 @@ struct ReportRequest {
     2: string method
     3: string protocol
     4: base.Transaction transaction
+    5: optional base.Auth auth
+    // uncertainty bounds the error of the transaction timestamp converted to the master clock, in nanoseconds
+    6: optional i64 uncertainty
+    // session is the session issued to the sniffer by ping, only checked by the report rpc since the reports
+    // of a batch are covered by the session of the batch
+    7: optional string session
+    // offered_txrcncl marks that the source sent sendtxrcncl, so it may announce fewer transactions by inv
+    // and its first-seen timings are less telling. Reconciliation itself is never negotiated by the sniffer.
+    8: optional bool offered_txrcncl
 }
 
 struct ReportResponse {
     1: base.ResponseStatus status
 }
 
+struct ReportBatchRequest {
+    1: string identifier
+    2: list<ReportRequest> reports
+    3: optional base.Auth auth
+    // session is the session issued to the sniffer by ping
+    4: optional string session
+}
+
+struct ReportBatchResponse {
+    1: base.ResponseStatus status
+    2: i32 accepted
+    // handled is the number of leading reports handled before the master failed with StatusInternalError,
+    // the others should be resent
+    3: optional i32 handled
+}
+
+// Observation is a peer announcing a transaction to a sniffer, sent in the raw observation mode
+struct Observation {
+    1: binary txid
+    2: binary ip
+    3: i32 port
+    // timestamp is converted to the master clock, uncertainty bounds its error, both in nanoseconds
+    4: i64 timestamp
+    5: i64 uncertainty
+    // offered_txrcncl marks that the source sent sendtxrcncl
+    6: optional bool offered_txrcncl
+}
+
+struct ObservationBatchRequest {
+    1: string identifier
+    2: string protocol
+    3: list<Observation> observations
+    4: optional base.Auth auth
+    // session is the session issued to the sniffer by ping
+    5: optional string session
+}
+
+struct ObservationBatchResponse {
+    1: base.ResponseStatus status
+    2: i32 accepted
+}
+
 service ArgosMaster {
     PingResponse ping(1: PingRequest req)
     ReportResponse report(1: ReportRequest req)
+    ReportBatchResponse reportBatch(1: ReportBatchRequest req)
+    ObservationBatchResponse reportObservations(1: ObservationBatchRequest req)
 }
\ No newline at end of file